@@ -0,0 +1,180 @@
+// Package activesessions provides HTTP handlers implementing a JSON API for
+// listing and revoking the sessions of the current user, suitable for backing
+// a "where you're logged in" account settings page.
+//
+// The handlers must be wrapped by the Manage middleware of the associated
+// Manager, whose SessionStore must implement store.UserIndex.
+//
+// Sessions are referred to by opaque handles (see Manager.SessionHandle)
+// rather than by SID, such that the API never exposes the latter. Revocation
+// requests are protected by the CSRF token of the current session, which must
+// be provided in the CSRFTokenHeader request header.
+package activesessions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	session "github.com/swfrench/simple-session"
+	"github.com/swfrench/simple-session/store"
+)
+
+// CSRFTokenHeader is the request header from which the CSRF token is read for
+// revocation requests.
+const CSRFTokenHeader = "X-CSRF-Token"
+
+// SessionInfo describes a single session in the response to a list request.
+type SessionInfo struct {
+	// Handle is the opaque handle used to refer to the session in revocation
+	// requests.
+	Handle     string    `json:"handle"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeen   time.Time `json:"last_seen"`
+	Expiration time.Time `json:"expiration"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	// Current is true if this is the session associated with the request.
+	Current bool `json:"current"`
+}
+
+// ListResponse is the response to a list request.
+type ListResponse struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// RevokeRequest is the body of a revocation request.
+type RevokeRequest struct {
+	// Handle is the handle of the session to revoke.
+	Handle string `json:"handle"`
+}
+
+// Handlers bundles the HTTP handlers for listing and revoking sessions.
+type Handlers[D any] struct {
+	m      *session.Manager[D]
+	userID func(*D) string
+}
+
+// New returns a new Handlers instance for sessions managed by the provided
+// Manager. The userID function returns the identifier of the user associated
+// with a given Data payload, and must be consistent with that used to
+// configure the user index of the SessionStore (e.g., see UserIDFunc).
+func New[D any](m *session.Manager[D], userID func(*D) string) *Handlers[D] {
+	return &Handlers[D]{m: m, userID: userID}
+}
+
+// ServeHTTP dispatches GET requests to List and POST requests to Revoke.
+func (h *Handlers[D]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.List(w, r)
+	case http.MethodPost:
+		h.Revoke(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.writeError(w, r, http.StatusMethodNotAllowed)
+	}
+}
+
+// current returns the session associated with the request and its user ID,
+// writing an error response and returning nil if the request is not
+// associated with an authenticated session.
+func (h *Handlers[D]) current(w http.ResponseWriter, r *http.Request) (*session.Session[D], string) {
+	s := session.Get[D](r.Context())
+	if s == nil || s.Data == nil {
+		h.writeError(w, r, http.StatusUnauthorized)
+		return nil, ""
+	}
+	uid := h.userID(s.Data)
+	if uid == "" {
+		h.writeError(w, r, http.StatusUnauthorized)
+		return nil, ""
+	}
+	return s, uid
+}
+
+// List responds with a ListResponse describing the sessions of the current
+// user, ordered by increasing expiration time.
+func (h *Handlers[D]) List(w http.ResponseWriter, r *http.Request) {
+	cs, uid := h.current(w, r)
+	if cs == nil {
+		return
+	}
+	ss, err := h.m.ListUserSessions(r.Context(), uid)
+	if err != nil {
+		h.m.Logger().ErrorContext(r.Context(), "Failed to list user sessions", "error", err)
+		h.writeError(w, r, http.StatusInternalServerError)
+		return
+	}
+	resp := ListResponse{Sessions: []SessionInfo{}}
+	for _, s := range ss {
+		resp.Sessions = append(resp.Sessions, SessionInfo{
+			Handle:     h.m.SessionHandle(s),
+			CreatedAt:  s.Metadata.CreatedAt,
+			LastSeen:   s.Metadata.LastSeen,
+			Expiration: s.Expiration,
+			UserAgent:  s.Metadata.UserAgent,
+			IP:         s.Metadata.IP,
+			Current:    s.ID == cs.ID,
+		})
+	}
+	h.writeJSON(w, r, http.StatusOK, &resp)
+}
+
+// Revoke revokes the session of the current user identified by the handle in
+// the RevokeRequest body, responding with 204 No Content on success. If the
+// revoked session is the current session, it is replaced with a new
+// pre-session (see Manager.Clear).
+func (h *Handlers[D]) Revoke(w http.ResponseWriter, r *http.Request) {
+	cs, uid := h.current(w, r)
+	if cs == nil {
+		return
+	}
+	if err := h.m.VerifySessionCSRFTokenContext(r.Context(), r.Header.Get(CSRFTokenHeader), cs); err != nil {
+		h.writeError(w, r, http.StatusForbidden)
+		return
+	}
+	var req RevokeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Handle == "" {
+		h.writeError(w, r, http.StatusBadRequest)
+		return
+	}
+	ss, err := h.m.ListUserSessions(r.Context(), uid)
+	if err != nil {
+		h.m.Logger().ErrorContext(r.Context(), "Failed to list user sessions", "error", err)
+		h.writeError(w, r, http.StatusInternalServerError)
+		return
+	}
+	for _, s := range ss {
+		if h.m.SessionHandle(s) != req.Handle {
+			continue
+		}
+		if s.ID == cs.ID {
+			_, err = h.m.Clear(r.Context(), w, s.ID)
+		} else if err = h.m.Destroy(r.Context(), s.ID); errors.Is(err, store.ErrSessionNotFound) {
+			// Revoked concurrently, which is fine.
+			err = nil
+		}
+		if err != nil {
+			h.m.Logger().ErrorContext(r.Context(), "Failed to revoke session", "error", err)
+			h.writeError(w, r, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.writeError(w, r, http.StatusNotFound)
+}
+
+func (h *Handlers[D]) writeJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.m.Logger().ErrorContext(r.Context(), "Failed to write response", "error", err)
+	}
+}
+
+func (h *Handlers[D]) writeError(w http.ResponseWriter, r *http.Request, code int) {
+	h.writeJSON(w, r, code, map[string]string{"error": http.StatusText(code)})
+}
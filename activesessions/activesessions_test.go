@@ -0,0 +1,219 @@
+package activesessions_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	session "github.com/swfrench/simple-session"
+	"github.com/swfrench/simple-session/activesessions"
+	"github.com/swfrench/simple-session/internal/testutil"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+type fakeSessionData struct {
+	User string `json:"user"`
+}
+
+func userID(d *fakeSessionData) string {
+	return d.User
+}
+
+// Secure must be false, as we do not configure TLS on our httptest.Server.
+func createNotSecureCookie(name, value string, expires time.Time) *http.Cookie {
+	base := session.CreateStrictCookie(name, value, expires)
+	base.Secure = false
+	return base
+}
+
+type testServer struct {
+	srv *httptest.Server
+}
+
+func mustCreateTestServer(t *testing.T) *testServer {
+	ms := memory.NewWithOptions(&memory.Options[session.Session[fakeSessionData]]{
+		UserID: session.UserIDFunc(userID),
+	})
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	sm, err := session.NewManager[fakeSessionData](ms, k, &session.Options{CreateCookie: createNotSecureCookie})
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	mux := http.NewServeMux()
+	// Logs in as the user named by the "user" query parameter, responding with
+	// the CSRF token of the new session.
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s, err := sm.Create(r.Context(), w, &fakeSessionData{User: r.URL.Query().Get("user")})
+		if err != nil {
+			t.Errorf("Create() returned unexpected error: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, s.CSRFToken)
+	})
+	mux.Handle("/sessions", activesessions.New(sm, userID))
+	return &testServer{srv: httptest.NewServer(sm.Manage(mux))}
+}
+
+func (ts *testServer) close() {
+	ts.srv.Close()
+}
+
+type testClient struct {
+	ts   *testServer
+	c    *http.Client
+	csrf string
+}
+
+func (ts *testServer) mustCreateClient(t *testing.T) *testClient {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New() returned unexpected error: %v", err)
+	}
+	return &testClient{ts: ts, c: &http.Client{Jar: jar}}
+}
+
+func (tc *testClient) do(t *testing.T, method, path string, body []byte, header http.Header) *http.Response {
+	r, err := http.NewRequest(method, tc.ts.srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest() returned unexpected error: %v", err)
+	}
+	r.Header.Set("User-Agent", "test-client")
+	for k, vs := range header {
+		r.Header[k] = vs
+	}
+	resp, err := tc.c.Do(r)
+	if err != nil {
+		t.Fatalf("Client.Do() returned unexpected error: %v", err)
+	}
+	return resp
+}
+
+func (tc *testClient) mustLogin(t *testing.T, user string) {
+	resp := tc.do(t, http.MethodGet, "/login?user="+user, nil, nil)
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read login response: %v", err)
+	}
+	tc.csrf = string(bs)
+}
+
+func (tc *testClient) mustList(t *testing.T) []activesessions.SessionInfo {
+	resp := tc.do(t, http.MethodGet, "/sessions", nil, nil)
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("List returned unexpected status - got: %d want: %d", got, want)
+	}
+	var lr activesessions.ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		t.Fatalf("Failed to decode list response: %v", err)
+	}
+	return lr.Sessions
+}
+
+func (tc *testClient) revoke(t *testing.T, handle, csrf string) int {
+	body, err := json.Marshal(&activesessions.RevokeRequest{Handle: handle})
+	if err != nil {
+		t.Fatalf("Failed to marshal revoke request: %v", err)
+	}
+	resp := tc.do(t, http.MethodPost, "/sessions", body, http.Header{activesessions.CSRFTokenHeader: {csrf}})
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestListAndRevoke(t *testing.T) {
+	ts := mustCreateTestServer(t)
+	defer ts.close()
+
+	a := ts.mustCreateClient(t)
+	a.mustLogin(t, "alice")
+	b := ts.mustCreateClient(t)
+	b.mustLogin(t, "alice")
+	c := ts.mustCreateClient(t)
+	c.mustLogin(t, "carol")
+
+	// Verify that alice's sessions are listed, with exactly one marked current,
+	// and that carol's are not.
+	ss := a.mustList(t)
+	if got, want := len(ss), 2; got != want {
+		t.Fatalf("List returned unexpected number of sessions - got: %d want: %d", got, want)
+	}
+	var current, other activesessions.SessionInfo
+	for _, s := range ss {
+		if s.Current {
+			current = s
+		} else {
+			other = s
+		}
+		if got, want := s.UserAgent, "test-client"; got != want {
+			t.Errorf("List returned unexpected user agent - got: %q want: %q", got, want)
+		}
+		if s.IP == "" || s.CreatedAt.IsZero() {
+			t.Errorf("List returned session with missing metadata: %+v", s)
+		}
+	}
+	if current.Handle == "" || other.Handle == "" || current.Handle == other.Handle {
+		t.Fatalf("List returned unexpected current / other sessions: %+v", ss)
+	}
+
+	// Verify that revocation requires a valid CSRF token.
+	if got, want := a.revoke(t, other.Handle, "nope"), http.StatusForbidden; got != want {
+		t.Errorf("Revoke without valid CSRF token returned unexpected status - got: %d want: %d", got, want)
+	}
+	// Verify that another user's sessions cannot be revoked.
+	if got, want := c.revoke(t, other.Handle, c.csrf), http.StatusNotFound; got != want {
+		t.Errorf("Revoke of another user's session returned unexpected status - got: %d want: %d", got, want)
+	}
+	if got, want := a.revoke(t, other.Handle, a.csrf), http.StatusNoContent; got != want {
+		t.Errorf("Revoke returned unexpected status - got: %d want: %d", got, want)
+	}
+
+	// Verify that the revoked session is gone, and that its client is now
+	// unauthenticated.
+	if ss := a.mustList(t); len(ss) != 1 || !ss[0].Current {
+		t.Errorf("List after revocation returned unexpected sessions: %+v", ss)
+	}
+	resp := b.do(t, http.MethodGet, "/sessions", nil, nil)
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("List from revoked session returned unexpected status - got: %d want: %d", got, want)
+	}
+}
+
+func TestRevokeCurrent(t *testing.T) {
+	ts := mustCreateTestServer(t)
+	defer ts.close()
+
+	a := ts.mustCreateClient(t)
+	a.mustLogin(t, "alice")
+	ss := a.mustList(t)
+	if got, want := len(ss), 1; got != want {
+		t.Fatalf("List returned unexpected number of sessions - got: %d want: %d", got, want)
+	}
+	if got, want := a.revoke(t, ss[0].Handle, a.csrf), http.StatusNoContent; got != want {
+		t.Errorf("Revoke returned unexpected status - got: %d want: %d", got, want)
+	}
+	resp := a.do(t, http.MethodGet, "/sessions", nil, nil)
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("List after revoking current session returned unexpected status - got: %d want: %d", got, want)
+	}
+}
+
+func TestUnauthenticated(t *testing.T) {
+	ts := mustCreateTestServer(t)
+	defer ts.close()
+
+	a := ts.mustCreateClient(t)
+	resp := a.do(t, http.MethodGet, "/sessions", nil, nil)
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("List from pre-session returned unexpected status - got: %d want: %d", got, want)
+	}
+}
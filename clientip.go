@@ -0,0 +1,99 @@
+package session
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RemoteAddrClientIP returns the IP address of the immediate peer of the
+// provided request (i.e., the host portion of RemoteAddr). This is the default
+// Options.ClientIP, and is appropriate when not serving behind a proxy.
+func RemoteAddrClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// TrustedProxyClientIP returns a function suitable for use as Options.ClientIP
+// when serving behind one or more reverse proxies, whose addresses fall within
+// the provided trusted prefixes.
+//
+// If the immediate peer is trusted, the client address chain is read from the
+// Forwarded header (RFC 7239) if present, otherwise from X-Forwarded-For, and
+// the rightmost address that is not trusted is returned (i.e., the address
+// reported by the outermost trusted proxy). Addresses to the left of it are
+// ignored, as they are supplied by the client and cannot be trusted. If the
+// immediate peer is not trusted, forwarding headers are ignored entirely.
+func TrustedProxyClientIP(trusted ...netip.Prefix) func(*http.Request) string {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) string {
+		peer := RemoteAddrClientIP(r)
+		addr, ok := parseForwardedAddr(peer)
+		if !ok || !isTrusted(addr) {
+			return peer
+		}
+		chain := forwardedFor(r.Header)
+		for i := len(chain) - 1; i >= 0; i-- {
+			next, ok := parseForwardedAddr(chain[i])
+			if !ok {
+				// Obfuscated or malformed, so we can go no further.
+				break
+			}
+			addr = next
+			if !isTrusted(addr) {
+				break
+			}
+		}
+		return addr.String()
+	}
+}
+
+// forwardedFor returns the chain of client addresses (leftmost being the
+// original client) from the Forwarded header if present, otherwise from
+// X-Forwarded-For.
+func forwardedFor(h http.Header) []string {
+	var chain []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, v := range values {
+			for _, elem := range strings.Split(v, ",") {
+				for _, pair := range strings.Split(elem, ";") {
+					key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(key, "for") {
+						chain = append(chain, val)
+					}
+				}
+			}
+		}
+		return chain
+	}
+	for _, v := range h.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(v, ",")...)
+	}
+	return chain
+}
+
+// parseForwardedAddr parses an IP address, optionally including a port and
+// enclosed in quotes or (for IPv6) brackets, as may be found in forwarding
+// headers.
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
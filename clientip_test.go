@@ -0,0 +1,87 @@
+package session_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	session "github.com/swfrench/simple-session"
+)
+
+func TestTrustedProxyClientIP(t *testing.T) {
+	clientIP := session.TrustedProxyClientIP(
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	)
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{
+			name:       "untrusted peer ignores headers",
+			remoteAddr: "203.0.113.7:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "x-forwarded-for skips trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.3", "10.0.0.2"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "x-forwarded-for ignores spoofed addresses",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": {"192.0.2.99, 198.51.100.1"}},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       {`for="[2001:db8:cafe::17]:4711";proto=https, For=10.0.0.2;by=10.0.0.1`},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: "2001:db8:cafe::17",
+		},
+		{
+			name:       "forwarded obfuscated",
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"Forwarded": {"for=_hidden, for=10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "all trusted",
+			remoteAddr: "[fd00::1]:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.2, fd00::2"}},
+			want:       "10.0.0.2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			r.Header = tc.header
+			if r.Header == nil {
+				r.Header = make(http.Header)
+			}
+			if got := clientIP(r); got != tc.want {
+				t.Errorf("ClientIP() returned unexpected address - got: %q want: %q", got, tc.want)
+			}
+		})
+	}
+}
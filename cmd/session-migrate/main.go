@@ -0,0 +1,142 @@
+// Command session-migrate copies live sessions from one Redis-backed
+// SessionStore to another (e.g., a different Redis instance or key prefix),
+// preserving their remaining TTLs.
+//
+// Session data is copied verbatim as JSON, and thus need not be known to the
+// command. As a result, the user associated with each session (see
+// redis.Options.UserID) must be identified by the path of a string field
+// within the session JSON (e.g., "data.user_id" for a session.Session whose
+// Data has a UserID field with JSON name "user_id"), via -user-id-field, in
+// order to rebuild the user session index in the destination. If the
+// destination already has a user session index, -user-id-field is required,
+// unless -skip-user-index is set.
+//
+// Usage:
+//
+//	session-migrate -src redis://old:6379/0 -dst redis://new:6379/0 [flags]
+//
+// To migrate sessions between other SessionStore implementations, see package
+// migrate.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/swfrench/simple-session/migrate"
+	"github.com/swfrench/simple-session/store/redis"
+)
+
+var (
+	srcURL        = flag.String("src", "", "URL of the source Redis instance (e.g., redis://host:6379/0)")
+	srcPrefix     = flag.String("src-prefix", "session", "key prefix used by the source store")
+	dstURL        = flag.String("dst", "", "URL of the destination Redis instance")
+	dstPrefix     = flag.String("dst-prefix", "session", "key prefix used by the destination store")
+	batchSize     = flag.Int("batch-size", 100, "number of sessions to scan per batch")
+	overwrite     = flag.Bool("overwrite", false, "replace sessions already present in the destination")
+	minTTL        = flag.Duration("min-ttl", 0, "skip sessions expiring sooner than this")
+	userIDField   = flag.String("user-id-field", "", "dot-separated path of the string field in the session JSON identifying the user, used to index sessions by user in the destination (e.g., data.user_id)")
+	skipUserIndex = flag.Bool("skip-user-index", false, "allow migrating to a destination with a user session index without -user-id-field, leaving migrated sessions unindexed")
+)
+
+// userIDFunc returns a function extracting the user ID from the string field
+// at the provided dot-separated path within session JSON, for use as
+// redis.Options.UserID. Sessions lacking such a field are not indexed.
+func userIDFunc(path string) func(*json.RawMessage) string {
+	fields := strings.Split(path, ".")
+	return func(s *json.RawMessage) string {
+		var v any
+		if err := json.Unmarshal(*s, &v); err != nil {
+			return ""
+		}
+		for _, f := range fields {
+			m, ok := v.(map[string]any)
+			if !ok {
+				return ""
+			}
+			v = m[f]
+		}
+		uid, _ := v.(string)
+		return uid
+	}
+}
+
+// hasUserIndex reports whether any user session index keys exist under the
+// provided prefix (see redis.Options.UserID).
+func hasUserIndex(ctx context.Context, rc *goredis.Client, prefix string) (bool, error) {
+	var cursor uint64
+	for {
+		keys, next, err := rc.Scan(ctx, cursor, prefix+"-users:*", 1000).Result()
+		if err != nil {
+			return false, err
+		}
+		if len(keys) > 0 {
+			return true, nil
+		}
+		if cursor = next; cursor == 0 {
+			return false, nil
+		}
+	}
+}
+
+func mustClient(url string) *goredis.Client {
+	opts, err := goredis.ParseURL(url)
+	if err != nil {
+		log.Fatalf("Invalid Redis URL %q: %v", url, err)
+	}
+	return goredis.NewClient(opts)
+}
+
+func main() {
+	flag.Parse()
+	if *srcURL == "" || *dstURL == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *srcURL == *dstURL && *srcPrefix == *dstPrefix {
+		log.Fatal("Source and destination stores are identical")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	src := mustClient(*srcURL)
+	defer src.Close()
+	dst := mustClient(*dstURL)
+	defer dst.Close()
+
+	dstOpts := &redis.Options[json.RawMessage]{}
+	if *userIDField != "" {
+		dstOpts.UserID = userIDFunc(*userIDField)
+	} else if !*skipUserIndex {
+		indexed, err := hasUserIndex(ctx, dst, *dstPrefix)
+		if err != nil {
+			log.Fatalf("Failed to check destination for user session index: %v", err)
+		}
+		if indexed {
+			log.Fatal("Destination has a user session index, which migrated sessions would be missing from: set -user-id-field to index them, or -skip-user-index to proceed regardless")
+		}
+	}
+
+	stats, err := migrate.Run[json.RawMessage](ctx,
+		redis.New[json.RawMessage](src, *srcPrefix),
+		redis.NewWithOptions(dst, *dstPrefix, dstOpts),
+		&migrate.Options{
+			BatchSize: *batchSize,
+			Overwrite: *overwrite,
+			MinTTL:    *minTTL,
+			OnProgress: func(s migrate.Stats) {
+				log.Printf("Progress: %+v", s)
+			},
+		})
+	if err != nil {
+		log.Fatalf("Migration failed after %+v: %v", stats, err)
+	}
+	log.Printf("Migration complete: %+v", stats)
+}
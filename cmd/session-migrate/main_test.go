@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/go-cmp/cmp"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/swfrench/simple-session/store/redis"
+)
+
+func TestUserIDFunc(t *testing.T) {
+	testCases := []struct {
+		name string
+		path string
+		data string
+		want string
+	}{
+		{name: "nested", path: "data.user_id", data: `{"id":"foo","data":{"user_id":"alice"}}`, want: "alice"},
+		{name: "top level", path: "uid", data: `{"uid":"alice"}`, want: "alice"},
+		{name: "missing", path: "data.user_id", data: `{"id":"foo","data":{}}`},
+		{name: "nil data", path: "data.user_id", data: `{"id":"foo","data":null}`},
+		{name: "not a string", path: "data.user_id", data: `{"data":{"user_id":42}}`},
+		{name: "invalid", path: "data.user_id", data: `invalid`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := json.RawMessage(tc.data)
+			if got := userIDFunc(tc.path)(&raw); got != tc.want {
+				t.Errorf("userIDFunc(%q) returned unexpected user ID - got: %q want: %q", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHasUserIndex(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rc := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	defer rc.Close()
+
+	unindexed := redis.New[json.RawMessage](rc, "session")
+	raw := json.RawMessage(`{"data":{"user_id":"alice"}}`)
+	if err := unindexed.Set(ctx, "foo", &raw, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	if indexed, err := hasUserIndex(ctx, rc, "session"); err != nil || indexed {
+		t.Errorf("hasUserIndex() returned unexpected result - got: (%t, %v) want: (false, <nil>)", indexed, err)
+	}
+
+	rs := redis.NewWithOptions(rc, "session", &redis.Options[json.RawMessage]{
+		UserID: userIDFunc("data.user_id"),
+	})
+	if err := rs.Set(ctx, "bar", &raw, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	if indexed, err := hasUserIndex(ctx, rc, "session"); err != nil || !indexed {
+		t.Errorf("hasUserIndex() returned unexpected result - got: (%t, %v) want: (true, <nil>)", indexed, err)
+	}
+	if indexed, err := hasUserIndex(ctx, rc, "other"); err != nil || indexed {
+		t.Errorf("hasUserIndex() returned unexpected result - got: (%t, %v) want: (false, <nil>)", indexed, err)
+	}
+	sids, err := rs.UserSessions(ctx, "alice")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"bar"}, sids); diff != "" {
+		t.Errorf("UserSessions() returned unexpected SIDs (-want +got):\n%s", diff)
+	}
+}
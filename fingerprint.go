@@ -0,0 +1,123 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// FingerprintAction determines how Manage responds when the fingerprint of the
+// client presenting a session does not match that recorded at creation.
+type FingerprintAction int
+
+const (
+	// FingerprintLogOnly logs the mismatch, but otherwise uses the session as
+	// normal.
+	FingerprintLogOnly FingerprintAction = iota
+	// FingerprintRequireReauth treats the request as though no session were
+	// present (i.e., a new pre-session is created for the client, requiring
+	// it to re-authenticate), while leaving the original session intact.
+	FingerprintRequireReauth
+	// FingerprintRevoke deletes the original session from the SessionStore and
+	// creates a new pre-session for the client.
+	FingerprintRevoke
+)
+
+func (a FingerprintAction) String() string {
+	switch a {
+	case FingerprintLogOnly:
+		return "log-only"
+	case FingerprintRequireReauth:
+		return "require-reauth"
+	case FingerprintRevoke:
+		return "revoke"
+	}
+	return "unknown"
+}
+
+// FingerprintPolicy configures binding of sessions to a fingerprint of the
+// client that created them, such that use of a stolen SID cookie from a
+// different client can be detected. Fingerprints are coarse by design (e.g.,
+// browser family rather than full User-Agent, and IP prefix rather than full
+// address), in order to tolerate benign changes such as browser updates.
+//
+// Note: Sessions created outside of a request handled by Manage (i.e., with a
+// Context not derived from the request Context) have no fingerprint, and are
+// never considered mismatched.
+type FingerprintPolicy struct {
+	// IPv4PrefixLen is the number of leading bits of the client IPv4 address
+	// (see Options.ClientIP) included in the fingerprint. Zero excludes IPv4
+	// addresses from the fingerprint.
+	IPv4PrefixLen int
+	// IPv6PrefixLen is the number of leading bits of the client IPv6 address
+	// included in the fingerprint. Zero excludes IPv6 addresses from the
+	// fingerprint.
+	IPv6PrefixLen int
+	// UserAgent includes the browser family derived from the User-Agent header
+	// (e.g., "firefox") in the fingerprint.
+	UserAgent bool
+	// ClientHints includes the low-entropy User-Agent Client Hints headers
+	// Sec-CH-UA-Platform and Sec-CH-UA-Mobile in the fingerprint.
+	ClientHints bool
+	// OnMismatch determines how Manage responds to a fingerprint mismatch.
+	// Default if unspecified: FingerprintLogOnly
+	OnMismatch FingerprintAction
+}
+
+// fingerprint computes the fingerprint of the client issuing the provided
+// request, whose address is ip.
+func (fp *FingerprintPolicy) fingerprint(r *http.Request, ip string) string {
+	var parts []string
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		bits := fp.IPv6PrefixLen
+		if addr.Is4() {
+			bits = fp.IPv4PrefixLen
+		}
+		if bits > 0 {
+			if p, err := addr.Prefix(bits); err == nil {
+				parts = append(parts, "ip="+p.String())
+			}
+		}
+	}
+	if fp.UserAgent {
+		parts = append(parts, "ua="+userAgentFamily(r.UserAgent()))
+	}
+	if fp.ClientHints {
+		parts = append(parts,
+			"platform="+r.Header.Get("Sec-CH-UA-Platform"),
+			"mobile="+r.Header.Get("Sec-CH-UA-Mobile"))
+	}
+	h := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(h[:16])
+}
+
+// userAgentFamilies maps User-Agent product tokens to browser families, in
+// order of precedence (e.g., Edge and Chrome both claim to be Safari).
+var userAgentFamilies = []struct {
+	token  string
+	family string
+}{
+	{"Edg/", "edge"},
+	{"OPR/", "opera"},
+	{"Firefox/", "firefox"},
+	{"FxiOS/", "firefox"},
+	{"Chrome/", "chrome"},
+	{"CriOS/", "chrome"},
+	{"Safari/", "safari"},
+}
+
+// userAgentFamily returns the browser family for the provided User-Agent, or
+// the leading product name for unrecognized agents.
+func userAgentFamily(ua string) string {
+	for _, f := range userAgentFamilies {
+		if strings.Contains(ua, f.token) {
+			return f.family
+		}
+	}
+	product, _, _ := strings.Cut(ua, "/")
+	return "other:" + strconv.Quote(strings.ToLower(strings.TrimSpace(product)))
+}
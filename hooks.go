@@ -0,0 +1,37 @@
+package session
+
+import "context"
+
+// Hooks represents user-supplied callbacks invoked by Manager on session
+// lifecycle events, e.g., to feed audit logs or security alerts. Each hook
+// receives the Context of the associated operation (i.e., the request Context
+// when invoked by Manage). Hooks are invoked synchronously, and thus should not
+// block. Any hook may be nil, in which case it is not invoked.
+type Hooks[D any] struct {
+	// OnCreate is invoked when a new session has been created and stored by
+	// Create (including pre-sessions created by Manage and Clear).
+	OnCreate func(ctx context.Context, s *Session[D])
+	// OnLoad is invoked when Manage has loaded the session associated with
+	// the request SID cookie, prior to passing it to the wrapped handler.
+	OnLoad func(ctx context.Context, s *Session[D])
+	// OnRotate is invoked when Rotate has replaced the session old with the
+	// session new.
+	OnRotate func(ctx context.Context, old, new *Session[D])
+	// OnExtend is invoked when Extend has extended the expiration of the
+	// provided session.
+	OnExtend func(ctx context.Context, s *Session[D])
+	// OnDestroy is invoked when a session has been deleted from the
+	// SessionStore by Clear, Destroy, RevokeUserSessions, or in response to a
+	// fingerprint mismatch. If the stored session could not be read prior to
+	// deletion, only its ID is populated.
+	OnDestroy func(ctx context.Context, s *Session[D])
+	// OnExpired is invoked when a session found in the SessionStore is
+	// determined to have expired.
+	OnExpired func(ctx context.Context, s *Session[D])
+	// OnInvalidCookie is invoked when the request SID cookie fails
+	// authentication, with the associated error.
+	OnInvalidCookie func(ctx context.Context, err error)
+	// OnCSRFFailure is invoked when a CSRF token fails verification against
+	// the provided session, with the associated error.
+	OnCSRFFailure func(ctx context.Context, s *Session[D], err error)
+}
@@ -0,0 +1,239 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	session "github.com/swfrench/simple-session"
+	"github.com/swfrench/simple-session/store"
+)
+
+type hookRecorder struct {
+	events []string
+}
+
+func (hr *hookRecorder) record(event string) {
+	hr.events = append(hr.events, event)
+}
+
+func (hr *hookRecorder) take() []string {
+	events := hr.events
+	hr.events = nil
+	return events
+}
+
+func (hr *hookRecorder) hooks() session.Hooks[fakeSessionData] {
+	type sess = session.Session[fakeSessionData]
+	return session.Hooks[fakeSessionData]{
+		OnCreate:        func(context.Context, *sess) { hr.record("create") },
+		OnLoad:          func(context.Context, *sess) { hr.record("load") },
+		OnRotate:        func(context.Context, *sess, *sess) { hr.record("rotate") },
+		OnExtend:        func(context.Context, *sess) { hr.record("extend") },
+		OnDestroy:       func(context.Context, *sess) { hr.record("destroy") },
+		OnExpired:       func(context.Context, *sess) { hr.record("expired") },
+		OnInvalidCookie: func(context.Context, error) { hr.record("invalid-cookie") },
+		OnCSRFFailure:   func(context.Context, *sess, error) { hr.record("csrf-failure") },
+	}
+}
+
+func TestHooks(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	now := time.Now()
+	sr.sm.Clock = func() time.Time { return now }
+	hr := new(hookRecorder)
+	sr.sm.Hooks = hr.hooks()
+
+	testCases := []struct {
+		name    string
+		arrange func()
+		handler http.HandlerFunc
+		want    []string
+	}{
+		{
+			name: "create",
+			want: []string{"create"},
+		},
+		{
+			name: "load",
+			want: []string{"load"},
+		},
+		{
+			name: "rotate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				s := session.Get[fakeSessionData](r.Context())
+				if _, err := sr.sm.Rotate(r.Context(), w, s); err != nil {
+					t.Errorf("Rotate() returned unexpected error: %v", err)
+				}
+			},
+			want: []string{"load", "create", "destroy", "rotate"},
+		},
+		{
+			name: "extend",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				s := session.Get[fakeSessionData](r.Context())
+				if _, err := sr.sm.Extend(r.Context(), w, s); err != nil {
+					t.Errorf("Extend() returned unexpected error: %v", err)
+				}
+			},
+			want: []string{"load", "extend"},
+		},
+		{
+			name: "csrf failure",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				s := session.Get[fakeSessionData](r.Context())
+				if err := sr.sm.VerifySessionCSRFTokenContext(r.Context(), "nope", s); err == nil {
+					t.Error("VerifySessionCSRFTokenContext() unexpectedly succeeded")
+				}
+			},
+			want: []string{"load", "csrf-failure"},
+		},
+		{
+			name: "clear",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				s := session.Get[fakeSessionData](r.Context())
+				if _, err := sr.sm.Clear(r.Context(), w, s.ID); err != nil {
+					t.Errorf("Clear() returned unexpected error: %v", err)
+				}
+			},
+			want: []string{"load", "create", "destroy"},
+		},
+		{
+			name: "expired",
+			arrange: func() {
+				sr.sm.Clock = func() time.Time { return now.Add(time.Hour) }
+			},
+			want: []string{"expired", "create"},
+		},
+		{
+			name: "invalid cookie",
+			arrange: func() {
+				c := sessionOptions().CreateCookie("session", "nope", time.Now().Add(time.Hour))
+				sr.jar.SetCookies(sr.srvURL, []*http.Cookie{c})
+			},
+			want: []string{"invalid-cookie", "create"},
+		},
+	}
+	for _, tc := range testCases {
+		if tc.arrange != nil {
+			tc.arrange()
+		}
+		sr.run(t, tc.handler)
+		if diff := cmp.Diff(tc.want, hr.take()); diff != "" {
+			t.Errorf("Unexpected hook invocations for %s (+got, -want):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestExtend(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	now := time.Now()
+	sr.sm.Clock = func() time.Time { return now }
+	sr.run(t, nil)
+	sid := sr.ctxSession.ID
+
+	// Extend the session just prior to its expiration, and verify that it
+	// remains valid past the original expiration.
+	sr.sm.Clock = func() time.Time { return now.Add(25 * time.Minute) }
+	sr.run(t, func(w http.ResponseWriter, r *http.Request) {
+		s := session.Get[fakeSessionData](r.Context())
+		if _, err := sr.sm.Extend(r.Context(), w, s); err != nil {
+			t.Errorf("Extend() returned unexpected error: %v", err)
+		}
+	})
+	sr.sm.Clock = func() time.Time { return now.Add(45 * time.Minute) }
+	sr.run(t, nil)
+	if got, want := sr.ctxSession.ID, sid; got != want {
+		t.Errorf("Unexpected session ID after extension - got: %q want: %q", got, want)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	sr.run(t, nil)
+	old := sr.ctxSession
+
+	var rotated *session.Session[fakeSessionData]
+	sr.run(t, func(w http.ResponseWriter, r *http.Request) {
+		s := session.Get[fakeSessionData](r.Context())
+		var err error
+		if rotated, err = sr.sm.Rotate(r.Context(), w, s); err != nil {
+			t.Errorf("Rotate() returned unexpected error: %v", err)
+		}
+	})
+	if rotated == nil {
+		t.Fatal("Rotate() did not return a session")
+	}
+	if rotated.ID == old.ID || rotated.CSRFToken == old.CSRFToken {
+		t.Errorf("Rotate() did not produce new identifiers - got: %+v", rotated)
+	}
+	if got, want := sr.getSessionCookie().Value, rotated.ID; got != want {
+		t.Errorf("Unexpected session cookie after rotation - got: %q want: %q", got, want)
+	}
+	if _, err := sr.mem.Get(context.Background(), old.ID); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Error("Rotated session unexpectedly remains in store")
+	}
+}
+
+func TestRotateAtUserSessionLimit(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy session.UserSessionLimitPolicy
+	}{
+		{
+			name:   "reject",
+			policy: session.RejectNewSession,
+		},
+		{
+			name:   "evict oldest",
+			policy: session.EvictOldestSession,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := sessionOptions()
+			opts.MaxUserSessions = 2
+			opts.UserSessionLimitPolicy = tc.policy
+			sm := mustCreateIndexedManager(t, opts)
+			ctx := context.Background()
+
+			var ss []*session.Session[fakeSessionData]
+			for i := 0; i < 2; i++ {
+				s, err := sm.Create(ctx, httptest.NewRecorder(), &fakeSessionData{Greeting: "hola"})
+				if err != nil {
+					t.Fatalf("Create() returned unexpected error: %v", err)
+				}
+				ss = append(ss, s)
+			}
+
+			rotated, err := sm.Rotate(ctx, httptest.NewRecorder(), ss[1])
+			if err != nil {
+				t.Fatalf("Rotate() returned unexpected error: %v", err)
+			}
+
+			// Verify that only the rotated session was replaced.
+			got := make(map[string]bool)
+			us, err := sm.ListUserSessions(ctx, "hola")
+			if err != nil {
+				t.Fatalf("ListUserSessions() returned unexpected error: %v", err)
+			}
+			for _, s := range us {
+				got[s.ID] = true
+			}
+			want := map[string]bool{ss[0].ID: true, rotated.ID: true}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("ListUserSessions() returned unexpected sessions (+got, -want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"expvar"
+	"strings"
+	"time"
+)
+
+// Expvar is a Recorder publishing measurements via the expvar package, as a
+// map with the following entries:
+//
+//   - "create": map from Create outcome to call count.
+//   - "create_attempts": map from Create outcome to total attempt count.
+//   - "lookup": map from lookup outcome to count.
+//   - "csrf_failures": count of CSRF token verification failures.
+//   - "store_ops": map from "<name>.<op>.<class>" to call count.
+//   - "store_latency_seconds": map from "<name>.<op>.<class>" to total latency.
+type Expvar struct {
+	m              *expvar.Map
+	create         *expvar.Map
+	createAttempts *expvar.Map
+	lookup         *expvar.Map
+	csrfFailures   *expvar.Int
+	storeOps       *expvar.Map
+	storeLatency   *expvar.Map
+}
+
+// NewExpvar returns a new Expvar, published under the provided name. As with
+// expvar.Publish, NewExpvar panics if the name is already in use.
+func NewExpvar(name string) *Expvar {
+	e := &Expvar{
+		m:              expvar.NewMap(name),
+		create:         new(expvar.Map),
+		createAttempts: new(expvar.Map),
+		lookup:         new(expvar.Map),
+		csrfFailures:   new(expvar.Int),
+		storeOps:       new(expvar.Map),
+		storeLatency:   new(expvar.Map),
+	}
+	e.m.Set("create", e.create)
+	e.m.Set("create_attempts", e.createAttempts)
+	e.m.Set("lookup", e.lookup)
+	e.m.Set("csrf_failures", e.csrfFailures)
+	e.m.Set("store_ops", e.storeOps)
+	e.m.Set("store_latency_seconds", e.storeLatency)
+	return e
+}
+
+// Map returns the published expvar.Map.
+func (e *Expvar) Map() *expvar.Map {
+	return e.m
+}
+
+// ObserveCreate implements Recorder.
+func (e *Expvar) ObserveCreate(outcome string, attempts int) {
+	e.create.Add(outcome, 1)
+	e.createAttempts.Add(outcome, int64(attempts))
+}
+
+// ObserveLookup implements Recorder.
+func (e *Expvar) ObserveLookup(outcome string) {
+	e.lookup.Add(outcome, 1)
+}
+
+// ObserveCSRFFailure implements Recorder.
+func (e *Expvar) ObserveCSRFFailure() {
+	e.csrfFailures.Add(1)
+}
+
+// ObserveStoreOp implements Recorder.
+func (e *Expvar) ObserveStoreOp(name, op, class string, latency time.Duration) {
+	key := strings.Join([]string{name, op, class}, ".")
+	e.storeOps.Add(key, 1)
+	e.storeLatency.AddFloat(key, latency.Seconds())
+}
@@ -0,0 +1,140 @@
+// Package metrics provides instrumentation of session operations, for use with
+// Manager (see session.Options.Metrics) and SessionStore implementations (see
+// Store).
+//
+// Measurements are delivered to a Recorder. This package provides a
+// dependency-free Recorder publishing to expvar (see Expvar), while the
+// prometheus subpackage provides one backed by a Prometheus registry.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+// Outcomes of Manager.Create, as provided to Recorder.ObserveCreate.
+const (
+	// CreateOK indicates that the session was created.
+	CreateOK = "ok"
+	// CreateLimited indicates that the session was rejected due to the user
+	// session limit.
+	CreateLimited = "limited"
+	// CreateError indicates that the session could not be created.
+	CreateError = "error"
+)
+
+// Outcomes of Manager.Clear, as recorded on its trace span (see
+// tracing.OutcomeKey).
+const (
+	// ClearOK indicates that the new pre-session was created, regardless of
+	// whether the prior session was deleted.
+	ClearOK = "ok"
+	// ClearError indicates that the new pre-session could not be created.
+	ClearError = "error"
+)
+
+// Outcomes of session lookup by Manager.Manage, as provided to
+// Recorder.ObserveLookup.
+const (
+	// LookupHit indicates that the session was found and used.
+	LookupHit = "hit"
+	// LookupNoCookie indicates that the request carried no SID cookie.
+	LookupNoCookie = "no_cookie"
+	// LookupInvalidCookie indicates that the SID cookie failed
+	// authentication.
+	LookupInvalidCookie = "invalid_cookie"
+	// LookupMiss indicates that no stored session was found for the SID.
+	LookupMiss = "miss"
+	// LookupExpired indicates that the stored session had expired.
+	LookupExpired = "expired"
+	// LookupMismatch indicates that the session was rejected due to a
+	// fingerprint mismatch.
+	LookupMismatch = "fingerprint_mismatch"
+	// LookupError indicates that the SessionStore returned an unexpected
+	// error.
+	LookupError = "error"
+)
+
+// Error classes, as provided to Recorder.ObserveStoreOp (see ErrorClass).
+const (
+	ClassOK                = "ok"
+	ClassNotFound          = "not_found"
+	ClassExists            = "exists"
+	ClassInvalidData       = "invalid_data"
+	ClassInvalidStoredData = "invalid_stored_data"
+	ClassUserSessionLimit  = "user_session_limit"
+	ClassUnsupported       = "unsupported"
+	ClassRedisClient       = "redis_client" // see redis.ErrRedisClient
+	ClassBreakerOpen       = "breaker_open" // see breaker.ErrOpen
+	ClassCanceled          = "canceled"
+	ClassDeadlineExceeded  = "deadline_exceeded"
+	ClassOther             = "other"
+)
+
+// Recorder receives measurements of session operations. Implementations must
+// be safe for concurrent use.
+type Recorder interface {
+	// ObserveCreate records a call to Manager.Create with the provided outcome
+	// (e.g., CreateOK), after the provided number of attempts.
+	ObserveCreate(outcome string, attempts int)
+	// ObserveLookup records the outcome (e.g., LookupHit) of looking up the
+	// session associated with a request in Manager.Manage.
+	ObserveLookup(outcome string)
+	// ObserveCSRFFailure records a CSRF token verification failure.
+	ObserveCSRFFailure()
+	// ObserveStoreOp records a call to the provided operation (e.g.,
+	// store.OpGet) of the named SessionStore, which completed with the
+	// provided error class (see ErrorClass) after the provided latency.
+	ObserveStoreOp(name, op, class string, latency time.Duration)
+}
+
+// Nop is a Recorder that discards all measurements.
+type Nop struct{}
+
+func (Nop) ObserveCreate(string, int)                            {}
+func (Nop) ObserveLookup(string)                                 {}
+func (Nop) ObserveCSRFFailure()                                  {}
+func (Nop) ObserveStoreOp(string, string, string, time.Duration) {}
+
+// Classifier may be implemented by errors returned by SessionStore
+// implementations in order to report their class to ErrorClass (e.g.,
+// redis.ErrRedisClient reports ClassRedisClient).
+type Classifier interface {
+	// ErrorClass returns the class of the error.
+	ErrorClass() string
+}
+
+// ErrorClass returns the class of the provided error returned by a
+// SessionStore operation, suitable for use as a low-cardinality metric label.
+// Errors not matching any of the store sentinel errors are classified by the
+// first Classifier in their chain, if any.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ClassOK
+	case errors.Is(err, store.ErrSessionNotFound):
+		return ClassNotFound
+	case errors.Is(err, store.ErrSessionExists):
+		return ClassExists
+	case errors.Is(err, store.ErrInvalidSessionData):
+		return ClassInvalidData
+	case errors.Is(err, store.ErrInvalidStoredSessionData):
+		return ClassInvalidStoredData
+	case errors.Is(err, store.ErrUserSessionLimit):
+		return ClassUserSessionLimit
+	case errors.Is(err, errors.ErrUnsupported):
+		return ClassUnsupported
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassDeadlineExceeded
+	}
+	var c Classifier
+	if errors.As(err, &c) {
+		return c.ErrorClass()
+	}
+	return ClassOther
+}
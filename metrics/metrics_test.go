@@ -0,0 +1,139 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/metrics"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/breaker"
+	"github.com/swfrench/simple-session/store/memory"
+	"github.com/swfrench/simple-session/store/redis"
+)
+
+type fakeRecorder struct {
+	metrics.Nop
+	ops []string
+}
+
+func (fr *fakeRecorder) ObserveStoreOp(name, op, class string, latency time.Duration) {
+	fr.ops = append(fr.ops, fmt.Sprintf("%s.%s.%s:%v", name, op, class, latency))
+}
+
+func TestErrorClass(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: metrics.ClassOK},
+		{err: fmt.Errorf("wrapped: %w", store.ErrSessionNotFound), want: metrics.ClassNotFound},
+		{err: store.ErrSessionExists, want: metrics.ClassExists},
+		{err: store.ErrInvalidSessionData, want: metrics.ClassInvalidData},
+		{err: store.ErrInvalidStoredSessionData, want: metrics.ClassInvalidStoredData},
+		{err: store.ErrUserSessionLimit, want: metrics.ClassUserSessionLimit},
+		{err: errors.ErrUnsupported, want: metrics.ClassUnsupported},
+		{err: fmt.Errorf("%w: %w", redis.ErrRedisClient, context.DeadlineExceeded), want: metrics.ClassDeadlineExceeded},
+		{err: fmt.Errorf("%w: %w", redis.ErrRedisClient, errors.New("i/o timeout")), want: metrics.ClassRedisClient},
+		{err: store.MarkPermanent(fmt.Errorf("unavailable: %w", breaker.ErrOpen)), want: metrics.ClassBreakerOpen},
+		{err: errors.New("nope"), want: metrics.ClassOther},
+	}
+	for _, tc := range testCases {
+		if got := metrics.ErrorClass(tc.err); got != tc.want {
+			t.Errorf("ErrorClass(%v) returned unexpected class - got: %q want: %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+type fakeSession struct {
+	Name string
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	rec := new(fakeRecorder)
+	ms := metrics.NewStore[fakeSession](memory.New[fakeSession](), "memory", rec)
+	now := time.Now()
+	ms.Clock = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+
+	if err := ms.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Minute); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	if err := ms.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Minute); !errors.Is(err, store.ErrSessionExists) {
+		t.Fatalf("Set() returned unexpected error - got: %v want: %v", err, store.ErrSessionExists)
+	}
+	if _, err := ms.Get(ctx, "foo"); err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if err := ms.Update(ctx, "foo", &fakeSession{Name: "bar"}, time.Minute); err != nil {
+		t.Fatalf("Update() returned unexpected error: %v", err)
+	}
+	if err := ms.Del(ctx, "foo"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	if _, err := ms.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Fatalf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	// The memory Store is not configured with a user index, and returns an
+	// error when SetLimited is called.
+	if _, err := ms.SetLimited(ctx, "bar", &fakeSession{Name: "bar"}, time.Minute, 1, false); err == nil {
+		t.Fatal("SetLimited() unexpectedly succeeded")
+	}
+
+	want := []string{
+		"memory.set.ok:1ms",
+		"memory.set.exists:1ms",
+		"memory.get.ok:1ms",
+		"memory.update.ok:1ms",
+		"memory.del.ok:1ms",
+		"memory.get.not_found:1ms",
+		"memory.set_limited.other:1ms",
+	}
+	if diff := cmp.Diff(want, rec.ops); diff != "" {
+		t.Errorf("Unexpected store operations recorded (+got, -want):\n%s", diff)
+	}
+}
+
+// basicStore implements only the required methods of store.SessionStore.
+type basicStore struct {
+	store.SessionStore[fakeSession]
+}
+
+func TestStoreUnsupported(t *testing.T) {
+	ctx := context.Background()
+	rec := new(fakeRecorder)
+	ms := metrics.NewStore[fakeSession](basicStore{memory.New[fakeSession]()}, "basic", rec)
+	if err := ms.Update(ctx, "foo", &fakeSession{}, time.Minute); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Update() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	if _, err := ms.SetLimited(ctx, "foo", &fakeSession{}, time.Minute, 1, false); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetLimited() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	if _, err := ms.UserSessions(ctx, "foo"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("UserSessions() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	if len(rec.ops) != 0 {
+		t.Errorf("Unexpected store operations recorded: %v", rec.ops)
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	e := metrics.NewExpvar("test_expvar")
+	e.ObserveCreate(metrics.CreateOK, 1)
+	e.ObserveCreate(metrics.CreateOK, 2)
+	e.ObserveLookup(metrics.LookupHit)
+	e.ObserveCSRFFailure()
+	e.ObserveStoreOp("memory", store.OpGet, metrics.ClassOK, 500*time.Millisecond)
+	e.ObserveStoreOp("memory", store.OpGet, metrics.ClassOK, 250*time.Millisecond)
+
+	want := `{"create": {"ok": 2}, "create_attempts": {"ok": 3}, "csrf_failures": 1, "lookup": {"hit": 1}, "store_latency_seconds": {"memory.get.ok": 0.75}, "store_ops": {"memory.get.ok": 2}}`
+	if diff := cmp.Diff(want, e.Map().String()); diff != "" {
+		t.Errorf("Unexpected expvar contents (+got, -want):\n%s", diff)
+	}
+}
@@ -0,0 +1,89 @@
+// Package prometheus provides a metrics.Recorder backed by a Prometheus
+// registry.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder is a metrics.Recorder exporting the following Prometheus metrics,
+// with the configured namespace prefix:
+//
+//   - session_creates_total{outcome}: calls to Manager.Create.
+//   - session_create_attempts: histogram of attempts per call to
+//     Manager.Create, by outcome.
+//   - session_lookups_total{outcome}: session lookups by Manager.Manage.
+//   - session_csrf_failures_total: CSRF token verification failures.
+//   - session_store_op_duration_seconds{store,op,class}: histogram of
+//     SessionStore operation latency, by error class.
+type Recorder struct {
+	creates        *prometheus.CounterVec
+	createAttempts *prometheus.HistogramVec
+	lookups        *prometheus.CounterVec
+	csrfFailures   prometheus.Counter
+	storeOps       *prometheus.HistogramVec
+}
+
+// New returns a new Recorder, registering its metrics with the provided
+// Registerer (e.g., prometheus.DefaultRegisterer) under the provided namespace
+// (which may be empty).
+func New(reg prometheus.Registerer, namespace string) (*Recorder, error) {
+	r := &Recorder{
+		creates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "session_creates_total",
+			Help:      "Calls to Manager.Create, by outcome.",
+		}, []string{"outcome"}),
+		createAttempts: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "session_create_attempts",
+			Help:      "Attempts per call to Manager.Create, by outcome.",
+			Buckets:   []float64{1, 2, 3, 4, 6, 8},
+		}, []string{"outcome"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "session_lookups_total",
+			Help:      "Session lookups by Manager.Manage, by outcome.",
+		}, []string{"outcome"}),
+		csrfFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "session_csrf_failures_total",
+			Help:      "CSRF token verification failures.",
+		}),
+		storeOps: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "session_store_op_duration_seconds",
+			Help:      "SessionStore operation latency, by store, operation, and error class.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"store", "op", "class"}),
+	}
+	for _, c := range []prometheus.Collector{r.creates, r.createAttempts, r.lookups, r.csrfFailures, r.storeOps} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// ObserveCreate implements metrics.Recorder.
+func (r *Recorder) ObserveCreate(outcome string, attempts int) {
+	r.creates.WithLabelValues(outcome).Inc()
+	r.createAttempts.WithLabelValues(outcome).Observe(float64(attempts))
+}
+
+// ObserveLookup implements metrics.Recorder.
+func (r *Recorder) ObserveLookup(outcome string) {
+	r.lookups.WithLabelValues(outcome).Inc()
+}
+
+// ObserveCSRFFailure implements metrics.Recorder.
+func (r *Recorder) ObserveCSRFFailure() {
+	r.csrfFailures.Inc()
+}
+
+// ObserveStoreOp implements metrics.Recorder.
+func (r *Recorder) ObserveStoreOp(name, op, class string, latency time.Duration) {
+	r.storeOps.WithLabelValues(name, op, class).Observe(latency.Seconds())
+}
@@ -0,0 +1,54 @@
+package prometheus_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/swfrench/simple-session/metrics"
+	sessionprom "github.com/swfrench/simple-session/metrics/prometheus"
+	"github.com/swfrench/simple-session/store"
+)
+
+func TestRecorder(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	r, err := sessionprom.New(reg, "test")
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	r.ObserveCreate(metrics.CreateOK, 1)
+	r.ObserveCreate(metrics.CreateError, 4)
+	r.ObserveLookup(metrics.LookupHit)
+	r.ObserveLookup(metrics.LookupHit)
+	r.ObserveLookup(metrics.LookupExpired)
+	r.ObserveCSRFFailure()
+	r.ObserveStoreOp("redis", store.OpGet, metrics.ClassOK, time.Millisecond)
+
+	want := `
+# HELP test_session_lookups_total Session lookups by Manager.Manage, by outcome.
+# TYPE test_session_lookups_total counter
+test_session_lookups_total{outcome="expired"} 1
+test_session_lookups_total{outcome="hit"} 2
+# HELP test_session_creates_total Calls to Manager.Create, by outcome.
+# TYPE test_session_creates_total counter
+test_session_creates_total{outcome="error"} 1
+test_session_creates_total{outcome="ok"} 1
+# HELP test_session_csrf_failures_total CSRF token verification failures.
+# TYPE test_session_csrf_failures_total counter
+test_session_csrf_failures_total 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"test_session_lookups_total", "test_session_creates_total", "test_session_csrf_failures_total"); err != nil {
+		t.Errorf("GatherAndCompare() returned unexpected error: %v", err)
+	}
+	if got, want := testutil.CollectAndCount(reg, "test_session_store_op_duration_seconds"), 1; got != want {
+		t.Errorf("Unexpected store operation series count - got: %d want: %d", got, want)
+	}
+
+	// Registering the same metrics again must fail.
+	if _, err := sessionprom.New(reg, "test"); err == nil {
+		t.Error("New() unexpectedly succeeded with duplicate registration")
+	}
+}
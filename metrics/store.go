@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+// Store is a SessionStore wrapping another SessionStore, recording the latency
+// and error class of each operation to a Recorder. Store implements the
+// optional store.UserIndex, store.UserSessionLimiter, store.Updater, and
+// store.Enumerator interfaces, delegating to the wrapped SessionStore if
+// supported (see store.Supports).
+type Store[S any] struct {
+	// Clock can be used to override measurement of time in tests.
+	Clock func() time.Time
+	s     store.SessionStore[S]
+	name  string
+	rec   Recorder
+}
+
+// NewStore returns a new Store wrapping the provided SessionStore, recording
+// operations to the provided Recorder under the provided name (e.g., "redis").
+func NewStore[S any](s store.SessionStore[S], name string, rec Recorder) *Store[S] {
+	return &Store[S]{
+		Clock: func() time.Time { return time.Now() },
+		s:     s,
+		name:  name,
+		rec:   rec,
+	}
+}
+
+// Unwrap implements store.Unwrapper.
+func (ms *Store[S]) Unwrap() store.SessionStore[S] {
+	return ms.s
+}
+
+func (ms *Store[S]) observe(op string, start time.Time, err error) {
+	ms.rec.ObserveStoreOp(ms.name, op, ErrorClass(err), ms.Clock().Sub(start))
+}
+
+// Get implements store.SessionStore.
+func (ms *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	start := ms.Clock()
+	s, err := ms.s.Get(ctx, sid)
+	ms.observe(store.OpGet, start, err)
+	return s, err
+}
+
+// Set implements store.SessionStore.
+func (ms *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	start := ms.Clock()
+	err := ms.s.Set(ctx, sid, s, ttl)
+	ms.observe(store.OpSet, start, err)
+	return err
+}
+
+// Del implements store.SessionStore.
+func (ms *Store[S]) Del(ctx context.Context, sid string) error {
+	start := ms.Clock()
+	err := ms.s.Del(ctx, sid)
+	ms.observe(store.OpDel, start, err)
+	return err
+}
+
+// Update implements store.Updater.
+func (ms *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	u, ok := ms.s.(store.Updater[S])
+	if !ok {
+		return store.Unsupported("store.Updater")
+	}
+	start := ms.Clock()
+	err := u.Update(ctx, sid, s, ttl)
+	ms.observe(store.OpUpdate, start, err)
+	return err
+}
+
+// SetLimited implements store.UserSessionLimiter.
+func (ms *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	l, ok := ms.s.(store.UserSessionLimiter[S])
+	if !ok {
+		return nil, store.Unsupported("store.UserSessionLimiter")
+	}
+	start := ms.Clock()
+	evicted, err := l.SetLimited(ctx, sid, s, ttl, limit, evict)
+	ms.observe(store.OpSetLimited, start, err)
+	return evicted, err
+}
+
+// UserSessions implements store.UserIndex.
+func (ms *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	ui, ok := ms.s.(store.UserIndex)
+	if !ok {
+		return nil, store.Unsupported("store.UserIndex")
+	}
+	start := ms.Clock()
+	sids, err := ui.UserSessions(ctx, uid)
+	ms.observe(store.OpUserSessions, start, err)
+	return sids, err
+}
+
+// Scan implements store.Scanner.
+func (ms *Store[S]) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[S], string, error) {
+	sc, ok := ms.s.(store.Scanner[S])
+	if !ok {
+		return nil, "", store.Unsupported("store.Scanner")
+	}
+	start := ms.Clock()
+	entries, next, err := sc.Scan(ctx, cursor, count)
+	ms.observe(store.OpScan, start, err)
+	return entries, next, err
+}
+
+// Count implements store.Enumerator.
+func (ms *Store[S]) Count(ctx context.Context) (int, error) {
+	e, ok := ms.s.(store.Enumerator[S])
+	if !ok {
+		return 0, store.Unsupported("store.Enumerator")
+	}
+	start := ms.Clock()
+	n, err := e.Count(ctx)
+	ms.observe(store.OpCount, start, err)
+	return n, err
+}
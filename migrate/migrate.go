@@ -0,0 +1,105 @@
+// Package migrate copies live sessions between SessionStores, e.g., when
+// switching from one backend to another without logging users out.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+const defaultBatchSize = 100
+
+// Options represents tunable knobs that control the behavior of Run.
+type Options struct {
+	// BatchSize is the number of sessions requested from the source store per
+	// call to Scan (which may be treated as a hint).
+	// Default if unspecified: 100
+	BatchSize int
+	// Overwrite causes sessions already present in the destination store to be
+	// replaced, which requires that it implement store.Updater (see
+	// store.Supports). Otherwise, such sessions are skipped.
+	// Default if unspecified: false
+	Overwrite bool
+	// MinTTL is the minimum remaining TTL of sessions to copy. Sessions
+	// expiring sooner are skipped.
+	// Default if unspecified: 0, in which case all unexpired sessions are
+	// copied.
+	MinTTL time.Duration
+	// OnProgress is a user-supplied callback invoked after each batch with the
+	// cumulative Stats, e.g., to log progress.
+	// Default if unspecified: nil, in which case OnProgress is not invoked.
+	OnProgress func(Stats)
+}
+
+// Stats summarizes the outcome of Run.
+type Stats struct {
+	// Scanned is the number of sessions read from the source store.
+	Scanned int
+	// Copied is the number of sessions written to the destination store.
+	Copied int
+	// Existing is the number of sessions skipped since they were already
+	// present in the destination store (without Overwrite).
+	Existing int
+	// Expiring is the number of sessions skipped due to MinTTL.
+	Expiring int
+}
+
+// Run copies all unexpired sessions from src to dst, preserving their
+// remaining TTLs. Sessions that do not expire (i.e., with zero TTL, see
+// store.Entry) are written with zero TTL, the interpretation of which depends
+// on dst.
+//
+// Run stops at the first error, returning the stats accumulated until then.
+// It is safe to rerun, since sessions already copied will be skipped (or
+// replaced, with Overwrite). Sessions created, updated, or deleted in src
+// while Run is in progress may not be reflected in dst, so consider writing to
+// both stores during the migration (see store/replicated).
+func Run[S any](ctx context.Context, src store.Scanner[S], dst store.SessionStore[S], opts *Options) (Stats, error) {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	var u store.Updater[S]
+	if opts.Overwrite {
+		if !store.Supports[store.Updater[S]](dst) {
+			return Stats{}, fmt.Errorf("Overwrite requires a destination store implementing store.Updater: %w", errors.ErrUnsupported)
+		}
+		u = dst.(store.Updater[S])
+	}
+	var stats Stats
+	var cursor string
+	for {
+		entries, next, err := src.Scan(ctx, cursor, opts.BatchSize)
+		if err != nil {
+			return stats, fmt.Errorf("failed to scan source store: %w", err)
+		}
+		for _, e := range entries {
+			stats.Scanned++
+			if e.TTL > 0 && e.TTL < opts.MinTTL {
+				stats.Expiring++
+				continue
+			}
+			err := dst.Set(ctx, e.SID, e.Session, e.TTL)
+			if errors.Is(err, store.ErrSessionExists) {
+				if !opts.Overwrite {
+					stats.Existing++
+					continue
+				}
+				err = u.Update(ctx, e.SID, e.Session, e.TTL)
+			}
+			if err != nil {
+				return stats, fmt.Errorf("failed to write session to destination store: %w", err)
+			}
+			stats.Copied++
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(stats)
+		}
+		if cursor = next; cursor == "" {
+			return stats, nil
+		}
+	}
+}
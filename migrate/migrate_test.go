@@ -0,0 +1,122 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/migrate"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/breaker"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+type fakeSession struct {
+	Name string
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name      string
+		opts      *migrate.Options
+		wantStats migrate.Stats
+		wantFoo   *fakeSession
+	}{
+		{
+			name:      "default",
+			opts:      &migrate.Options{BatchSize: 3},
+			wantStats: migrate.Stats{Scanned: 10, Copied: 9, Existing: 1},
+			wantFoo:   &fakeSession{Name: "existing"},
+		},
+		{
+			name:      "overwrite",
+			opts:      &migrate.Options{BatchSize: 3, Overwrite: true},
+			wantStats: migrate.Stats{Scanned: 10, Copied: 10},
+			wantFoo:   &fakeSession{Name: "foo"},
+		},
+		{
+			name:      "min TTL",
+			opts:      &migrate.Options{MinTTL: 90 * time.Minute},
+			wantStats: migrate.Stats{Scanned: 10, Copied: 5, Expiring: 5},
+			wantFoo:   &fakeSession{Name: "existing"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			src := memory.New[fakeSession]()
+			src.Clock = func() time.Time { return now }
+			dst := memory.New[fakeSession]()
+			dst.Clock = func() time.Time { return now }
+
+			for i := range 9 {
+				ttl := time.Hour
+				if i%2 == 0 {
+					ttl = 2 * time.Hour
+				}
+				if err := src.Set(ctx, fmt.Sprintf("sid-%d", i), &fakeSession{Name: "bar"}, ttl); err != nil {
+					t.Fatalf("Set() returned unexpected error: %v", err)
+				}
+			}
+			if err := src.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+				t.Fatalf("Set() returned unexpected error: %v", err)
+			}
+			if err := dst.Set(ctx, "foo", &fakeSession{Name: "existing"}, time.Hour); err != nil {
+				t.Fatalf("Set() returned unexpected error: %v", err)
+			}
+
+			var batches int
+			tc.opts.OnProgress = func(migrate.Stats) { batches++ }
+			stats, err := migrate.Run[fakeSession](ctx, src, dst, tc.opts)
+			if err != nil {
+				t.Fatalf("Run() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantStats, stats); diff != "" {
+				t.Errorf("Run() returned unexpected stats (-want +got):\n%s", diff)
+			}
+			if batches == 0 {
+				t.Errorf("OnProgress was not invoked")
+			}
+			if got, err := dst.Get(ctx, "foo"); err != nil {
+				t.Errorf("Get() returned unexpected error: %v", err)
+			} else if diff := cmp.Diff(tc.wantFoo, got); diff != "" {
+				t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+			}
+
+			// Remaining TTLs are preserved.
+			dst.Clock = func() time.Time { return now.Add(90 * time.Minute) }
+			if _, err := dst.Get(ctx, "sid-1"); !errors.Is(err, store.ErrSessionNotFound) {
+				t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+			}
+			if _, err := dst.Get(ctx, "sid-0"); err != nil {
+				t.Errorf("Get() returned unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunOverwriteUnsupported(t *testing.T) {
+	dst := struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()}
+	testCases := []struct {
+		name string
+		dst  store.SessionStore[fakeSession]
+	}{
+		{name: "unsupported", dst: dst},
+		// The wrapper implements store.Updater, but the wrapped store does not.
+		{name: "wrapped unsupported", dst: breaker.New[fakeSession](dst, &breaker.Options{})},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := migrate.Run[fakeSession](context.Background(), memory.New[fakeSession](), tc.dst, &migrate.Options{Overwrite: true})
+			if !errors.Is(err, errors.ErrUnsupported) {
+				t.Errorf("Run() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+func validateBaseCap(base, cap time.Duration) error {
+	if base <= 0 {
+		return fmt.Errorf("base delay is not positive: %w", ErrInvalidPolicyParam)
+	}
+	if cap != 0 && cap < base {
+		return fmt.Errorf("delay cap is less than base delay: %w", ErrInvalidPolicyParam)
+	}
+	return nil
+}
+
+// capped returns d limited to cap, unless cap is zero.
+func capped(d, cap time.Duration) time.Duration {
+	if cap != 0 && d > cap {
+		return cap
+	}
+	return d
+}
+
+// uniform returns a duration drawn uniformly from the interval [lo, hi).
+func uniform(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(rand.Int63n(int64(hi-lo)))
+}
+
+// FullJitter is a Policy implementing exponential backoff with "full" jitter:
+// the delay prior to the k-th retry is drawn uniformly from the interval
+// [0, min(Cap, Base * 2^(k-1))). Compared to Backoff, this better spreads out
+// retries from many concurrent clients. Multiple goroutines may use a given
+// FullJitter instance concurrently.
+type FullJitter struct {
+	// Base is the upper bound of the delay prior to the first retry, and must
+	// be positive.
+	Base time.Duration
+	// Cap is the maximum upper bound of the delay between attempts, and must
+	// be zero (i.e., uncapped) or at least Base.
+	Cap time.Duration
+	// OnRetry is as with Backoff.OnRetry.
+	OnRetry func(attempt int, delay time.Duration)
+	// MaxElapsed is as with Backoff.MaxElapsed.
+	MaxElapsed time.Duration
+	sleep      func(time.Duration) // overidden in tests
+	now        func() time.Time    // overidden in tests
+}
+
+// Do invokes the provided WorkFn up to n times according to the configured
+// backoff policy.
+func (fj FullJitter) Do(fn WorkFn, n int) error {
+	return fj.DoContext(context.Background(), fn, n)
+}
+
+// DoContext invokes the provided WorkFn up to n times according to the
+// configured backoff policy, stopping early as with Backoff.DoContext.
+func (fj FullJitter) DoContext(ctx context.Context, fn WorkFn, n int) error {
+	if err := validateBaseCap(fj.Base, fj.Cap); err != nil {
+		return err
+	}
+	bound := fj.Base
+	next := func() time.Duration {
+		delay := uniform(0, bound)
+		bound = capped(2*bound, fj.Cap)
+		return delay
+	}
+	return run(ctx, fn, n, loop{onRetry: fj.OnRetry, maxElapsed: fj.MaxElapsed, sleep: fj.sleep, now: fj.now}, next)
+}
+
+// DecorrelatedJitter is a Policy implementing "decorrelated" jitter: the delay
+// prior to each retry is drawn uniformly from the interval
+// [Base, 3 * previous delay), limited to Cap, where the initial "previous"
+// delay is Base. Multiple goroutines may use a given DecorrelatedJitter
+// instance concurrently.
+type DecorrelatedJitter struct {
+	// Base is the minimum delay between attempts, and must be positive.
+	Base time.Duration
+	// Cap is the maximum delay between attempts, and must be zero (i.e.,
+	// uncapped) or at least Base.
+	Cap time.Duration
+	// OnRetry is as with Backoff.OnRetry.
+	OnRetry func(attempt int, delay time.Duration)
+	// MaxElapsed is as with Backoff.MaxElapsed.
+	MaxElapsed time.Duration
+	sleep      func(time.Duration) // overidden in tests
+	now        func() time.Time    // overidden in tests
+}
+
+// Do invokes the provided WorkFn up to n times according to the configured
+// backoff policy.
+func (dj DecorrelatedJitter) Do(fn WorkFn, n int) error {
+	return dj.DoContext(context.Background(), fn, n)
+}
+
+// DoContext invokes the provided WorkFn up to n times according to the
+// configured backoff policy, stopping early as with Backoff.DoContext.
+func (dj DecorrelatedJitter) DoContext(ctx context.Context, fn WorkFn, n int) error {
+	if err := validateBaseCap(dj.Base, dj.Cap); err != nil {
+		return err
+	}
+	prev := dj.Base
+	next := func() time.Duration {
+		prev = capped(uniform(dj.Base, 3*prev), dj.Cap)
+		return prev
+	}
+	return run(ctx, fn, n, loop{onRetry: dj.OnRetry, maxElapsed: dj.MaxElapsed, sleep: dj.sleep, now: dj.now}, next)
+}
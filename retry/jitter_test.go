@@ -0,0 +1,118 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJitterDelays(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy func(sleep func(time.Duration)) Policy
+		budget int
+		delays []interval
+	}{
+		{
+			name: "full jitter - uncapped",
+			policy: func(sleep func(time.Duration)) Policy {
+				return &FullJitter{Base: 100 * time.Millisecond, sleep: sleep}
+			},
+			budget: 4,
+			delays: []interval{
+				{min: 0, max: 100 * time.Millisecond},
+				{min: 0, max: 200 * time.Millisecond},
+				{min: 0, max: 400 * time.Millisecond},
+			},
+		},
+		{
+			name: "full jitter - capped",
+			policy: func(sleep func(time.Duration)) Policy {
+				return &FullJitter{Base: 100 * time.Millisecond, Cap: 150 * time.Millisecond, sleep: sleep}
+			},
+			budget: 4,
+			delays: []interval{
+				{min: 0, max: 100 * time.Millisecond},
+				{min: 0, max: 150 * time.Millisecond},
+				{min: 0, max: 150 * time.Millisecond},
+			},
+		},
+		{
+			name: "decorrelated jitter - capped",
+			policy: func(sleep func(time.Duration)) Policy {
+				return &DecorrelatedJitter{Base: 100 * time.Millisecond, Cap: 500 * time.Millisecond, sleep: sleep}
+			},
+			budget: 4,
+			delays: []interval{
+				{min: 100 * time.Millisecond, max: 300 * time.Millisecond},
+				{min: 100 * time.Millisecond, max: 500 * time.Millisecond},
+				{min: 100 * time.Millisecond, max: 500 * time.Millisecond},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Repeat a number of times, given the randomness of the delays.
+			for r := 0; r < 100; r++ {
+				var delays []time.Duration
+				p := tc.policy(func(d time.Duration) {
+					delays = append(delays, d)
+				})
+				if got, want := p.Do(func(rc *RetryContext) {}, tc.budget), ErrExhausted; !errors.Is(got, want) {
+					t.Fatalf("Do(fn, %d) returned unexpected error status: got: %v, want: %v", tc.budget, got, want)
+				}
+				if got, want := len(delays), len(tc.delays); got != want {
+					t.Fatalf("Do(fn, %d) invoked sleep an incorrect number of times: got: %d, want: %d", tc.budget, got, want)
+				}
+				for i, d := range delays {
+					if !tc.delays[i].contains(d) {
+						t.Fatalf("Do(fn, %d) invoked sleep with an unexpected duration: got: %v, want in interval: %v", tc.budget, d, tc.delays[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOnRetryDoContext(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy func(onRetry func(int, time.Duration)) Policy
+	}{
+		{
+			name: "backoff",
+			policy: func(onRetry func(int, time.Duration)) Policy {
+				return Backoff{Base: time.Millisecond, Growth: 2.0, OnRetry: onRetry, sleep: func(time.Duration) {}}
+			},
+		},
+		{
+			name: "full jitter",
+			policy: func(onRetry func(int, time.Duration)) Policy {
+				return FullJitter{Base: time.Millisecond, OnRetry: onRetry, sleep: func(time.Duration) {}}
+			},
+		},
+		{
+			name: "decorrelated jitter",
+			policy: func(onRetry func(int, time.Duration)) Policy {
+				return DecorrelatedJitter{Base: time.Millisecond, OnRetry: onRetry, sleep: func(time.Duration) {}}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts []int
+			p := tc.policy(func(attempt int, _ time.Duration) {
+				attempts = append(attempts, attempt)
+			})
+			if err := p.DoContext(context.Background(), func(*RetryContext) {}, 3); !errors.Is(err, ErrExhausted) {
+				t.Fatalf("DoContext(fn, 3) returned unexpected error - got: %v want: %v", err, ErrExhausted)
+			}
+			if diff := cmp.Diff([]int{2, 3}, attempts); diff != "" {
+				t.Errorf("DoContext(fn, 3) invoked OnRetry with unexpected attempts (+got, -want):\n%s", diff)
+			}
+		})
+	}
+}
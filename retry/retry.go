@@ -0,0 +1,193 @@
+// Package retry implements retries with jittered exponential backoff.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var (
+	// ErrInvalidPolicyParam indicates that one or more Policy parameters are
+	// invalid (e.g., fall outside accepted intervals).
+	ErrInvalidPolicyParam = errors.New("invalid policy param")
+	// ErrAborted indicates that the work function provided to Do invoked the
+	// Abort callback indicating a non-retryable error.
+	ErrAborted = errors.New("aborted")
+	// ErrExhausted indicates that the work function provided to Do exhausted
+	// the provided attempt budget without succeeding.
+	ErrExhausted = errors.New("too many attempts")
+	// ErrMaxElapsed indicates that the work function provided to DoContext did
+	// not succeed before the next attempt would exceed the maximum elapsed
+	// time.
+	ErrMaxElapsed = errors.New("max elapsed time exceeded")
+)
+
+// Policy represents an abstract retry policy, which can be used to execute a
+// retryable work function.
+type Policy interface {
+	// Do invokes fn up to n times (i.e., the attempt budget).
+	Do(fn WorkFn, n int) error
+	// DoContext invokes fn up to n times, as with Do, but stops early once
+	// the provided Context is done.
+	DoContext(ctx context.Context, fn WorkFn, n int) error
+}
+
+// Backoff is a Policy implementing jittered exponential backoff. Multiple
+// goroutines may use a given Backoff instance concurrently.
+type Backoff struct {
+	// Base is the initial delay between attempts.
+	Base time.Duration
+	// Growth is the multiplicative growth factor used to increase the delay on
+	// successive attempts, and must be greater than or equal to 1.
+	Growth float64
+	// Jitter is the fractional amplitude of the random jitter applied to the
+	// delay each time Do sleeps prior to the next attempt, and must be in the
+	// interval [0, 1].
+	Jitter float64
+	// OnRetry, if non-nil, is invoked prior to sleeping ahead of each retry
+	// with the number of the upcoming attempt (i.e., starting at 2) and the
+	// delay prior to making it.
+	OnRetry func(attempt int, delay time.Duration)
+	// MaxElapsed, if positive, is the maximum total time DoContext may spend
+	// retrying: no further attempt is made if it would start after
+	// MaxElapsed has elapsed since the first.
+	MaxElapsed time.Duration
+	sleep      func(time.Duration) // overidden in tests
+	now        func() time.Time    // overidden in tests
+}
+
+func (b *Backoff) validate() error {
+	if b.Growth < 1.0 {
+		return fmt.Errorf("delay growth factor is less than 1: %w", ErrInvalidPolicyParam)
+	}
+	if b.Jitter < 0.0 {
+		return fmt.Errorf("delay jitter amplitude is negative: %w", ErrInvalidPolicyParam)
+	}
+	if b.Jitter > 1.0 {
+		return fmt.Errorf("delay jitter amplitude is greater than 1: %w", ErrInvalidPolicyParam)
+	}
+	return nil
+}
+
+// WorkFn represents the retryable work provided to a Policy for execution
+// (i.e., passed to Do). The function must report attempt outcome status via the
+// provided RetryContext.
+type WorkFn func(*RetryContext)
+
+// RetryContext is passed to a WorkFn provided to a given Policy via Do, and is
+// used to report "terminal" attempt outcomes.
+// Note: A retryable error need not be explicitly reported.
+type RetryContext struct {
+	// Context is the Context provided to DoContext (or context.Background(),
+	// if invoked via Do), which should be used by the WorkFn for any
+	// cancellable work.
+	Context context.Context
+	// Done should be invoked when the WorkFn has successfully completed its
+	// work, and need not be retried.
+	Done func()
+	// Abort should be invoked when the WorkFn encounters a non-retryable error,
+	// and thus should not be retried.
+	Abort func()
+}
+
+// scale scales the duration d by f, truncated to integer nanoseconds.
+func scale(d time.Duration, f float64) time.Duration {
+	return time.Duration(float64(d.Nanoseconds())*f) * time.Nanosecond
+}
+
+// Do invokes the provided WorkFn up to n times according to the configured
+// backoff policy.
+func (b Backoff) Do(fn WorkFn, n int) error {
+	return b.DoContext(context.Background(), fn, n)
+}
+
+// DoContext invokes the provided WorkFn up to n times according to the
+// configured backoff policy. If the provided Context is done prior to an
+// attempt (including while sleeping between attempts), DoContext returns an
+// error wrapping ctx.Err(). If MaxElapsed is configured and the next attempt
+// would start after it has elapsed, DoContext returns ErrMaxElapsed.
+func (b Backoff) DoContext(ctx context.Context, fn WorkFn, n int) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	d := b.Base
+	next := func() time.Duration {
+		// Note: Jitter is actually over the interval [1-J, 1+J).
+		delay := scale(d, 1.0+b.Jitter*(2*rand.Float64()-1.0))
+		d = scale(d, b.Growth)
+		return delay
+	}
+	return run(ctx, fn, n, loop{onRetry: b.OnRetry, maxElapsed: b.MaxElapsed, sleep: b.sleep, now: b.now}, next)
+}
+
+// loop represents the parameters common to all Policy implementations, used by
+// run.
+type loop struct {
+	onRetry    func(attempt int, delay time.Duration)
+	maxElapsed time.Duration
+	sleep      func(time.Duration)
+	now        func() time.Time
+}
+
+// wait sleeps for the provided duration, returning early with ctx.Err() if the
+// provided Context is done first.
+func (l loop) wait(ctx context.Context, d time.Duration) error {
+	if l.sleep != nil {
+		l.sleep(d)
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// run implements DoContext for all Policy implementations, using next to
+// determine the delay prior to each successive retry.
+func run(ctx context.Context, fn WorkFn, n int, l loop, next func() time.Duration) error {
+	now := l.now
+	if now == nil {
+		now = time.Now
+	}
+	onCall := func(called *bool) func() { return func() { *called = true } }
+	var done bool
+	var aborted bool
+	rctx := RetryContext{
+		Context: ctx,
+		Done:    onCall(&done),
+		Abort:   onCall(&aborted),
+	}
+	start := now()
+	for i := 1; i <= n; i++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("retry interrupted prior to attempt %d: %w", i, err)
+		}
+		fn(&rctx)
+		if done {
+			return nil
+		}
+		if aborted {
+			return ErrAborted
+		}
+		if i < n {
+			delay := next()
+			if l.maxElapsed > 0 && now().Add(delay).Sub(start) > l.maxElapsed {
+				return ErrMaxElapsed
+			}
+			if l.onRetry != nil {
+				l.onRetry(i+1, delay)
+			}
+			if err := l.wait(ctx, delay); err != nil {
+				return fmt.Errorf("retry interrupted prior to attempt %d: %w", i+1, err)
+			}
+		}
+	}
+	return ErrExhausted
+}
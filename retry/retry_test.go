@@ -1,9 +1,12 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestInvalidPolicyParam(t *testing.T) {
@@ -23,6 +26,22 @@ func TestInvalidPolicyParam(t *testing.T) {
 			name:   "backoff: jitter amplitude negative",
 			policy: &Backoff{Base: 100 * time.Millisecond, Growth: 1.2, Jitter: -0.1},
 		},
+		{
+			name:   "full jitter: base not positive",
+			policy: &FullJitter{Base: 0},
+		},
+		{
+			name:   "full jitter: cap less than base",
+			policy: &FullJitter{Base: 100 * time.Millisecond, Cap: 10 * time.Millisecond},
+		},
+		{
+			name:   "decorrelated jitter: base not positive",
+			policy: &DecorrelatedJitter{Base: -time.Millisecond},
+		},
+		{
+			name:   "decorrelated jitter: cap less than base",
+			policy: &DecorrelatedJitter{Base: 100 * time.Millisecond, Cap: 10 * time.Millisecond},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -171,3 +190,133 @@ func TestBackoffDelays(t *testing.T) {
 		})
 	}
 }
+
+func TestBackoffOnRetry(t *testing.T) {
+	type retryEvent struct {
+		Attempt int
+		Delay   time.Duration
+	}
+	var events []retryEvent
+	b := &Backoff{
+		Base:   100 * time.Millisecond,
+		Growth: 2.0,
+		OnRetry: func(attempt int, delay time.Duration) {
+			events = append(events, retryEvent{Attempt: attempt, Delay: delay})
+		},
+		sleep: func(time.Duration) {},
+	}
+	var attempts int
+	if err := b.Do(func(rc *RetryContext) {
+		if attempts++; attempts == 3 {
+			rc.Done()
+		}
+	}, 4); err != nil {
+		t.Fatalf("Do(fn, 4) returned unexpected error: %v", err)
+	}
+	want := []retryEvent{
+		{Attempt: 2, Delay: 100 * time.Millisecond},
+		{Attempt: 3, Delay: 200 * time.Millisecond},
+	}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("Do(fn, 4) invoked OnRetry with unexpected arguments (+got, -want):\n%s", diff)
+	}
+}
+
+func TestBackoffDoContext(t *testing.T) {
+	type ctxKey struct{}
+	testCases := []struct {
+		name     string
+		backoff  *Backoff
+		ctx      func() (context.Context, context.CancelFunc)
+		fn       func(cancel context.CancelFunc) func(*RetryContext)
+		attempts int
+		err      error
+	}{
+		{
+			name:    "passes context",
+			backoff: &Backoff{Base: time.Millisecond, Growth: 1.0},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "hello"))
+			},
+			fn: func(context.CancelFunc) func(*RetryContext) {
+				return func(rc *RetryContext) {
+					if rc.Context.Value(ctxKey{}) == "hello" {
+						rc.Done()
+					}
+				}
+			},
+			attempts: 1,
+		},
+		{
+			name:    "canceled while sleeping",
+			backoff: &Backoff{Base: time.Hour, Growth: 1.0},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			fn: func(cancel context.CancelFunc) func(*RetryContext) {
+				return func(*RetryContext) { cancel() }
+			},
+			attempts: 1,
+			err:      context.Canceled,
+		},
+		{
+			name:    "deadline exceeded while sleeping",
+			backoff: &Backoff{Base: time.Hour, Growth: 1.0},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			fn: func(context.CancelFunc) func(*RetryContext) {
+				return func(*RetryContext) {}
+			},
+			attempts: 1,
+			err:      context.DeadlineExceeded,
+		},
+		{
+			name:    "canceled prior to first attempt",
+			backoff: &Backoff{Base: time.Millisecond, Growth: 1.0},
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			fn: func(context.CancelFunc) func(*RetryContext) {
+				return func(*RetryContext) {}
+			},
+			attempts: 0,
+			err:      context.Canceled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+			cw := &countingWorker{fnInner: tc.fn(cancel)}
+			if got, want := tc.backoff.DoContext(ctx, cw.fn, 3), tc.err; !errors.Is(got, want) {
+				t.Errorf("DoContext(ctx, fn, 3) returned unexpected error status: got: %v, want: %v", got, want)
+			}
+			if got, want := cw.attempts, tc.attempts; got != want {
+				t.Errorf("DoContext(ctx, fn, 3) made an unexpected number of attempts: got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestBackoffMaxElapsed(t *testing.T) {
+	now := time.Now()
+	b := &Backoff{
+		Base:       100 * time.Millisecond,
+		Growth:     2.0,
+		MaxElapsed: 250 * time.Millisecond,
+		sleep:      func(d time.Duration) { now = now.Add(d) },
+		now:        func() time.Time { return now },
+	}
+	// Attempts start at 0ms, 100ms, and 300ms, the last of which exceeds the
+	// max elapsed time.
+	cw := &countingWorker{fnInner: func(*RetryContext) {}}
+	if got, want := b.DoContext(context.Background(), cw.fn, 4), ErrMaxElapsed; !errors.Is(got, want) {
+		t.Errorf("DoContext(ctx, fn, 4) returned unexpected error status: got: %v, want: %v", got, want)
+	}
+	if got, want := cw.attempts, 2; got != want {
+		t.Errorf("DoContext(ctx, fn, 4) made an unexpected number of attempts: got: %d, want: %d", got, want)
+	}
+}
@@ -22,19 +22,22 @@ package session
 
 import (
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
 
-	"github.com/swfrench/simple-session/internal/retry"
 	"github.com/swfrench/simple-session/internal/token"
+	"github.com/swfrench/simple-session/metrics"
+	"github.com/swfrench/simple-session/retry"
 	"github.com/swfrench/simple-session/store"
 	"golang.org/x/crypto/hkdf"
-	"golang.org/x/exp/slog"
 )
 
 const (
@@ -43,13 +46,27 @@ const (
 	sessionCookieGracePeriod  = 10 * time.Minute
 	defaultIDLen              = 16 // bytes
 	defaultSessionCookieName  = "session"
+	sessionHandleLen          = 16 // bytes
+	defaultLastSeenInterval   = 5 * time.Minute
+	defaultRetryAttempts      = 4
 )
 
 // contextKey is the type used to represent keys identifying values stored in
 // the request Context.
 type contextKey string
 
-const contextKeySession = contextKey("session")
+const (
+	contextKeySession = contextKey("session")
+	contextKeyClient  = contextKey("client")
+)
+
+// clientInfo describes the client issuing the request being handled by the
+// Manage middleware, and is used to populate Metadata on session creation.
+type clientInfo struct {
+	userAgent   string
+	ip          string
+	fingerprint string // empty if no FingerprintPolicy is configured
+}
 
 // Session represents a user session.
 type Session[D any] struct {
@@ -63,12 +80,33 @@ type Session[D any] struct {
 	// CSRFToken is a random identifier (authenticated) bound to this session,
 	// suitable for, e.g., embedding in a hidden form field.
 	CSRFToken string `json:"csrf_token"`
+	// Metadata describes the origin and use of this session.
+	Metadata Metadata `json:"metadata"`
+	// Fingerprint identifies the client that created this session, if a
+	// FingerprintPolicy is configured.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Metadata describes the origin and use of a Session, e.g., for display on an
+// account settings page or for auditing. Client details are only populated
+// when the Session is created within a request handled by the Manage
+// middleware (i.e., when Create is passed the request Context). Similarly,
+// LastSeen is refreshed by Manage (see Options.LastSeenInterval).
+type Metadata struct {
+	// CreatedAt is the time at which the session was created.
+	CreatedAt time.Time `json:"created_at"`
+	// LastSeen is the time at which the session was last used.
+	LastSeen time.Time `json:"last_seen"`
+	// UserAgent is the User-Agent of the client that created the session.
+	UserAgent string `json:"user_agent,omitempty"`
+	// IP is the IP address of the client that created the session.
+	IP string `json:"ip,omitempty"`
 }
 
 // Options represents tunable knobs that control the behavior of Manager.
 type Options struct {
-	// TTL is the duration that any given session is valid. Note that there is
-	// no facility for session extension at this time.
+	// TTL is the duration that any given session is valid, unless extended
+	// (see Manager.Extend).
 	// Default if unspecified: 30m
 	TTL time.Duration
 	// IDLen is the length of random portion of user-facing identifiers (i.e.,
@@ -89,9 +127,138 @@ type Options struct {
 	CreateCookie func(name, value string, expires time.Time) *http.Cookie
 	// OnCreate is a user-supplied callback invoked on session creation, with
 	// the associated ResponseWriter instance and newly created Session. May be
-	// used, e.g., to inject a CSRF Token cookie into the response.
+	// used, e.g., to inject a CSRF Token cookie into the response. See also
+	// Manager.Hooks for typed lifecycle callbacks.
 	// Default if unspecified: nil, in which case OnCreate is not invoked.
 	OnCreate func(w http.ResponseWriter, session any)
+	// MaxUserSessions is the maximum number of concurrent sessions associated
+	// with any given user, enforced by Create when Data is non-nil according
+	// to UserSessionLimitPolicy. The SessionStore must implement
+	// store.UserSessionLimiter, and be configured to associate sessions with
+	// users (e.g., see UserIDFunc), otherwise NewManager returns an error (see
+	// store.UserIndexEnabled).
+	// Default if unspecified: 0, in which case no limit is enforced.
+	MaxUserSessions int
+	// UserSessionLimitPolicy determines the behavior of Create when creating a
+	// session would exceed MaxUserSessions.
+	// Default if unspecified: RejectNewSession
+	UserSessionLimitPolicy UserSessionLimitPolicy
+	// ClientIP is a user-supplied function returning the IP address of the
+	// client issuing the provided request, recorded in Metadata.IP on session
+	// creation. When serving behind a reverse proxy, consider using
+	// TrustedProxyClientIP.
+	// Default if unspecified: RemoteAddrClientIP
+	ClientIP func(*http.Request) string
+	// LastSeenInterval is the minimum interval between updates to
+	// Metadata.LastSeen by Manage, which requires a write to the SessionStore.
+	// Updates are only performed if the SessionStore implements
+	// store.Updater.
+	// Default if unspecified: 5m. Negative values disable updates.
+	LastSeenInterval time.Duration
+	// Fingerprint, if provided, configures binding of sessions to the client
+	// that created them (see FingerprintPolicy).
+	// Default if unspecified: nil, in which case sessions are not bound.
+	Fingerprint *FingerprintPolicy
+	// Logger is the logger used by Manager. Session IDs are never logged;
+	// where a session must be identified, its SessionHandle is used instead.
+	// Default if unspecified: slog.Default()
+	Logger *slog.Logger
+	// Metrics receives measurements of Manager operations (e.g., see
+	// metrics.NewExpvar). To instrument SessionStore operations, see
+	// metrics.NewStore.
+	// Default if unspecified: metrics.Nop{}
+	Metrics metrics.Recorder
+	// Tracer is used to create spans for Manager operations (e.g., Create,
+	// session lookup, and Clear), e.g., see tracing.NewTracer. To trace
+	// SessionStore operations, see tracing.NewStore.
+	// Default if unspecified: a no-op Tracer.
+	Tracer Tracer
+	// RetryPolicy is the policy used to retry SessionStore operations that
+	// fail with retryable errors (see RetryClassifier): storing new sessions
+	// in Create (where a retry uses a new SID) and looking up sessions in
+	// Manage.
+	// Retries can be observed via the OnRetry field of the retry package
+	// policies.
+	// Default if unspecified: retry.Backoff with 100ms base delay, 2x growth,
+	// 20% jitter, and 1s MaxElapsed.
+	RetryPolicy retry.Policy
+	// RetryAttempts is the maximum number of attempts made by RetryPolicy,
+	// including the first. A value of 1 disables retries.
+	// Default if unspecified: 4
+	RetryAttempts int
+	// RetryClassifier reports whether the provided error returned by a
+	// SessionStore operation is retryable.
+	// Default if unspecified: store.IsRetryable
+	RetryClassifier func(error) bool
+	// StoreFailurePolicy determines how Manage handles requests for which
+	// the session could not be looked up due to a SessionStore failure (e.g.,
+	// an outage), as opposed to the session not existing or having expired.
+	// Default if unspecified: ServeStoreFailure
+	StoreFailurePolicy StoreFailurePolicy
+	// StoreFailureHandler is a user-supplied function used to respond to
+	// requests under the ServeStoreFailure policy, with the associated
+	// SessionStore error.
+	// Default if unspecified: ServiceUnavailable
+	StoreFailureHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// StoreFailurePolicy determines how Manage handles SessionStore failures
+// encountered while looking up the session associated with a request. In all
+// cases, the SID cookie is left untouched, such that the session may be used
+// once the SessionStore recovers.
+type StoreFailurePolicy int
+
+const (
+	// ServeStoreFailure causes Manage to respond to the request via
+	// Options.StoreFailureHandler, without invoking the wrapped handler.
+	ServeStoreFailure StoreFailurePolicy = iota
+	// PassWithoutSession causes Manage to invoke the wrapped handler without
+	// a Session in the request Context (i.e., Get returns nil).
+	PassWithoutSession
+)
+
+func (p StoreFailurePolicy) String() string {
+	switch p {
+	case ServeStoreFailure:
+		return "serve-store-failure"
+	case PassWithoutSession:
+		return "pass-without-session"
+	}
+	return "unknown"
+}
+
+// ServiceUnavailable responds to the provided request with 503 Service
+// Unavailable. This is the default Options.StoreFailureHandler.
+func ServiceUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+}
+
+// UserSessionLimitPolicy determines how Manager enforces MaxUserSessions.
+type UserSessionLimitPolicy int
+
+const (
+	// RejectNewSession causes Create to fail with a *UserSessionLimitError
+	// when the user already has MaxUserSessions sessions.
+	RejectNewSession UserSessionLimitPolicy = iota
+	// EvictOldestSession causes Create to delete the user's oldest sessions
+	// (i.e., those soonest to expire) to make room for the new session.
+	EvictOldestSession
+)
+
+// UserSessionLimitError is returned by Create when creating a session would
+// exceed MaxUserSessions under the RejectNewSession policy. It wraps
+// store.ErrUserSessionLimit.
+type UserSessionLimitError struct {
+	// Limit is the configured MaxUserSessions.
+	Limit int
+}
+
+func (e *UserSessionLimitError) Error() string {
+	return fmt.Sprintf("user session limit (%d) reached", e.Limit)
+}
+
+func (e *UserSessionLimitError) Unwrap() error {
+	return store.ErrUserSessionLimit
 }
 
 // CreateStrictCookie returns an http.Cookie with strict defaults, with the
@@ -113,10 +280,14 @@ func CreateStrictCookie(name, value string, expires time.Time) *http.Cookie {
 type Manager[D any] struct {
 	// Clock can be used to override measurement of time in tests.
 	Clock func() time.Time
+	// Hooks are user-supplied callbacks invoked on session lifecycle events,
+	// and should be set prior to use of the Manager.
+	Hooks Hooks[D]
 	store store.SessionStore[Session[D]]
 	opts  *Options
 	sta   *token.Authenticator
 	cta   *token.Authenticator
+	hkey  []byte
 }
 
 func deriveKeys(ikm []byte, infos []string) ([][]byte, error) {
@@ -149,7 +320,44 @@ func NewManager[D any](s store.SessionStore[Session[D]], key []byte, opts *Optio
 	if opts.CreateCookie == nil {
 		opts.CreateCookie = CreateStrictCookie
 	}
-	keys, err := deriveKeys(key, []string{"session-token", "csrf-token"})
+	if opts.ClientIP == nil {
+		opts.ClientIP = RemoteAddrClientIP
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.Nop{}
+	}
+	if opts.StoreFailureHandler == nil {
+		opts.StoreFailureHandler = ServiceUnavailable
+	}
+	if opts.RetryPolicy == nil {
+		opts.RetryPolicy = retry.Backoff{
+			Base:       100 * time.Millisecond,
+			Growth:     2.0,
+			Jitter:     0.2,
+			MaxElapsed: time.Second,
+		}
+	}
+	if opts.RetryAttempts == 0 {
+		opts.RetryAttempts = defaultRetryAttempts
+	}
+	if opts.RetryClassifier == nil {
+		opts.RetryClassifier = store.IsRetryable
+	}
+	if opts.Tracer == nil {
+		opts.Tracer = nopTracer{}
+	}
+	if opts.LastSeenInterval == time.Duration(0) {
+		opts.LastSeenInterval = defaultLastSeenInterval
+	}
+	if opts.MaxUserSessions > 0 {
+		if !store.Supports[store.UserSessionLimiter[Session[D]]](s) || !store.UserIndexEnabled(s) {
+			return nil, errors.New("MaxUserSessions requires a SessionStore implementing store.UserSessionLimiter, with its user index enabled")
+		}
+	}
+	keys, err := deriveKeys(key, []string{"session-token", "csrf-token", "session-handle"})
 	if err != nil {
 		return nil, err
 	}
@@ -159,17 +367,62 @@ func NewManager[D any](s store.SessionStore[Session[D]], key []byte, opts *Optio
 		opts:  opts,
 		sta:   token.NewAuthenticator(keys[0]),
 		cta:   token.NewAuthenticator(keys[1]),
+		hkey:  keys[2],
 	}, nil
 }
 
 var errExpiredSession = errors.New("expired session")
 
-func (m *Manager[D]) lookup(ctx context.Context, sid string) (*Session[D], error) {
-	s, err := m.store.Get(ctx, sid)
+// lookupOutcome returns the metrics lookup outcome associated with the
+// provided error returned by lookup.
+func lookupOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.LookupHit
+	case errors.Is(err, store.ErrSessionNotFound):
+		return metrics.LookupMiss
+	case errors.Is(err, errExpiredSession):
+		return metrics.LookupExpired
+	}
+	return metrics.LookupError
+}
+
+func (m *Manager[D]) lookup(ctx context.Context, sid string) (s *Session[D], err error) {
+	ctx, span := m.opts.Tracer.Start(ctx, "Manager.lookup")
+	span.SetSessionID(sid)
+	defer func() {
+		if errors.Is(err, errExpiredSession) {
+			// Expiration is an expected outcome, as with ErrSessionNotFound.
+			span.End(metrics.LookupExpired, nil)
+		} else {
+			span.End(lookupOutcome(err), err)
+		}
+	}()
+	var attempts int
+	var lastEnd time.Time
+	fn := func(rctx *retry.RetryContext) {
+		attempts++
+		if attempts > 1 {
+			span.RecordRetry(attempts, m.Clock().Sub(lastEnd))
+		}
+		defer func() { lastEnd = m.Clock() }()
+		if s, err = m.store.Get(ctx, sid); err == nil {
+			rctx.Done()
+		} else if !m.opts.RetryClassifier(err) {
+			rctx.Abort()
+		}
+	}
+	if rerr := m.opts.RetryPolicy.DoContext(ctx, fn, m.opts.RetryAttempts); err == nil && rerr != nil {
+		// No attempt was made (e.g., ctx was already done).
+		err = rerr
+	}
 	if err != nil {
 		return nil, err
 	}
 	if s.Expiration.Before(m.Clock()) {
+		if m.Hooks.OnExpired != nil {
+			m.Hooks.OnExpired(ctx, s)
+		}
 		return nil, errExpiredSession
 	}
 	return s, nil
@@ -201,36 +454,74 @@ func (m *Manager[D]) createCSRFToken() (string, error) {
 
 // Create creates a new Session with the provided Data payload, storing the
 // session to the SessionStore and setting the associated SID cookie.
+//
+// If MaxUserSessions is configured and Data is non-nil, the limit is enforced
+// atomically with storing the session. Under the RejectNewSession policy, a
+// *UserSessionLimitError is returned if the limit has been reached.
+//
+// Storing the session is retried on transient failures, for as long as the
+// provided Context is not done. If the Context is done first, the returned
+// error wraps ctx.Err().
 func (m *Manager[D]) Create(ctx context.Context, w http.ResponseWriter, data *D) (*Session[D], error) {
+	return m.create(ctx, w, data, true)
+}
+
+// create implements Create, enforcing MaxUserSessions only if limit is true.
+func (m *Manager[D]) create(ctx context.Context, w http.ResponseWriter, data *D, limit bool) (*Session[D], error) {
+	ctx, span := m.opts.Tracer.Start(ctx, "Manager.Create")
 	var s *Session[D]
+	var limitErr error
+	var attempts int
+	var lastEnd time.Time
 	fn := func(rctx *retry.RetryContext) {
+		attempts++
+		if attempts > 1 {
+			span.RecordRetry(attempts, m.Clock().Sub(lastEnd))
+		}
+		defer func() { lastEnd = m.Clock() }()
 		// create(Session|CSRF)Token may fail if there is insufficient entropy
 		// available, in which case, it makes sense to backoff and retry.
 		id, err := m.createSessionToken()
 		if err != nil {
-			slog.Error("Failed to generate Session ID token", "error", err)
+			m.opts.Logger.ErrorContext(ctx, "Failed to generate Session ID token", "error", err)
 			return
 		}
 		csrf, err := m.createCSRFToken()
 		if err != nil {
-			slog.Error("Failed to generate CSRF token", "error", err)
+			m.opts.Logger.ErrorContext(ctx, "Failed to generate CSRF token", "error", err)
 			return
 		}
+		now := m.Clock()
 		snew := &Session[D]{
 			ID:         id,
 			Data:       data,
-			Expiration: m.Clock().Add(m.opts.TTL),
+			Expiration: now.Add(m.opts.TTL),
 			CSRFToken:  csrf,
+			Metadata: Metadata{
+				CreatedAt: now,
+				LastSeen:  now,
+			},
+		}
+		if ci, ok := ctx.Value(contextKeyClient).(*clientInfo); ok {
+			snew.Metadata.UserAgent = ci.userAgent
+			snew.Metadata.IP = ci.ip
+			snew.Fingerprint = ci.fingerprint
 		}
 		// Set may fail if there is a session collision, the backing store is
 		// unavailable, or snew cannot be marshalled for storage.
-		if err := m.store.Set(ctx, id, snew, m.opts.TTL+sessionStorageGracePeriod); err != nil {
+		if err := m.storeNew(ctx, snew, limit); err != nil {
+			if errors.Is(err, store.ErrUserSessionLimit) {
+				limitErr = &UserSessionLimitError{Limit: m.opts.MaxUserSessions}
+				rctx.Abort()
+				return
+			}
 			if !errors.Is(err, store.ErrSessionExists) {
-				slog.Error("Failed to store new Session", "error", err)
+				m.opts.Logger.ErrorContext(ctx, "Failed to store new Session", "error", err)
 			}
-			if errors.Is(err, store.ErrInvalidSessionData) {
-				// This suggests that type D cannot be marshalled by the
-				// underlying store, which retry cannot address.
+			if !m.opts.RetryClassifier(err) {
+				// For example, ErrInvalidSessionData suggests that type D
+				// cannot be marshalled by the underlying store, which retry
+				// cannot address.
 				rctx.Abort()
 			}
 			return
@@ -238,34 +529,168 @@ func (m *Manager[D]) Create(ctx context.Context, w http.ResponseWriter, data *D)
 		s = snew
 		rctx.Done()
 	}
-	// Max 4 attempts, with inter-attempt delay ~100ms, ~200ms, ~400ms (+/- 20%).
-	err := retry.Backoff{Base: 100 * time.Millisecond, Growth: 2.0, Jitter: 0.2}.Do(fn, 4)
+	err := m.opts.RetryPolicy.DoContext(ctx, fn, m.opts.RetryAttempts)
+	span.SetAttempts(attempts)
+	if limitErr != nil {
+		m.opts.Metrics.ObserveCreate(metrics.CreateLimited, attempts)
+		span.End(metrics.CreateLimited, nil)
+		return nil, fmt.Errorf("failed to create session: %w", limitErr)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %v", err)
+		m.opts.Metrics.ObserveCreate(metrics.CreateError, attempts)
+		span.End(metrics.CreateError, err)
+		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
+	m.opts.Metrics.ObserveCreate(metrics.CreateOK, attempts)
+	span.SetSessionID(s.ID)
+	span.End(metrics.CreateOK, nil)
 	m.setSIDCookie(w, s.ID)
 	if m.opts.OnCreate != nil {
 		m.opts.OnCreate(w, s)
 	}
+	if m.Hooks.OnCreate != nil {
+		m.Hooks.OnCreate(ctx, s)
+	}
 	return s, nil
 }
 
+// storeNew stores the provided newly created session, enforcing
+// MaxUserSessions if configured and limit is true.
+func (m *Manager[D]) storeNew(ctx context.Context, s *Session[D], limit bool) error {
+	ttl := m.opts.TTL + sessionStorageGracePeriod
+	if s.Data == nil || m.opts.MaxUserSessions <= 0 || !limit {
+		return m.store.Set(ctx, s.ID, s, ttl)
+	}
+	limiter := m.store.(store.UserSessionLimiter[Session[D]])
+	evicted, err := limiter.SetLimited(ctx, s.ID, s, ttl, m.opts.MaxUserSessions, m.opts.UserSessionLimitPolicy == EvictOldestSession)
+	if err != nil {
+		return err
+	}
+	if len(evicted) > 0 {
+		m.opts.Logger.DebugContext(ctx, "Evicted sessions to enforce user session limit", "count", len(evicted))
+	}
+	return nil
+}
+
 // Clear creates a new pre-session (i.e., a Session with no Data payload) and
 // attempts to delete the prior session from the SessionStore. The former is
 // stored to the SessionStore and its ID set in the SID cookie, and it is also
 // returned. Deletion of the old session is considered non-critical (i.e.,
 // unexpected errors are merely logged).
 func (m *Manager[D]) Clear(ctx context.Context, w http.ResponseWriter, sid string) (*Session[D], error) {
+	ctx, span := m.opts.Tracer.Start(ctx, "Manager.Clear")
+	span.SetSessionID(sid)
 	ps, err := m.Create(ctx, w, nil)
 	if err != nil {
+		span.End(metrics.ClearError, err)
 		return nil, fmt.Errorf("failed to create new pre-session: %w", err)
 	}
-	if err := m.store.Del(ctx, sid); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
-		slog.Error("Failed to delete data for session", "sid", sid)
+	if err := m.deleteSession(ctx, sid); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+		m.opts.Logger.ErrorContext(ctx, "Failed to delete data for session", "session", m.handle(sid), "error", err)
 	}
+	span.End(metrics.ClearOK, nil)
 	return ps, nil
 }
 
+// deleteSession deletes the session associated with the provided SID from the
+// SessionStore, invoking the OnDestroy hook on success.
+func (m *Manager[D]) deleteSession(ctx context.Context, sid string) error {
+	var old *Session[D]
+	if m.Hooks.OnDestroy != nil {
+		// Best effort, as this is only used to populate the hook argument.
+		old, _ = m.store.Get(ctx, sid)
+	}
+	if err := m.store.Del(ctx, sid); err != nil {
+		return err
+	}
+	if m.Hooks.OnDestroy != nil {
+		if old == nil {
+			old = &Session[D]{ID: sid}
+		}
+		m.Hooks.OnDestroy(ctx, old)
+	}
+	return nil
+}
+
+// Rotate replaces the provided Session with a new one bearing the same Data
+// payload but a new SID and CSRF token, setting the associated SID cookie, and
+// returns the new Session. This is useful, e.g., to mitigate session fixation
+// on privilege changes. Deletion of the old session is considered
+// non-critical (i.e., unexpected errors are merely logged).
+//
+// As the new session replaces the old, it is not subject to MaxUserSessions.
+func (m *Manager[D]) Rotate(ctx context.Context, w http.ResponseWriter, s *Session[D]) (*Session[D], error) {
+	snew, err := m.create(ctx, w, s.Data, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rotated session: %w", err)
+	}
+	if err := m.deleteSession(ctx, s.ID); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+		m.opts.Logger.ErrorContext(ctx, "Failed to delete data for rotated session", "session", m.handle(s.ID), "error", err)
+	}
+	if m.Hooks.OnRotate != nil {
+		m.Hooks.OnRotate(ctx, s, snew)
+	}
+	return snew, nil
+}
+
+// ErrUpdateUnsupported indicates that the SessionStore used by Manager does not
+// implement store.Updater.
+var ErrUpdateUnsupported = errors.New("session store does not support update")
+
+// Extend extends the expiration of the provided Session by the configured TTL
+// from the current time, updating the SessionStore and refreshing the SID
+// cookie, and returns the extended Session. The SessionStore must implement
+// store.Updater, otherwise ErrUpdateUnsupported is returned.
+func (m *Manager[D]) Extend(ctx context.Context, w http.ResponseWriter, s *Session[D]) (*Session[D], error) {
+	if !store.Supports[store.Updater[Session[D]]](m.store) {
+		return nil, ErrUpdateUnsupported
+	}
+	u := m.store.(store.Updater[Session[D]])
+	snew := *s
+	snew.Expiration = m.Clock().Add(m.opts.TTL)
+	if err := u.Update(ctx, snew.ID, &snew, m.opts.TTL+sessionStorageGracePeriod); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil, ErrUpdateUnsupported
+		}
+		return nil, fmt.Errorf("failed to extend session: %w", err)
+	}
+	m.setSIDCookie(w, snew.ID)
+	if m.Hooks.OnExtend != nil {
+		m.Hooks.OnExtend(ctx, &snew)
+	}
+	return &snew, nil
+}
+
+// Destroy deletes the session associated with the provided SID from the
+// SessionStore, returning store.ErrSessionNotFound if no such session exists.
+// Unlike Clear, Destroy does not create a new pre-session or modify the SID
+// cookie, and is thus suitable for revoking sessions other than that of the
+// current request.
+func (m *Manager[D]) Destroy(ctx context.Context, sid string) error {
+	if err := m.deleteSession(ctx, sid); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// SessionHandle returns an opaque, stable identifier for the provided Session,
+// suitable for exposing to end users (e.g., to refer to a session to be
+// revoked) in place of the SID, from which the SID cannot be recovered.
+func (m *Manager[D]) SessionHandle(s *Session[D]) string {
+	return m.handle(s.ID)
+}
+
+// Logger returns the logger used by Manager (see Options.Logger).
+func (m *Manager[D]) Logger() *slog.Logger {
+	return m.opts.Logger
+}
+
+func (m *Manager[D]) handle(sid string) string {
+	h := hmac.New(sha256.New, m.hkey)
+	h.Write([]byte(sid))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:sessionHandleLen])
+}
+
 func (m *Manager[D]) setSIDCookie(w http.ResponseWriter, sid string) {
 	expires := m.Clock().Add(m.opts.TTL + sessionCookieGracePeriod)
 	http.SetCookie(w, m.opts.CreateCookie(m.opts.SessionCookieName, sid, expires))
@@ -283,6 +708,9 @@ func (m *Manager[D]) getSIDCookie(r *http.Request) (string, error) {
 		return "", err
 	}
 	if _, err := m.sta.Verify(c.Value); err != nil {
+		if m.Hooks.OnInvalidCookie != nil {
+			m.Hooks.OnInvalidCookie(r.Context(), err)
+		}
 		return "", err
 	}
 	return c.Value, nil
@@ -290,7 +718,27 @@ func (m *Manager[D]) getSIDCookie(r *http.Request) (string, error) {
 
 // VerifySessionCSRFToken verifies the authenticity of the provided CSRF token
 // and that it matches the expected value for the provided Session.
+// Consider using VerifySessionCSRFTokenContext, such that the OnCSRFFailure
+// hook receives the request Context.
 func (m *Manager[D]) VerifySessionCSRFToken(token string, s *Session[D]) error {
+	return m.VerifySessionCSRFTokenContext(context.Background(), token, s)
+}
+
+// VerifySessionCSRFTokenContext is equivalent to VerifySessionCSRFToken, but
+// additionally accepts the Context of the associated request.
+func (m *Manager[D]) VerifySessionCSRFTokenContext(ctx context.Context, token string, s *Session[D]) error {
+	err := m.verifySessionCSRFToken(token, s)
+	if err == nil {
+		return nil
+	}
+	m.opts.Metrics.ObserveCSRFFailure()
+	if m.Hooks.OnCSRFFailure != nil {
+		m.Hooks.OnCSRFFailure(ctx, s, err)
+	}
+	return err
+}
+
+func (m *Manager[D]) verifySessionCSRFToken(token string, s *Session[D]) error {
 	if _, err := m.cta.Verify(token); err != nil {
 		return fmt.Errorf("failed to validate CSRF token: %w", err)
 	}
@@ -301,22 +749,43 @@ func (m *Manager[D]) VerifySessionCSRFToken(token string, s *Session[D]) error {
 }
 
 func (m *Manager[D]) wrapHandler(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ci := &clientInfo{userAgent: r.UserAgent(), ip: m.opts.ClientIP(r)}
+	if m.opts.Fingerprint != nil {
+		ci.fingerprint = m.opts.Fingerprint.fingerprint(r, ci.ip)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyClient, ci))
 	var s *Session[D]
+	outcome := metrics.LookupHit
 	sid, err := m.getSIDCookie(r)
 	if err != nil {
 		// Regardless of the error reason, we'll create a pre-session below.
-		if !errors.Is(err, errNoSIDCookie) {
-			slog.Error("Failed to extract session cookie", "error", err)
+		if errors.Is(err, errNoSIDCookie) {
+			outcome = metrics.LookupNoCookie
+		} else {
+			outcome = metrics.LookupInvalidCookie
+			m.opts.Logger.ErrorContext(r.Context(), "Failed to extract session cookie", "error", err)
 		}
 	} else if cs, err := m.lookup(r.Context(), sid); err != nil {
-		slog.Debug("Failed to look up session for SID", "sid", sid, "error", err)
+		outcome = lookupOutcome(err)
+		if isStoreFailure(err) {
+			m.opts.Metrics.ObserveLookup(outcome)
+			m.handleStoreFailure(w, r, next, sid, err)
+			return
+		}
+		m.opts.Logger.DebugContext(r.Context(), "Failed to look up session for SID", "session", m.handle(sid), "error", err)
+	} else if m.checkFingerprint(r.Context(), cs, ci) {
+		s = m.refreshLastSeen(r.Context(), cs)
+		if m.Hooks.OnLoad != nil {
+			m.Hooks.OnLoad(r.Context(), s)
+		}
 	} else {
-		s = cs
+		outcome = metrics.LookupMismatch
 	}
+	m.opts.Metrics.ObserveLookup(outcome)
 	if s == nil {
 		ps, err := m.Create(r.Context(), w, nil)
 		if err != nil {
-			slog.Error("Failed to create session", "error", err)
+			m.opts.Logger.ErrorContext(r.Context(), "Failed to create session", "error", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
@@ -326,12 +795,172 @@ func (m *Manager[D]) wrapHandler(w http.ResponseWriter, r *http.Request, next ht
 	next.ServeHTTP(w, r.WithContext(ctx))
 }
 
+// isStoreFailure returns true if the provided error returned by lookup
+// indicates a failure of the SessionStore (e.g., an outage), as opposed to the
+// session not existing, having expired, or being unusable.
+func isStoreFailure(err error) bool {
+	return !errors.Is(err, store.ErrSessionNotFound) &&
+		!errors.Is(err, errExpiredSession) &&
+		!errors.Is(err, store.ErrInvalidStoredSessionData)
+}
+
+// handleStoreFailure handles a request for which the session associated with
+// the provided SID could not be looked up due to the provided SessionStore
+// failure, according to StoreFailurePolicy. The SID cookie is not modified.
+func (m *Manager[D]) handleStoreFailure(w http.ResponseWriter, r *http.Request, next http.Handler, sid string, err error) {
+	m.opts.Logger.ErrorContext(r.Context(), "Failed to look up session due to store failure", "session", m.handle(sid), "policy", m.opts.StoreFailurePolicy, "error", err)
+	switch m.opts.StoreFailurePolicy {
+	case PassWithoutSession:
+		next.ServeHTTP(w, r)
+	default:
+		m.opts.StoreFailureHandler(w, r, err)
+	}
+}
+
+// ErrUserIndexUnsupported indicates that the SessionStore used by Manager does
+// not implement store.UserIndex, or does not maintain its user index (see
+// store.UserIndexEnabled).
+var ErrUserIndexUnsupported = errors.New("session store does not support user index")
+
+// UserIDFunc adapts the provided function, which returns the identifier of the
+// user associated with a given Data payload, into one suitable for configuring
+// the user index of a SessionStore (e.g., memory.Options.UserID). Pre-sessions
+// (i.e., those with nil Data) are not associated with any user.
+func UserIDFunc[D any](fn func(*D) string) func(*Session[D]) string {
+	return func(s *Session[D]) string {
+		if s.Data == nil {
+			return ""
+		}
+		return fn(s.Data)
+	}
+}
+
+func (m *Manager[D]) userIndex() (store.UserIndex, error) {
+	if !store.Supports[store.UserIndex](m.store) || !store.UserIndexEnabled(m.store) {
+		return nil, ErrUserIndexUnsupported
+	}
+	return m.store.(store.UserIndex), nil
+}
+
+// ListUserSessions returns all unexpired sessions associated with the provided
+// user identifier, ordered by increasing expiration time. The SessionStore must
+// implement store.UserIndex, otherwise ErrUserIndexUnsupported is returned.
+func (m *Manager[D]) ListUserSessions(ctx context.Context, uid string) ([]*Session[D], error) {
+	ui, err := m.userIndex()
+	if err != nil {
+		return nil, err
+	}
+	sids, err := ui.UserSessions(ctx, uid)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, ErrUserIndexUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sessions for user: %w", err)
+	}
+	var ss []*Session[D]
+	for _, sid := range sids {
+		s, err := m.lookup(ctx, sid)
+		if err != nil {
+			if errors.Is(err, store.ErrSessionNotFound) || errors.Is(err, errExpiredSession) {
+				// The session was deleted or expired since the index was read.
+				continue
+			}
+			return nil, fmt.Errorf("failed to look up session for user: %w", err)
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// RevokeUserSessions deletes all sessions associated with the provided user
+// identifier from the SessionStore (e.g., in response to a password change),
+// returning the number of sessions deleted. The SessionStore must implement
+// store.UserIndex, otherwise ErrUserIndexUnsupported is returned.
+// Note that the SID cookie of the current request (if any) is not modified;
+// consider using Clear for the current session.
+func (m *Manager[D]) RevokeUserSessions(ctx context.Context, uid string) (int, error) {
+	ui, err := m.userIndex()
+	if err != nil {
+		return 0, err
+	}
+	sids, err := ui.UserSessions(ctx, uid)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return 0, ErrUserIndexUnsupported
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up sessions for user: %w", err)
+	}
+	var n int
+	for _, sid := range sids {
+		if err := m.deleteSession(ctx, sid); err != nil {
+			if errors.Is(err, store.ErrSessionNotFound) {
+				continue
+			}
+			return n, fmt.Errorf("failed to delete session for user: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// checkFingerprint verifies that the fingerprint of the provided client matches
+// that of the provided session (if any), returning false if the session should
+// not be used according to FingerprintPolicy.OnMismatch.
+func (m *Manager[D]) checkFingerprint(ctx context.Context, s *Session[D], ci *clientInfo) bool {
+	if m.opts.Fingerprint == nil || s.Fingerprint == "" || s.Fingerprint == ci.fingerprint {
+		return true
+	}
+	m.opts.Logger.WarnContext(ctx, "Session fingerprint mismatch", "session", m.handle(s.ID), "action", m.opts.Fingerprint.OnMismatch, "ip", ci.ip, "user_agent", ci.userAgent)
+	switch m.opts.Fingerprint.OnMismatch {
+	case FingerprintRequireReauth:
+		return false
+	case FingerprintRevoke:
+		if err := m.deleteSession(ctx, s.ID); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+			m.opts.Logger.ErrorContext(ctx, "Failed to delete session on fingerprint mismatch", "session", m.handle(s.ID), "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// refreshLastSeen updates Metadata.LastSeen for the provided session if at
+// least LastSeenInterval has elapsed since the last update, returning the
+// updated session (or the original, if no update was performed). Failure to
+// update is considered non-critical.
+func (m *Manager[D]) refreshLastSeen(ctx context.Context, s *Session[D]) *Session[D] {
+	if m.opts.LastSeenInterval < 0 {
+		return s
+	}
+	if !store.Supports[store.Updater[Session[D]]](m.store) {
+		return s
+	}
+	u := m.store.(store.Updater[Session[D]])
+	now := m.Clock()
+	if now.Sub(s.Metadata.LastSeen) < m.opts.LastSeenInterval {
+		return s
+	}
+	snew := *s
+	snew.Metadata.LastSeen = now
+	// Preserve the original storage expiration (i.e., this is not extension).
+	ttl := snew.Expiration.Add(sessionStorageGracePeriod).Sub(now)
+	if err := u.Update(ctx, snew.ID, &snew, ttl); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			return s
+		}
+		m.opts.Logger.ErrorContext(ctx, "Failed to update session last seen time", "error", err)
+		return s
+	}
+	return &snew
+}
+
 // Manage is a chi-compatible middleware that validates the session cookie,
 // looks up the associated session data, and stores it to the request Context
 // (which can be retrieved via Get).
-// If no session cookie is present, a pre-session (i.e., one with nil Data
-// payload) will be created. In other words, Manage ensures a session always
-// exists (with an associated CSRF token).
+// If no session cookie is present, or the associated session does not exist or
+// has expired, a pre-session (i.e., one with nil Data payload) will be created.
+// In other words, Manage ensures a session always exists (with an associated
+// CSRF token), unless the session cannot be looked up due to a SessionStore
+// failure, which is handled according to Options.StoreFailurePolicy.
 func (m *Manager[D]) Manage(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		m.wrapHandler(w, r, next)
@@ -339,7 +968,8 @@ func (m *Manager[D]) Manage(next http.Handler) http.Handler {
 }
 
 // Get returns the Session object instance stored in the provided request
-// Context by the Manage middleware.
+// Context by the Manage middleware, or nil if there is none (e.g., under the
+// PassWithoutSession StoreFailurePolicy).
 func Get[D any](ctx context.Context) *Session[D] {
 	s := ctx.Value(contextKeySession)
 	if s == nil {
@@ -347,3 +977,10 @@ func Get[D any](ctx context.Context) *Session[D] {
 	}
 	return s.(*Session[D])
 }
+
+// NewContext returns a copy of the provided Context carrying the provided
+// Session, such that Get returns it. This is primarily useful for testing
+// handlers without the Manage middleware (see package sessiontest).
+func NewContext[D any](ctx context.Context, s *Session[D]) context.Context {
+	return context.WithValue(ctx, contextKeySession, s)
+}
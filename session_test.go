@@ -1,20 +1,31 @@
 package session_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	session "github.com/swfrench/simple-session"
 	"github.com/swfrench/simple-session/internal/testutil"
+	"github.com/swfrench/simple-session/metrics"
+	"github.com/swfrench/simple-session/retry"
 	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/fault"
+	"github.com/swfrench/simple-session/store/memory"
+	"github.com/swfrench/simple-session/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // fakeSessionData is the fake data payload type used in tests below.
@@ -22,51 +33,17 @@ type fakeSessionData struct {
 	Greeting string `json:"greeting"`
 }
 
-// stubStore is a stub implementation of the SessionStore interface.
-type stubStore[S any] struct {
-	sessions map[string]*S
-	getErr   func() error
-	setErr   func() error
-	delErr   func() error
-}
-
-func newStubStore[S any]() *stubStore[S] {
-	return &stubStore[S]{
-		sessions: make(map[string]*S),
-		getErr:   func() error { return nil },
-		setErr:   func() error { return nil },
-		delErr:   func() error { return nil },
-	}
-}
-
-func (s *stubStore[S]) Get(ctx context.Context, sid string) (*S, error) {
-	if err := s.getErr(); err != nil {
-		return nil, err
-	}
-	sess, ok := s.sessions[sid]
-	if !ok {
-		return nil, store.ErrSessionNotFound
-	}
-	return sess, nil
-}
+// errBadger is a permanent (i.e., non-retryable) store error.
+var errBadger = store.MarkPermanent(errors.New("badger"))
 
-func (s *stubStore[S]) Set(ctx context.Context, sid string, sess *S, ttl time.Duration) error {
-	if err := s.setErr(); err != nil {
-		return err
-	}
-	if _, ok := s.sessions[sid]; ok {
-		return store.ErrSessionExists
-	}
-	s.sessions[sid] = sess
-	return nil
+// basicStore is a SessionStore implementing none of the optional store
+// interfaces (e.g., store.Updater), regardless of the wrapped SessionStore.
+type basicStore[S any] struct {
+	store.SessionStore[S]
 }
 
-func (s *stubStore[S]) Del(ctx context.Context, sid string) error {
-	if err := s.delErr(); err != nil {
-		return err
-	}
-	delete(s.sessions, sid)
-	return nil
+func newBasicStore[S any]() *basicStore[S] {
+	return &basicStore[S]{SessionStore: memory.New[S]()}
 }
 
 // Secure must be false, as we do not configure TLS on our httptest.Server.
@@ -83,7 +60,8 @@ func sessionOptions() *session.Options {
 }
 
 type sessionRunner struct {
-	store      *stubStore[session.Session[fakeSessionData]]
+	mem        *memory.Store[session.Session[fakeSessionData]]
+	store      *fault.Store[session.Session[fakeSessionData]]
 	sm         *session.Manager[fakeSessionData]
 	ctxSession *session.Session[fakeSessionData]
 	srv        *httptest.Server
@@ -91,11 +69,13 @@ type sessionRunner struct {
 	jar        http.CookieJar
 	client     *http.Client
 	handler    http.HandlerFunc
+	userAgent  string
 }
 
 func mustCreateSessionRunner(t *testing.T, opts *session.Options) *sessionRunner {
 	sr := new(sessionRunner)
-	sr.store = newStubStore[session.Session[fakeSessionData]]()
+	sr.mem = memory.New[session.Session[fakeSessionData]]()
+	sr.store = fault.New[session.Session[fakeSessionData]](sr.mem)
 	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
 	var err error
 	sr.sm, err = session.NewManager[fakeSessionData](sr.store, k, opts)
@@ -133,6 +113,9 @@ func (sr *sessionRunner) run(t *testing.T, h http.HandlerFunc) *http.Response {
 	if err != nil {
 		t.Fatalf("NewRequest() returned unexpected error: %v", err)
 	}
+	if sr.userAgent != "" {
+		r.Header.Set("User-Agent", sr.userAgent)
+	}
 	resp, err := sr.client.Do(r)
 	if err != nil {
 		t.Fatalf("Client.Do() returned unexpected error: %v", err)
@@ -282,7 +265,7 @@ func TestCreatesNewPreSessionWhenNotFound(t *testing.T) {
 		t.Fatal("Session cookie missing from response")
 	}
 
-	sr.store.getErr = func() error { return store.ErrSessionNotFound }
+	sr.store.Fail(store.ErrSessionNotFound, store.OpGet)
 
 	// Verify that the session cookie changes on the next request (i.e., a new
 	// session is created).
@@ -293,7 +276,7 @@ func TestCreatesNewPreSessionWhenNotFound(t *testing.T) {
 	}
 }
 
-func TestCreatesNewPreSessionWhenLookupFails(t *testing.T) {
+func TestCreatesNewPreSessionWhenStoredDataInvalid(t *testing.T) {
 	sr := mustCreateSessionRunner(t, sessionOptions())
 	defer sr.close()
 
@@ -305,7 +288,7 @@ func TestCreatesNewPreSessionWhenLookupFails(t *testing.T) {
 		t.Fatal("Session cookie missing from response")
 	}
 
-	sr.store.getErr = func() error { return errors.New("badger") }
+	sr.store.Fail(store.ErrInvalidStoredSessionData, store.OpGet)
 
 	// Verify that the session cookie changes on the next request (i.e., a new
 	// session is created).
@@ -316,11 +299,84 @@ func TestCreatesNewPreSessionWhenLookupFails(t *testing.T) {
 	}
 }
 
+func TestStoreFailureDuringLookup(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  session.StoreFailurePolicy
+		handler func(w http.ResponseWriter, r *http.Request, err error)
+		status  int
+		invoked bool
+	}{
+		{
+			name:   "serve default",
+			policy: session.ServeStoreFailure,
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "serve custom",
+			policy: session.ServeStoreFailure,
+			handler: func(w http.ResponseWriter, r *http.Request, err error) {
+				if !errors.Is(err, errBadger) {
+					t.Errorf("StoreFailureHandler invoked with unexpected error - got: %v want: %v", err, errBadger)
+				}
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			status: http.StatusBadGateway,
+		},
+		{
+			name:    "pass without session",
+			policy:  session.PassWithoutSession,
+			status:  http.StatusTeapot,
+			invoked: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := sessionOptions()
+			opts.StoreFailurePolicy = tc.policy
+			opts.StoreFailureHandler = tc.handler
+			sr := mustCreateSessionRunner(t, opts)
+			defer sr.close()
+
+			sr.run(t, nil)
+			sc1 := sr.getSessionCookie()
+			if sc1 == nil {
+				t.Fatal("Session cookie missing from response")
+			}
+
+			sr.store.Fail(errBadger, store.OpGet)
+			var invoked bool
+			resp := sr.run(t, func(w http.ResponseWriter, r *http.Request) {
+				invoked = true
+				if s := session.Get[fakeSessionData](r.Context()); s != nil {
+					t.Errorf("Get() returned unexpected session within handler: %+v", s)
+				}
+			})
+			if got, want := resp.StatusCode, tc.status; got != want {
+				t.Errorf("Unexpected status code under store failure - got: %d want: %d", got, want)
+			}
+			if got, want := invoked, tc.invoked; got != want {
+				t.Errorf("Unexpected handler invocation under store failure - got: %v want: %v", got, want)
+			}
+			if got := resp.Header.Get("Set-Cookie"); got != "" {
+				t.Errorf("Set-Cookie header unexpectedly present under store failure: %s", got)
+			}
+
+			// Verify that the session is used once the store recovers.
+			sr.store.Reset()
+			sr.run(t, nil)
+			if got, want := sr.ctxSession.ID, sc1.Value; got != want {
+				t.Errorf("Unexpected session after store recovery - got: %q want: %q", got, want)
+			}
+		})
+	}
+}
+
 func TestCreatePreSessionFailsOnPersistentSetError(t *testing.T) {
 	sr := mustCreateSessionRunner(t, sessionOptions())
 	defer sr.close()
 
-	sr.store.setErr = func() error { return errors.New("gremlins") }
+	sr.store.Fail(errors.New("gremlins"), store.OpSet)
 
 	// Verify that persistent Set errors result in failed session creation and a
 	// 503 response. Further, no cookie is provided to the client.
@@ -337,12 +393,7 @@ func TestCreatePreSessionSucceedsOnTransientSetError(t *testing.T) {
 	sr := mustCreateSessionRunner(t, sessionOptions())
 	defer sr.close()
 
-	setErr := errors.New("gremlins")
-	sr.store.setErr = func() error {
-		var err error
-		err, setErr = setErr, nil
-		return err
-	}
+	sr.store.Inject(fault.Rule{Ops: []string{store.OpSet}, Err: errors.New("gremlins"), FirstN: 1})
 
 	// Verify that a transient Set error results in successful session creation.
 	// Further, the session cookie is provided to the client as expected.
@@ -475,7 +526,7 @@ func TestClearSessionSucceedsOnDelError(t *testing.T) {
 		t.Fatal("Session cookie missing from response")
 	}
 
-	sr.store.delErr = func() error { return errors.New("gremlins") }
+	sr.store.Fail(errors.New("gremlins"), store.OpDel)
 
 	// Run the next request, attempting to clear the prior session.
 	sr.run(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -504,7 +555,7 @@ func TestClearSessionFailsOnSetError(t *testing.T) {
 		t.Fatal("Session cookie missing from response")
 	}
 
-	sr.store.setErr = func() error { return errors.New("gremlins") }
+	sr.store.Fail(errors.New("gremlins"), store.OpSet)
 
 	// Run the next request, attempting to clear the prior session.
 	sr.run(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -532,7 +583,7 @@ func TestClearSessionSucceedsOnSessionNotFound(t *testing.T) {
 		t.Fatal("Session cookie missing from response")
 	}
 
-	sr.store.delErr = func() error { return store.ErrSessionNotFound }
+	sr.store.Fail(store.ErrSessionNotFound, store.OpDel)
 
 	// Run the next request, clearing the prior session.
 	sr.run(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -657,3 +708,706 @@ func TestGetWithEmptyContext(t *testing.T) {
 		t.Errorf("Get() returned unexpected value for empty context - got: %v want: %v", got, want)
 	}
 }
+
+func mustCreateIndexedManager(t *testing.T, opts *session.Options) *session.Manager[fakeSessionData] {
+	ms := memory.NewWithOptions(&memory.Options[session.Session[fakeSessionData]]{
+		UserID: session.UserIDFunc(func(d *fakeSessionData) string { return d.Greeting }),
+	})
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	sm, err := session.NewManager[fakeSessionData](ms, k, opts)
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	return sm
+}
+
+func TestListAndRevokeUserSessions(t *testing.T) {
+	sm := mustCreateIndexedManager(t, sessionOptions())
+	ctx := context.Background()
+
+	want := make(map[string]bool)
+	for _, greeting := range []string{"hola", "hola", "hello", ""} {
+		var data *fakeSessionData
+		if greeting != "" {
+			data = &fakeSessionData{Greeting: greeting}
+		}
+		s, err := sm.Create(ctx, httptest.NewRecorder(), data)
+		if err != nil {
+			t.Fatalf("Create() returned unexpected error: %v", err)
+		}
+		if greeting == "hola" {
+			want[s.ID] = true
+		}
+	}
+
+	ss, err := sm.ListUserSessions(ctx, "hola")
+	if err != nil {
+		t.Fatalf("ListUserSessions() returned unexpected error: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, s := range ss {
+		got[s.ID] = true
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ListUserSessions() returned unexpected sessions (+got, -want):\n%s", diff)
+	}
+
+	n, err := sm.RevokeUserSessions(ctx, "hola")
+	if err != nil {
+		t.Fatalf("RevokeUserSessions() returned unexpected error: %v", err)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("RevokeUserSessions() revoked unexpected number of sessions - got: %d want: %d", got, want)
+	}
+	if ss, err := sm.ListUserSessions(ctx, "hola"); err != nil || len(ss) != 0 {
+		t.Errorf("ListUserSessions() after revocation - got: %v, %v want: no sessions", ss, err)
+	}
+	if ss, err := sm.ListUserSessions(ctx, "hello"); err != nil || len(ss) != 1 {
+		t.Errorf("ListUserSessions() for other user after revocation - got: %v, %v want: 1 session", ss, err)
+	}
+}
+
+func TestUserSessionsUnsupported(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	if _, err := sr.sm.ListUserSessions(context.Background(), "hola"); !errors.Is(err, session.ErrUserIndexUnsupported) {
+		t.Errorf("ListUserSessions() returned unexpected error - got: %v want: %v", err, session.ErrUserIndexUnsupported)
+	}
+	if _, err := sr.sm.RevokeUserSessions(context.Background(), "hola"); !errors.Is(err, session.ErrUserIndexUnsupported) {
+		t.Errorf("RevokeUserSessions() returned unexpected error - got: %v want: %v", err, session.ErrUserIndexUnsupported)
+	}
+}
+
+func TestWrappedStoreUnsupported(t *testing.T) {
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	// The wrapping store implements store.UserIndex, but the basic store does
+	// not.
+	ms := metrics.NewStore[session.Session[fakeSessionData]](newBasicStore[session.Session[fakeSessionData]](), "basic", metrics.Nop{})
+	sm, err := session.NewManager[fakeSessionData](ms, k, sessionOptions())
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	if _, err := sm.ListUserSessions(context.Background(), "hola"); !errors.Is(err, session.ErrUserIndexUnsupported) {
+		t.Errorf("ListUserSessions() returned unexpected error - got: %v want: %v", err, session.ErrUserIndexUnsupported)
+	}
+	if _, err := sm.RevokeUserSessions(context.Background(), "hola"); !errors.Is(err, session.ErrUserIndexUnsupported) {
+		t.Errorf("RevokeUserSessions() returned unexpected error - got: %v want: %v", err, session.ErrUserIndexUnsupported)
+	}
+}
+
+func TestUserSessionLimit(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  session.UserSessionLimitPolicy
+		wantErr bool
+	}{
+		{
+			name:    "reject",
+			policy:  session.RejectNewSession,
+			wantErr: true,
+		},
+		{
+			name:   "evict oldest",
+			policy: session.EvictOldestSession,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := sessionOptions()
+			opts.MaxUserSessions = 2
+			opts.UserSessionLimitPolicy = tc.policy
+			ms := memory.NewWithOptions(&memory.Options[session.Session[fakeSessionData]]{
+				UserID: session.UserIDFunc(func(d *fakeSessionData) string { return d.Greeting }),
+			})
+			k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+			sm, err := session.NewManager[fakeSessionData](ms, k, opts)
+			if err != nil {
+				t.Fatalf("NewManager() returned unexpected error: %v", err)
+			}
+			ctx := context.Background()
+			// The Manager and store share a clock, such that expiration
+			// (which determines eviction order) follows session creation.
+			now := time.Now()
+			clock := func() time.Time { return now }
+			sm.Clock = clock
+			ms.Clock = clock
+
+			// Create the first session a minute later than the second, such
+			// that the second is the oldest (i.e., soonest to expire).
+			base := now
+			var sids []string
+			for _, offset := range []time.Duration{time.Minute, 0} {
+				now = base.Add(offset)
+				s, err := sm.Create(ctx, httptest.NewRecorder(), &fakeSessionData{Greeting: "hola"})
+				if err != nil {
+					t.Fatalf("Create() returned unexpected error: %v", err)
+				}
+				sids = append(sids, s.ID)
+			}
+			oldest, newest := sids[1], sids[0]
+
+			// Pre-sessions and sessions of other users are unaffected.
+			for _, data := range []*fakeSessionData{nil, {Greeting: "hello"}} {
+				if _, err := sm.Create(ctx, httptest.NewRecorder(), data); err != nil {
+					t.Fatalf("Create() returned unexpected error: %v", err)
+				}
+			}
+
+			now = base.Add(2 * time.Minute)
+			s, err := sm.Create(ctx, httptest.NewRecorder(), &fakeSessionData{Greeting: "hola"})
+			want := []string{oldest, newest}
+			if tc.wantErr {
+				var limitErr *session.UserSessionLimitError
+				if !errors.As(err, &limitErr) {
+					t.Fatalf("Create() returned unexpected error - got: %v want: %T", err, limitErr)
+				}
+				if !errors.Is(err, store.ErrUserSessionLimit) {
+					t.Errorf("Create() returned error not matching %v: %v", store.ErrUserSessionLimit, err)
+				}
+				if got, want := limitErr.Limit, 2; got != want {
+					t.Errorf("UserSessionLimitError has unexpected Limit - got: %d want: %d", got, want)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Create() returned unexpected error: %v", err)
+				}
+				want = []string{newest, s.ID}
+				if _, err := ms.Get(ctx, oldest); !errors.Is(err, store.ErrSessionNotFound) {
+					t.Errorf("Get() returned unexpected error for evicted session - got: %v want: %v", err, store.ErrSessionNotFound)
+				}
+			}
+			if _, err := ms.Get(ctx, newest); err != nil {
+				t.Errorf("Get() returned unexpected error for retained session: %v", err)
+			}
+
+			ss, err := sm.ListUserSessions(ctx, "hola")
+			if err != nil {
+				t.Fatalf("ListUserSessions() returned unexpected error: %v", err)
+			}
+			var got []string
+			for _, s := range ss {
+				got = append(got, s.ID)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("ListUserSessions() returned unexpected sessions (+got, -want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUserSessionLimitParallel(t *testing.T) {
+	opts := sessionOptions()
+	opts.MaxUserSessions = 3
+	sm := mustCreateIndexedManager(t, opts)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sm.Create(ctx, httptest.NewRecorder(), &fakeSessionData{Greeting: "hola"})
+		}()
+	}
+	wg.Wait()
+
+	ss, err := sm.ListUserSessions(ctx, "hola")
+	if err != nil {
+		t.Fatalf("ListUserSessions() returned unexpected error: %v", err)
+	}
+	if got, want := len(ss), 3; got != want {
+		t.Errorf("Parallel Create() produced unexpected number of sessions - got: %d want: %d", got, want)
+	}
+}
+
+func TestUserSessionLimitUnsupported(t *testing.T) {
+	opts := sessionOptions()
+	opts.MaxUserSessions = 2
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	if _, err := session.NewManager[fakeSessionData](newBasicStore[session.Session[fakeSessionData]](), k, opts); err == nil {
+		t.Error("NewManager() unexpectedly succeeded with MaxUserSessions and a store not implementing UserSessionLimiter")
+	}
+	// Wrapped stores implement UserSessionLimiter regardless of the wrapped
+	// store, but must still be rejected.
+	ms := metrics.NewStore[session.Session[fakeSessionData]](newBasicStore[session.Session[fakeSessionData]](), "basic", metrics.Nop{})
+	if _, err := session.NewManager[fakeSessionData](ms, k, opts); err == nil {
+		t.Error("NewManager() unexpectedly succeeded with MaxUserSessions and a wrapped store not implementing UserSessionLimiter")
+	}
+	// Stores implementing UserSessionLimiter, but not configured to maintain
+	// a user index, must also be rejected.
+	for _, s := range []store.SessionStore[session.Session[fakeSessionData]]{
+		memory.New[session.Session[fakeSessionData]](),
+		metrics.NewStore[session.Session[fakeSessionData]](memory.New[session.Session[fakeSessionData]](), "memory", metrics.Nop{}),
+	} {
+		if _, err := session.NewManager[fakeSessionData](s, k, opts); err == nil {
+			t.Error("NewManager() unexpectedly succeeded with MaxUserSessions and a store without UserID")
+		}
+	}
+	sm, err := session.NewManager[fakeSessionData](memory.New[session.Session[fakeSessionData]](), k, sessionOptions())
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	if _, err := sm.ListUserSessions(context.Background(), "alice"); !errors.Is(err, session.ErrUserIndexUnsupported) {
+		t.Errorf("ListUserSessions() returned unexpected error - got: %v want: %v", err, session.ErrUserIndexUnsupported)
+	}
+}
+
+func TestSessionHandleAndDestroy(t *testing.T) {
+	sm := mustCreateIndexedManager(t, sessionOptions())
+	ctx := context.Background()
+
+	s1, err := sm.Create(ctx, httptest.NewRecorder(), &fakeSessionData{Greeting: "hola"})
+	if err != nil {
+		t.Fatalf("Create() returned unexpected error: %v", err)
+	}
+	s2, err := sm.Create(ctx, httptest.NewRecorder(), &fakeSessionData{Greeting: "hola"})
+	if err != nil {
+		t.Fatalf("Create() returned unexpected error: %v", err)
+	}
+
+	// Verify that handles are stable, distinct, and do not reveal the SID.
+	h1 := sm.SessionHandle(s1)
+	if got, want := sm.SessionHandle(s1), h1; got != want {
+		t.Errorf("SessionHandle() is not stable - got: %q want: %q", got, want)
+	}
+	if h1 == sm.SessionHandle(s2) {
+		t.Errorf("SessionHandle() returned identical handles for distinct sessions: %q", h1)
+	}
+	if strings.Contains(s1.ID, h1) || strings.Contains(h1, s1.ID) {
+		t.Errorf("SessionHandle() %q unexpectedly related to SID %q", h1, s1.ID)
+	}
+
+	if err := sm.Destroy(ctx, s1.ID); err != nil {
+		t.Fatalf("Destroy() returned unexpected error: %v", err)
+	}
+	if err := sm.Destroy(ctx, s1.ID); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Destroy() returned unexpected error for destroyed session - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	ss, err := sm.ListUserSessions(ctx, "hola")
+	if err != nil {
+		t.Fatalf("ListUserSessions() returned unexpected error: %v", err)
+	}
+	if len(ss) != 1 || ss[0].ID != s2.ID {
+		t.Errorf("ListUserSessions() after Destroy() returned unexpected sessions: %v", ss)
+	}
+}
+
+func TestSessionMetadata(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	now := time.Now()
+	sr.sm.Clock = func() time.Time { return now }
+
+	sr.run(t, nil)
+	if sr.ctxSession == nil {
+		t.Fatal("Get() returned nil Session within handler")
+	}
+	sid := sr.ctxSession.ID
+
+	// Verify that client details were captured at creation.
+	want := session.Metadata{
+		CreatedAt: now,
+		LastSeen:  now,
+		UserAgent: "Go-http-client/1.1",
+		IP:        "127.0.0.1",
+	}
+	if diff := cmp.Diff(want, sr.ctxSession.Metadata); diff != "" {
+		t.Errorf("Session has unexpected metadata (+got, -want):\n%s", diff)
+	}
+
+	// Verify that LastSeen is not refreshed within LastSeenInterval.
+	sr.sm.Clock = func() time.Time { return now.Add(time.Minute) }
+	sr.run(t, nil)
+	if got, want := sr.ctxSession.Metadata.LastSeen, now; !got.Equal(want) {
+		t.Errorf("Unexpected LastSeen within refresh interval - got: %v want: %v", got, want)
+	}
+
+	// Verify that LastSeen is refreshed, in both the context and stored
+	// session, once LastSeenInterval has elapsed.
+	later := now.Add(6 * time.Minute)
+	sr.sm.Clock = func() time.Time { return later }
+	sr.run(t, nil)
+	if got, want := sr.ctxSession.ID, sid; got != want {
+		t.Fatalf("Unexpected change in session ID - got: %q want: %q", got, want)
+	}
+	if got, want := sr.ctxSession.Metadata.LastSeen, later; !got.Equal(want) {
+		t.Errorf("Unexpected LastSeen in context session after refresh interval - got: %v want: %v", got, want)
+	}
+	stored, err := sr.mem.Get(context.Background(), sid)
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if got, want := stored.Metadata.LastSeen, later; !got.Equal(want) {
+		t.Errorf("Unexpected LastSeen in stored session after refresh interval - got: %v want: %v", got, want)
+	}
+	if got, want := sr.ctxSession.Metadata.CreatedAt, now; !got.Equal(want) {
+		t.Errorf("Unexpected change in CreatedAt - got: %v want: %v", got, want)
+	}
+}
+
+func TestSessionMetadataUpdateFailure(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	now := time.Now()
+	sr.sm.Clock = func() time.Time { return now }
+	sr.run(t, nil)
+	sid := sr.ctxSession.ID
+
+	// Verify that failure to refresh LastSeen does not disrupt the session.
+	sr.store.Fail(errors.New("gremlins"), store.OpUpdate)
+	sr.sm.Clock = func() time.Time { return now.Add(6 * time.Minute) }
+	resp := sr.run(t, nil)
+	if got, want := resp.StatusCode, http.StatusTeapot; got != want {
+		t.Errorf("Request with failed LastSeen refresh returned unexpected status - got: %d want: %d", got, want)
+	}
+	if got, want := sr.ctxSession.ID, sid; got != want {
+		t.Errorf("Unexpected change in session ID - got: %q want: %q", got, want)
+	}
+}
+
+func TestFingerprintMismatch(t *testing.T) {
+	const (
+		firefox    = "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"
+		firefoxNew = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+		chrome     = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	)
+	testCases := []struct {
+		name        string
+		action      session.FingerprintAction
+		userAgent   string
+		wantSame    bool
+		wantDeleted bool
+	}{
+		{
+			name:      "same family",
+			action:    session.FingerprintRevoke,
+			userAgent: firefoxNew,
+			wantSame:  true,
+		},
+		{
+			name:      "log only",
+			action:    session.FingerprintLogOnly,
+			userAgent: chrome,
+			wantSame:  true,
+		},
+		{
+			name:      "require reauth",
+			action:    session.FingerprintRequireReauth,
+			userAgent: chrome,
+		},
+		{
+			name:        "revoke",
+			action:      session.FingerprintRevoke,
+			userAgent:   chrome,
+			wantDeleted: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := sessionOptions()
+			opts.Fingerprint = &session.FingerprintPolicy{
+				IPv4PrefixLen: 24,
+				IPv6PrefixLen: 64,
+				UserAgent:     true,
+				OnMismatch:    tc.action,
+			}
+			sr := mustCreateSessionRunner(t, opts)
+			defer sr.close()
+
+			sr.userAgent = firefox
+			sr.run(t, nil)
+			sid := sr.ctxSession.ID
+			if sr.ctxSession.Fingerprint == "" {
+				t.Fatal("Session created by Manage has no fingerprint")
+			}
+
+			sr.userAgent = tc.userAgent
+			sr.run(t, nil)
+			if got := sr.ctxSession.ID == sid; got != tc.wantSame {
+				t.Errorf("Unexpected session reuse after fingerprint change - got: %t want: %t", got, tc.wantSame)
+			}
+			if got := sr.getSessionCookie().Value == sid; got != tc.wantSame {
+				t.Errorf("Unexpected session cookie reuse after fingerprint change - got: %t want: %t", got, tc.wantSame)
+			}
+			_, err := sr.mem.Get(context.Background(), sid)
+			if ok := err == nil; ok == tc.wantDeleted {
+				t.Errorf("Unexpected original session presence in store - got: %t want: %t", ok, !tc.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestLoggerRedactsSessionIDs(t *testing.T) {
+	var buf bytes.Buffer
+	opts := sessionOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	sr := mustCreateSessionRunner(t, opts)
+	defer sr.close()
+
+	// Create a session, and then clear it while its deletion fails.
+	sr.run(t, nil)
+	first := sr.ctxSession
+	sr.store.Fail(errors.New("nope"), store.OpDel)
+	sr.run(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := sr.sm.Clear(r.Context(), w, first.ID); err != nil {
+			t.Errorf("Clear() returned unexpected error: %v", err)
+		}
+	})
+
+	// Drop the replacement session from the store, such that lookup fails.
+	second := sr.getSessionCookie().Value
+	if err := sr.mem.Del(context.Background(), second); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	sr.run(t, nil)
+
+	logs := buf.String()
+	for _, sid := range []string{first.ID, second} {
+		if strings.Contains(logs, sid) {
+			t.Errorf("Logs unexpectedly contain SID %q:\n%s", sid, logs)
+		}
+		if h := sr.sm.SessionHandle(&session.Session[fakeSessionData]{ID: sid}); !strings.Contains(logs, h) {
+			t.Errorf("Logs do not contain session handle %q:\n%s", h, logs)
+		}
+	}
+}
+
+type fakeRecorder struct {
+	metrics.Nop
+	mu     sync.Mutex
+	events []string
+}
+
+func (fr *fakeRecorder) record(event string) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.events = append(fr.events, event)
+}
+
+func (fr *fakeRecorder) take() []string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	events := fr.events
+	fr.events = nil
+	return events
+}
+
+func (fr *fakeRecorder) ObserveCreate(outcome string, attempts int) {
+	fr.record(fmt.Sprintf("create:%s:%d", outcome, attempts))
+}
+
+func (fr *fakeRecorder) ObserveLookup(outcome string) {
+	fr.record("lookup:" + outcome)
+}
+
+func (fr *fakeRecorder) ObserveCSRFFailure() {
+	fr.record("csrf-failure")
+}
+
+func TestMetrics(t *testing.T) {
+	rec := new(fakeRecorder)
+	opts := sessionOptions()
+	opts.Metrics = rec
+	sr := mustCreateSessionRunner(t, opts)
+	defer sr.close()
+
+	testCases := []struct {
+		name    string
+		arrange func()
+		handler http.HandlerFunc
+		want    []string
+	}{
+		{
+			name: "no cookie",
+			want: []string{"lookup:no_cookie", "create:ok:1"},
+		},
+		{
+			name: "hit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				s := session.Get[fakeSessionData](r.Context())
+				sr.sm.VerifySessionCSRFTokenContext(r.Context(), "nope", s)
+			},
+			want: []string{"lookup:hit", "csrf-failure"},
+		},
+		{
+			name: "miss",
+			arrange: func() {
+				if err := sr.mem.Del(context.Background(), sr.getSessionCookie().Value); err != nil {
+					t.Fatalf("Del() returned unexpected error: %v", err)
+				}
+			},
+			want: []string{"lookup:miss", "create:ok:1"},
+		},
+		{
+			name: "create error",
+			arrange: func() {
+				sr.jar.SetCookies(sr.srvURL, []*http.Cookie{createNotSecureCookie("session", "nope", time.Now().Add(time.Hour))})
+				sr.store.Fail(store.ErrInvalidSessionData, store.OpSet)
+			},
+			want: []string{"lookup:invalid_cookie", "create:error:1"},
+		},
+	}
+	for _, tc := range testCases {
+		if tc.arrange != nil {
+			tc.arrange()
+		}
+		sr.run(t, tc.handler)
+		if diff := cmp.Diff(tc.want, rec.take()); diff != "" {
+			t.Errorf("Unexpected metrics for %s (+got, -want):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	opts := sessionOptions()
+	opts.Tracer = tracing.NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	sr := mustCreateSessionRunner(t, opts)
+	defer sr.close()
+	// Retry delays are measured using the Manager's Clock.
+	now := time.Now()
+	sr.sm.Clock = func() time.Time { return now }
+
+	// Fail the first attempt to store the new session, such that it is
+	// retried.
+	sr.store.Inject(fault.Rule{Ops: []string{store.OpSet}, Err: store.ErrSessionExists, FirstN: 1})
+	sr.run(t, nil)
+	first := sr.ctxSession.ID
+	sr.run(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := sr.sm.Clear(r.Context(), w, first); err != nil {
+			t.Errorf("Clear() returned unexpected error: %v", err)
+		}
+	})
+	second := sr.getSessionCookie().Value
+
+	type spanSummary struct {
+		Name    string
+		Outcome string
+		IDHash  string
+		Events  []string
+	}
+	var got []spanSummary
+	for _, span := range exp.GetSpans() {
+		ss := spanSummary{Name: span.Name}
+		for _, kv := range span.Attributes {
+			switch v := kv.Value.Emit(); kv.Key {
+			case tracing.OutcomeKey:
+				ss.Outcome = v
+			case tracing.IDHashKey:
+				ss.IDHash = v
+			}
+			if strings.Contains(kv.Value.Emit(), first) || strings.Contains(kv.Value.Emit(), second) {
+				t.Errorf("Span %s attribute %s unexpectedly contains SID", span.Name, kv.Key)
+			}
+		}
+		for _, e := range span.Events {
+			name := e.Name
+			for _, kv := range e.Attributes {
+				if kv.Key == tracing.RetryDelayKey {
+					name += ":" + kv.Value.Emit()
+				}
+			}
+			ss.Events = append(ss.Events, name)
+		}
+		got = append(got, ss)
+	}
+	want := []spanSummary{
+		{Name: "Manager.Create", Outcome: "ok", IDHash: tracing.HashSID(first), Events: []string{tracing.RetryEvent + ":0s"}},
+		{Name: "Manager.lookup", Outcome: "hit", IDHash: tracing.HashSID(first)},
+		{Name: "Manager.Create", Outcome: "ok", IDHash: tracing.HashSID(second)},
+		{Name: "Manager.Clear", Outcome: "ok", IDHash: tracing.HashSID(first)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected spans (+got, -want):\n%s", diff)
+	}
+}
+
+func TestCreateStopsRetryingWhenContextDone(t *testing.T) {
+	sr := mustCreateSessionRunner(t, sessionOptions())
+	defer sr.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Simulate the client going away during the first attempt, which the
+	// Manager Clock is consulted within.
+	sr.sm.Clock = func() time.Time {
+		cancel()
+		return time.Now()
+	}
+	sr.store.Fail(errors.New("transient"), store.OpSet)
+	_, err := sr.sm.Create(ctx, httptest.NewRecorder(), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Create() returned unexpected error - got: %v want: %v", err, context.Canceled)
+	}
+	if got, want := sr.store.CallCount(store.OpSet), 1; got != want {
+		t.Errorf("Create() made an unexpected number of attempts - got: %d want: %d", got, want)
+	}
+}
+
+func TestCreateRetryPolicyOnRetry(t *testing.T) {
+	opts := sessionOptions()
+	var retries []int
+	opts.RetryPolicy = retry.FullJitter{
+		Base:    time.Millisecond,
+		OnRetry: func(attempt int, _ time.Duration) { retries = append(retries, attempt) },
+	}
+	sr := mustCreateSessionRunner(t, opts)
+	defer sr.close()
+
+	// Fail the first Set only.
+	sr.store.Inject(fault.Rule{Ops: []string{store.OpSet}, Err: store.MarkRetryable(errors.New("transient")), FirstN: 1})
+	if _, err := sr.sm.Create(context.Background(), httptest.NewRecorder(), nil); err != nil {
+		t.Fatalf("Create() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]int{2}, retries); diff != "" {
+		t.Errorf("OnRetry() invoked with unexpected attempts (+got, -want):\n%s", diff)
+	}
+}
+
+func TestLookupRetriesTransientErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		attempts int
+		retained bool
+	}{
+		{
+			name:     "retryable",
+			err:      store.MarkRetryable(errors.New("transient")),
+			attempts: 2,
+			retained: true,
+		},
+		{
+			name:     "permanent",
+			err:      store.MarkPermanent(errors.New("permanent")),
+			attempts: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := sessionOptions()
+			opts.RetryPolicy = retry.FullJitter{Base: time.Millisecond}
+			opts.RetryAttempts = 3
+			sr := mustCreateSessionRunner(t, opts)
+			defer sr.close()
+
+			sr.run(t, nil)
+			sid := sr.ctxSession.ID
+
+			// Fail the first Get only.
+			sr.store.Reset()
+			sr.store.Inject(fault.Rule{Ops: []string{store.OpGet}, Err: tc.err, FirstN: 1})
+			sr.ctxSession = nil
+			sr.run(t, nil)
+			if got, want := sr.store.CallCount(store.OpGet), tc.attempts; got != want {
+				t.Errorf("Unexpected number of Get attempts - got: %d want: %d", got, want)
+			}
+			if got := sr.ctxSession != nil && sr.ctxSession.ID == sid; got != tc.retained {
+				t.Errorf("Unexpected session retention - got: %v want: %v", got, tc.retained)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package sessiontest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	session "github.com/swfrench/simple-session"
+	"github.com/swfrench/simple-session/internal/testutil"
+	"github.com/swfrench/simple-session/sessiontest"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+type fakeSessionData struct {
+	Greeting string
+}
+
+func newManager(t *testing.T, clock *sessiontest.Clock) *session.Manager[fakeSessionData] {
+	t.Helper()
+	ms := memory.New[session.Session[fakeSessionData]]()
+	ms.Clock = clock.Now
+	m, err := session.NewManager[fakeSessionData](ms, testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU="), &session.Options{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	m.Clock = clock.Now
+	return m
+}
+
+// greetingHandler responds with the session greeting, requiring a valid CSRF
+// token for POST requests.
+func greetingHandler(m *session.Manager[fakeSessionData]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := session.Get[fakeSessionData](r.Context())
+		if s == nil || s.Data == nil {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if err := m.VerifySessionCSRFToken(r.FormValue("csrf_token"), s); err != nil {
+				http.Error(w, "bad csrf token", http.StatusForbidden)
+				return
+			}
+		}
+		w.Write([]byte(s.Data.Greeting))
+	})
+}
+
+func TestSeed(t *testing.T) {
+	clock := sessiontest.NewClock(time.Unix(1700000000, 0))
+	m := newManager(t, clock)
+	sd := sessiontest.Seed(t, m, &fakeSessionData{Greeting: "hello"})
+	h := m.Manage(greetingHandler(m))
+
+	testCases := []struct {
+		name     string
+		req      func() *http.Request
+		advance  time.Duration
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "get",
+			req:      func() *http.Request { return sd.Request(http.MethodGet, "/", nil) },
+			wantCode: http.StatusOK,
+			wantBody: "hello",
+		},
+		{
+			name: "post form",
+			req: func() *http.Request {
+				return sd.PostForm("/", url.Values{"name": {"foo"}}, "csrf_token")
+			},
+			wantCode: http.StatusOK,
+			wantBody: "hello",
+		},
+		{
+			name:     "post without csrf token",
+			req:      func() *http.Request { return sd.Request(http.MethodPost, "/", nil) },
+			wantCode: http.StatusForbidden,
+			wantBody: "bad csrf token\n",
+		},
+		{
+			name:     "expired",
+			req:      func() *http.Request { return sd.Request(http.MethodGet, "/", nil) },
+			advance:  2 * time.Hour,
+			wantCode: http.StatusUnauthorized,
+			wantBody: "unauthenticated\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock.Advance(tc.advance)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tc.req())
+			if got, want := w.Code, tc.wantCode; got != want {
+				t.Errorf("ServeHTTP() returned unexpected status - got: %d want: %d", got, want)
+			}
+			if diff := cmp.Diff(tc.wantBody, w.Body.String()); diff != "" {
+				t.Errorf("ServeHTTP() returned unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithSession(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := sessiontest.NewSession(&fakeSessionData{Greeting: "hello"}, now, time.Hour)
+	r := sessiontest.WithSession(httptest.NewRequest(http.MethodGet, "/", nil), s)
+	if got := session.Get[fakeSessionData](r.Context()); got != s {
+		t.Errorf("Get() returned unexpected session - got: %v want: %v", got, s)
+	}
+	if got := session.Get[fakeSessionData](context.Background()); got != nil {
+		t.Errorf("Get() returned unexpected session - got: %v want: nil", got)
+	}
+	if got, want := s.Expiration, now.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("NewSession() returned unexpected expiration - got: %v want: %v", got, want)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	c := sessiontest.NewClock(start)
+	c.Advance(time.Minute)
+	if got, want := c.Now(), start.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("Now() returned unexpected time - got: %v want: %v", got, want)
+	}
+	c.Set(start)
+	if got, want := c.Now(), start; !got.Equal(want) {
+		t.Errorf("Now() returned unexpected time - got: %v want: %v", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+const defaultStatsBatchSize = 100
+
+// defaultExpiryBuckets are the default upper bounds of the expiry histogram
+// computed by Stats.
+var defaultExpiryBuckets = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// ErrEnumerationUnsupported indicates that the SessionStore used by Manager
+// does not implement store.Scanner (or store.Enumerator).
+var ErrEnumerationUnsupported = errors.New("session store does not support enumeration")
+
+// StatsOptions represents tunable knobs that control the behavior of Stats.
+type StatsOptions struct {
+	// ExpiryBuckets are the upper bounds (inclusive, in increasing order) of
+	// the buckets of the expiry histogram, by time until session expiration.
+	// Default if unspecified: 1m, 5m, 15m, 1h, 6h, 24h
+	ExpiryBuckets []time.Duration
+	// BatchSize is the number of sessions requested per call to Scan.
+	// Default if unspecified: 100
+	BatchSize int
+}
+
+// SessionStats summarizes the sessions held by the SessionStore.
+type SessionStats struct {
+	// Total is the number of unexpired sessions.
+	Total int
+	// Authenticated is the number of unexpired sessions with non-nil Data.
+	Authenticated int
+	// PreSessions is the number of unexpired sessions with nil Data.
+	PreSessions int
+	// Expired is the number of sessions that have expired, but remain in the
+	// SessionStore (e.g., during the storage grace period). These are not
+	// included in the other counts.
+	Expired int
+	// ExpiryBuckets are the upper bounds of the expiry histogram buckets (see
+	// StatsOptions).
+	ExpiryBuckets []time.Duration
+	// ExpiryCounts are the number of unexpired sessions in each bucket of the
+	// expiry histogram, by time until session expiration: ExpiryCounts[i] is
+	// the number expiring within ExpiryBuckets[i] (but after
+	// ExpiryBuckets[i-1]), while the final element is the number expiring
+	// after the last bucket.
+	ExpiryCounts []int
+}
+
+// Stats aggregates statistics over all sessions held by the SessionStore,
+// which must implement store.Scanner (or store.Enumerator), otherwise
+// ErrEnumerationUnsupported is returned. Sessions returned more than once by
+// the scan (e.g., as may occur with Redis SCAN) are counted once. Note that
+// Stats reads every session, and thus may be expensive for large stores;
+// consider store.Enumerator Count if only the total is needed.
+func (m *Manager[D]) Stats(ctx context.Context, opts *StatsOptions) (*SessionStats, error) {
+	if !store.Supports[store.Scanner[Session[D]]](m.store) {
+		return nil, ErrEnumerationUnsupported
+	}
+	sc := m.store.(store.Scanner[Session[D]])
+	buckets := opts.ExpiryBuckets
+	if buckets == nil {
+		buckets = defaultExpiryBuckets
+	}
+	batchSize := opts.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultStatsBatchSize
+	}
+	stats := &SessionStats{
+		ExpiryBuckets: buckets,
+		ExpiryCounts:  make([]int, len(buckets)+1),
+	}
+	now := m.Clock()
+	seen := make(map[string]bool)
+	var cursor string
+	for {
+		entries, next, err := sc.Scan(ctx, cursor, batchSize)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil, ErrEnumerationUnsupported
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sessions: %w", err)
+		}
+		for _, e := range entries {
+			if seen[e.SID] {
+				continue
+			}
+			seen[e.SID] = true
+			remaining := e.Session.Expiration.Sub(now)
+			if remaining < 0 {
+				stats.Expired++
+				continue
+			}
+			stats.Total++
+			if e.Session.Data == nil {
+				stats.PreSessions++
+			} else {
+				stats.Authenticated++
+			}
+			stats.ExpiryCounts[sort.Search(len(buckets), func(i int) bool {
+				return remaining <= buckets[i]
+			})]++
+		}
+		if cursor = next; cursor == "" {
+			return stats, nil
+		}
+	}
+}
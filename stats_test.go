@@ -0,0 +1,153 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	session "github.com/swfrench/simple-session"
+	"github.com/swfrench/simple-session/internal/testutil"
+	"github.com/swfrench/simple-session/metrics"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	ms := memory.New[session.Session[fakeSessionData]]()
+	opts := sessionOptions()
+	opts.TTL = time.Hour
+	sm, err := session.NewManager[fakeSessionData](ms, k, opts)
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	now := time.Now()
+	ms.Clock = func() time.Time { return now }
+
+	// Sessions created 70m, 50m, 30m, 10m, and 0m ago, the first of which has
+	// expired (but remains stored for the grace period).
+	for i, age := range []time.Duration{70, 50, 30, 10, 0} {
+		sm.Clock = func() time.Time { return now.Add(-age * time.Minute) }
+		var data *fakeSessionData
+		if i%2 == 0 {
+			data = &fakeSessionData{Greeting: "hello"}
+		}
+		if _, err := sm.Create(ctx, httptest.NewRecorder(), data); err != nil {
+			t.Fatalf("Create() returned unexpected error: %v", err)
+		}
+	}
+	sm.Clock = func() time.Time { return now }
+
+	got, err := sm.Stats(ctx, &session.StatsOptions{
+		ExpiryBuckets: []time.Duration{15 * time.Minute, 45 * time.Minute},
+		BatchSize:     2,
+	})
+	if err != nil {
+		t.Fatalf("Stats() returned unexpected error: %v", err)
+	}
+	want := &session.SessionStats{
+		Total:         4,
+		Authenticated: 2,
+		PreSessions:   2,
+		Expired:       1,
+		ExpiryBuckets: []time.Duration{15 * time.Minute, 45 * time.Minute},
+		ExpiryCounts:  []int{1, 1, 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Stats() returned unexpected stats (-want +got):\n%s", diff)
+	}
+	if n, err := ms.Count(ctx); err != nil || n != 5 {
+		t.Errorf("Count() returned unexpected result - got: (%d, %v) want: (5, <nil>)", n, err)
+	}
+}
+
+func TestStatsUnsupported(t *testing.T) {
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	for _, tc := range []struct {
+		name    string
+		manager func() *session.Manager[fakeSessionData]
+	}{
+		{
+			name: "basic store",
+			manager: func() *session.Manager[fakeSessionData] {
+				sm, err := session.NewManager[fakeSessionData](newBasicStore[session.Session[fakeSessionData]](), k, sessionOptions())
+				if err != nil {
+					t.Fatalf("NewManager() returned unexpected error: %v", err)
+				}
+				return sm
+			},
+		},
+		{
+			name: "wrapped basic store",
+			manager: func() *session.Manager[fakeSessionData] {
+				ms := metrics.NewStore[session.Session[fakeSessionData]](newBasicStore[session.Session[fakeSessionData]](), "basic", metrics.Nop{})
+				sm, err := session.NewManager[fakeSessionData](ms, k, sessionOptions())
+				if err != nil {
+					t.Fatalf("NewManager() returned unexpected error: %v", err)
+				}
+				return sm
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.manager().Stats(context.Background(), &session.StatsOptions{}); !errors.Is(err, session.ErrEnumerationUnsupported) {
+				t.Errorf("Stats() returned unexpected error - got: %v want: %v", err, session.ErrEnumerationUnsupported)
+			}
+		})
+	}
+}
+
+// dupScanner wraps a memory Store, returning each batch of scanned sessions
+// again with the following batch, as may occur with Redis SCAN.
+type dupScanner struct {
+	*memory.Store[session.Session[fakeSessionData]]
+	prev []store.Entry[session.Session[fakeSessionData]]
+}
+
+func (ds *dupScanner) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[session.Session[fakeSessionData]], string, error) {
+	entries, next, err := ds.Store.Scan(ctx, cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+	dups := append(ds.prev, entries...)
+	ds.prev = entries
+	return dups, next, nil
+}
+
+func TestStatsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	k := testutil.MustDecodeBase64(t, "W+HdoO687DHK7p/Uk933ojArElzkEMtRebhW07NFTgU=")
+	ds := &dupScanner{Store: memory.New[session.Session[fakeSessionData]]()}
+	opts := sessionOptions()
+	opts.TTL = time.Hour
+	sm, err := session.NewManager[fakeSessionData](ds, k, opts)
+	if err != nil {
+		t.Fatalf("NewManager() returned unexpected error: %v", err)
+	}
+	for range 5 {
+		if _, err := sm.Create(ctx, httptest.NewRecorder(), nil); err != nil {
+			t.Fatalf("Create() returned unexpected error: %v", err)
+		}
+	}
+
+	got, err := sm.Stats(ctx, &session.StatsOptions{
+		ExpiryBuckets: []time.Duration{2 * time.Hour},
+		BatchSize:     2,
+	})
+	if err != nil {
+		t.Fatalf("Stats() returned unexpected error: %v", err)
+	}
+	want := &session.SessionStats{
+		Total:         5,
+		PreSessions:   5,
+		ExpiryBuckets: []time.Duration{2 * time.Hour},
+		ExpiryCounts:  []int{5, 0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Stats() returned unexpected stats (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,330 @@
+// Package breaker provides a SessionStore wrapper implementing a circuit
+// breaker, which fails fast while the wrapped SessionStore is unavailable.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = 10 * time.Second
+	defaultSuccessThreshold = 1
+)
+
+// ErrOpen indicates that an operation was rejected without being attempted,
+// since the circuit breaker is open. Errors wrapping ErrOpen are marked as
+// permanent (see store.IsRetryable), since retrying immediately would be
+// futile.
+var ErrOpen error = openError{}
+
+// openError is the type of ErrOpen, which reports its class to
+// metrics.ErrorClass (see metrics.Classifier).
+type openError struct{}
+
+func (openError) Error() string      { return "circuit breaker open" }
+func (openError) ErrorClass() string { return "breaker_open" }
+
+// State represents the state of the circuit breaker.
+type State int
+
+const (
+	// Closed is the initial state, in which operations are passed through to
+	// the wrapped SessionStore.
+	Closed State = iota
+	// Open is the state entered after FailureThreshold consecutive failures,
+	// in which operations fail fast with ErrOpen.
+	Open
+	// HalfOpen is the state entered once CoolDown has elapsed since entering
+	// Open, in which a single trial operation at a time is passed through to
+	// the wrapped SessionStore.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Options represents tunable knobs that control the behavior of Store.
+type Options struct {
+	// FailureThreshold is the number of consecutive failures in the Closed
+	// state after which the breaker opens.
+	// Default if unspecified: 5
+	FailureThreshold int
+	// CoolDown is the duration for which the breaker remains Open before
+	// transitioning to HalfOpen.
+	// Default if unspecified: 10s
+	CoolDown time.Duration
+	// SuccessThreshold is the number of consecutive successful trial
+	// operations in the HalfOpen state after which the breaker closes. Any
+	// failed trial operation reopens the breaker.
+	// Default if unspecified: 1
+	SuccessThreshold int
+	// IsFailure reports whether the provided (non-nil) error returned by the
+	// wrapped SessionStore indicates that it is unavailable.
+	// Default if unspecified: IsFailure
+	IsFailure func(error) bool
+	// OnStateChange is a user-supplied callback invoked on each state
+	// transition, e.g., to log or export the breaker state. It is invoked
+	// synchronously, outside of any internal locks.
+	// Default if unspecified: nil, in which case OnStateChange is not invoked.
+	OnStateChange func(from, to State)
+}
+
+// IsFailure is the default Options.IsFailure, which considers retryable errors
+// (see store.IsRetryable) other than store.ErrSessionExists to be failures.
+func IsFailure(err error) bool {
+	return store.IsRetryable(err) && !errors.Is(err, store.ErrSessionExists)
+}
+
+// Store is a SessionStore wrapping another SessionStore with a circuit
+// breaker. Store implements the optional store.UserIndex,
+// store.UserSessionLimiter, store.Updater, and store.Enumerator interfaces,
+// delegating to the wrapped SessionStore if supported (see store.Supports).
+type Store[S any] struct {
+	// Clock can be used to override measurement of time in tests.
+	Clock     func() time.Time
+	s         store.SessionStore[S]
+	opts      *Options
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	openedAt  time.Time
+	trial     bool
+}
+
+// New returns a new Store wrapping the provided SessionStore and respecting
+// the provided options.
+func New[S any](s store.SessionStore[S], opts *Options) *Store[S] {
+	if opts.FailureThreshold == 0 {
+		opts.FailureThreshold = defaultFailureThreshold
+	}
+	if opts.CoolDown == time.Duration(0) {
+		opts.CoolDown = defaultCoolDown
+	}
+	if opts.SuccessThreshold == 0 {
+		opts.SuccessThreshold = defaultSuccessThreshold
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = IsFailure
+	}
+	return &Store[S]{
+		Clock: func() time.Time { return time.Now() },
+		s:     s,
+		opts:  opts,
+	}
+}
+
+// State returns the current state of the breaker.
+func (bs *Store[S]) State() State {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.state
+}
+
+// transition records a state transition, to be reported via OnStateChange
+// once the lock is released.
+type transition struct {
+	from, to State
+}
+
+func (bs *Store[S]) notify(ts []transition) {
+	if bs.opts.OnStateChange == nil {
+		return
+	}
+	for _, t := range ts {
+		bs.opts.OnStateChange(t.from, t.to)
+	}
+}
+
+// setState must be called with the lock held.
+func (bs *Store[S]) setState(to State, ts []transition) []transition {
+	ts = append(ts, transition{from: bs.state, to: to})
+	bs.state = to
+	bs.failures = 0
+	bs.successes = 0
+	bs.trial = false
+	if to == Open {
+		bs.openedAt = bs.Clock()
+	}
+	return ts
+}
+
+// allow determines whether an operation may be attempted, returning whether
+// it is a HalfOpen trial operation, or an error wrapping ErrOpen.
+func (bs *Store[S]) allow() (bool, error) {
+	var ts []transition
+	defer func() { bs.notify(ts) }()
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.state == Open {
+		if bs.Clock().Sub(bs.openedAt) < bs.opts.CoolDown {
+			return false, store.MarkPermanent(fmt.Errorf("session store unavailable: %w", ErrOpen))
+		}
+		ts = bs.setState(HalfOpen, ts)
+	}
+	if bs.state == HalfOpen {
+		if bs.trial {
+			return false, store.MarkPermanent(fmt.Errorf("session store unavailable (trial in progress): %w", ErrOpen))
+		}
+		bs.trial = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record records the outcome of an operation permitted by allow.
+func (bs *Store[S]) record(trial bool, err error) {
+	var ts []transition
+	defer func() { bs.notify(ts) }()
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	failure := err != nil && bs.opts.IsFailure(err)
+	switch bs.state {
+	case Closed:
+		if !failure {
+			bs.failures = 0
+		} else if bs.failures++; bs.failures >= bs.opts.FailureThreshold {
+			ts = bs.setState(Open, ts)
+		}
+	case HalfOpen:
+		if !trial {
+			// Permitted prior to the breaker opening; outcome is stale.
+			return
+		}
+		bs.trial = false
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// The outcome is inconclusive (e.g., the caller gave up), so
+			// remain HalfOpen and permit another trial.
+			return
+		}
+		if failure {
+			ts = bs.setState(Open, ts)
+		} else if bs.successes++; bs.successes >= bs.opts.SuccessThreshold {
+			ts = bs.setState(Closed, ts)
+		}
+	}
+}
+
+// do invokes fn if permitted by the breaker, recording its outcome.
+func (bs *Store[S]) do(fn func() error) error {
+	trial, err := bs.allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	bs.record(trial, err)
+	return err
+}
+
+// Unwrap implements store.Unwrapper.
+func (bs *Store[S]) Unwrap() store.SessionStore[S] {
+	return bs.s
+}
+
+// Get implements store.SessionStore.
+func (bs *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	var s *S
+	err := bs.do(func() (err error) {
+		s, err = bs.s.Get(ctx, sid)
+		return err
+	})
+	return s, err
+}
+
+// Set implements store.SessionStore.
+func (bs *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	return bs.do(func() error {
+		return bs.s.Set(ctx, sid, s, ttl)
+	})
+}
+
+// Del implements store.SessionStore.
+func (bs *Store[S]) Del(ctx context.Context, sid string) error {
+	return bs.do(func() error {
+		return bs.s.Del(ctx, sid)
+	})
+}
+
+// Update implements store.Updater.
+func (bs *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	u, ok := bs.s.(store.Updater[S])
+	if !ok {
+		return store.Unsupported("store.Updater")
+	}
+	return bs.do(func() error {
+		return u.Update(ctx, sid, s, ttl)
+	})
+}
+
+// SetLimited implements store.UserSessionLimiter.
+func (bs *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	l, ok := bs.s.(store.UserSessionLimiter[S])
+	if !ok {
+		return nil, store.Unsupported("store.UserSessionLimiter")
+	}
+	var evicted []string
+	err := bs.do(func() (err error) {
+		evicted, err = l.SetLimited(ctx, sid, s, ttl, limit, evict)
+		return err
+	})
+	return evicted, err
+}
+
+// UserSessions implements store.UserIndex.
+func (bs *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	ui, ok := bs.s.(store.UserIndex)
+	if !ok {
+		return nil, store.Unsupported("store.UserIndex")
+	}
+	var sids []string
+	err := bs.do(func() (err error) {
+		sids, err = ui.UserSessions(ctx, uid)
+		return err
+	})
+	return sids, err
+}
+
+// Scan implements store.Scanner.
+func (bs *Store[S]) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[S], string, error) {
+	sc, ok := bs.s.(store.Scanner[S])
+	if !ok {
+		return nil, "", store.Unsupported("store.Scanner")
+	}
+	var entries []store.Entry[S]
+	var next string
+	err := bs.do(func() (err error) {
+		entries, next, err = sc.Scan(ctx, cursor, count)
+		return err
+	})
+	return entries, next, err
+}
+
+// Count implements store.Enumerator.
+func (bs *Store[S]) Count(ctx context.Context) (int, error) {
+	e, ok := bs.s.(store.Enumerator[S])
+	if !ok {
+		return 0, store.Unsupported("store.Enumerator")
+	}
+	var n int
+	err := bs.do(func() (err error) {
+		n, err = e.Count(ctx)
+		return err
+	})
+	return n, err
+}
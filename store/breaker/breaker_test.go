@@ -0,0 +1,192 @@
+package breaker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/breaker"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+type fakeSession struct {
+	Name string
+}
+
+// flakyStore wraps a memory Store, returning err (if non-nil) from Get. If
+// block is non-nil, Get signals entered and then blocks until block is closed.
+type flakyStore struct {
+	*memory.Store[fakeSession]
+	err     error
+	calls   int
+	block   chan struct{}
+	entered chan struct{}
+}
+
+func (fs *flakyStore) Get(ctx context.Context, sid string) (*fakeSession, error) {
+	fs.calls++
+	if fs.block != nil {
+		fs.entered <- struct{}{}
+		<-fs.block
+	}
+	if fs.err != nil {
+		return nil, fs.err
+	}
+	return fs.Store.Get(ctx, sid)
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	fs := &flakyStore{Store: memory.New[fakeSession]()}
+	var transitions []string
+	bs := breaker.New[fakeSession](fs, &breaker.Options{
+		FailureThreshold: 2,
+		CoolDown:         time.Minute,
+		OnStateChange: func(from, to breaker.State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	now := time.Now()
+	bs.Clock = func() time.Time { return now }
+
+	if err := bs.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	errOutage := errors.New("outage")
+	steps := []struct {
+		name    string
+		advance time.Duration
+		err     error
+		sid     string
+		wantErr error
+		calls   int
+		state   breaker.State
+	}{
+		{name: "not found is not a failure", sid: "bar", wantErr: store.ErrSessionNotFound, calls: 1, state: breaker.Closed},
+		{name: "first failure", err: errOutage, wantErr: errOutage, calls: 1, state: breaker.Closed},
+		{name: "success resets", calls: 1, state: breaker.Closed},
+		{name: "failure", err: errOutage, wantErr: errOutage, calls: 1, state: breaker.Closed},
+		{name: "failure trips", err: errOutage, wantErr: errOutage, calls: 1, state: breaker.Open},
+		{name: "fails fast", err: errOutage, wantErr: breaker.ErrOpen, calls: 0, state: breaker.Open},
+		{name: "failed trial", advance: time.Minute, err: errOutage, wantErr: errOutage, calls: 1, state: breaker.Open},
+		{name: "fails fast again", advance: 30 * time.Second, wantErr: breaker.ErrOpen, calls: 0, state: breaker.Open},
+		{name: "successful trial", advance: time.Minute, calls: 1, state: breaker.Closed},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		fs.err = step.err
+		fs.calls = 0
+		sid := step.sid
+		if sid == "" {
+			sid = "foo"
+		}
+		_, err := bs.Get(ctx, sid)
+		if !errors.Is(err, step.wantErr) || (err == nil) != (step.wantErr == nil) {
+			t.Errorf("Get() for step %q returned unexpected error - got: %v want: %v", step.name, err, step.wantErr)
+		}
+		if errors.Is(err, breaker.ErrOpen) && store.IsRetryable(err) {
+			t.Errorf("Get() for step %q returned retryable error: %v", step.name, err)
+		}
+		if got, want := fs.calls, step.calls; got != want {
+			t.Errorf("Unexpected calls to wrapped store for step %q - got: %d want: %d", step.name, got, want)
+		}
+		if got, want := bs.State(), step.state; got != want {
+			t.Errorf("Unexpected breaker state after step %q - got: %v want: %v", step.name, got, want)
+		}
+	}
+	want := []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}
+	if diff := cmp.Diff(want, transitions); diff != "" {
+		t.Errorf("Unexpected state transitions (+got, -want):\n%s", diff)
+	}
+}
+
+func TestBreakerSingleTrial(t *testing.T) {
+	ctx := context.Background()
+	fs := &flakyStore{Store: memory.New[fakeSession](), err: errors.New("outage")}
+	bs := breaker.New[fakeSession](fs, &breaker.Options{FailureThreshold: 1, CoolDown: time.Minute})
+	now := time.Now()
+	bs.Clock = func() time.Time { return now }
+	if _, err := bs.Get(ctx, "foo"); err == nil {
+		t.Fatal("Get() unexpectedly succeeded")
+	}
+	if got, want := bs.State(), breaker.Open; got != want {
+		t.Fatalf("Unexpected breaker state - got: %v want: %v", got, want)
+	}
+
+	// Start a trial operation that blocks in the wrapped store.
+	now = now.Add(time.Minute)
+	fs.err = nil
+	fs.block = make(chan struct{})
+	fs.entered = make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := bs.Get(ctx, "foo")
+		done <- err
+	}()
+	<-fs.entered
+
+	// Verify that concurrent operations fail fast while the trial is in
+	// progress.
+	if err := bs.Del(ctx, "foo"); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("Del() during trial returned unexpected error - got: %v want: %v", err, breaker.ErrOpen)
+	}
+	close(fs.block)
+	if err := <-done; !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() trial returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	if got, want := bs.State(), breaker.Closed; got != want {
+		t.Errorf("Unexpected breaker state after trial - got: %v want: %v", got, want)
+	}
+}
+
+func TestStoreSupports(t *testing.T) {
+	bs := breaker.New[fakeSession](struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()}, &breaker.Options{})
+	if store.Supports[store.Updater[fakeSession]](bs) {
+		t.Error("Supports() unexpectedly returned true for Updater not implemented by the wrapped store")
+	}
+	if !store.Supports[store.Updater[fakeSession]](breaker.New[fakeSession](memory.New[fakeSession](), &breaker.Options{})) {
+		t.Error("Supports() unexpectedly returned false for Updater implemented by the wrapped store")
+	}
+}
+
+func TestBreakerCanceledTrial(t *testing.T) {
+	ctx := context.Background()
+	fs := &flakyStore{Store: memory.New[fakeSession](), err: errors.New("outage")}
+	bs := breaker.New[fakeSession](fs, &breaker.Options{FailureThreshold: 1, CoolDown: time.Minute})
+	now := time.Now()
+	bs.Clock = func() time.Time { return now }
+	if _, err := bs.Get(ctx, "foo"); err == nil {
+		t.Fatal("Get() unexpectedly succeeded")
+	}
+
+	// A trial failing due to cancellation is inconclusive.
+	now = now.Add(time.Minute)
+	fs.err = fmt.Errorf("wrapped: %w", context.Canceled)
+	if _, err := bs.Get(ctx, "foo"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Get() returned unexpected error - got: %v want: %v", err, context.Canceled)
+	}
+	if got, want := bs.State(), breaker.HalfOpen; got != want {
+		t.Fatalf("Unexpected breaker state after canceled trial - got: %v want: %v", got, want)
+	}
+
+	// A subsequent trial is permitted, and determines the outcome.
+	fs.err = nil
+	if _, err := bs.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Fatalf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	if got, want := bs.State(), breaker.Closed; got != want {
+		t.Errorf("Unexpected breaker state after trial - got: %v want: %v", got, want)
+	}
+}
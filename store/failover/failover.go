@@ -0,0 +1,502 @@
+// Package failover provides a SessionStore wrapper that degrades gracefully
+// while its primary SessionStore is unavailable, by serving sessions from a
+// local replica on a best-effort basis.
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/breaker"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+const (
+	defaultReplicaEntries = 10000
+	defaultReplicaTTL     = 30 * time.Minute
+	defaultMaxQueue       = 1000
+)
+
+// ErrQueueFull indicates that a write could not be accepted while the primary
+// SessionStore is unavailable, since the queue of pending writes is full.
+var ErrQueueFull = errors.New("failover write queue full")
+
+// WritePolicy determines how Store handles writes (Set, Update, and Del) while
+// the primary SessionStore is unavailable.
+type WritePolicy int
+
+const (
+	// RejectWrites causes writes to fail with the error returned by the
+	// primary SessionStore.
+	RejectWrites WritePolicy = iota
+	// QueueWrites causes writes to be applied to the replica and queued for
+	// replay to the primary SessionStore once it is available (see Replay).
+	// If the queue is full, writes fail with ErrQueueFull. While writes for a
+	// given SID are queued, the replica is authoritative for that SID: Gets
+	// are served from the replica, and subsequent writes are also queued,
+	// such that they are replayed in order.
+	QueueWrites
+)
+
+// Fallback outcomes, as provided to Options.OnFallback.
+const (
+	// FallbackHit indicates that a Get was served from the replica.
+	FallbackHit = "hit"
+	// FallbackMiss indicates that a Get could not be served from the replica.
+	FallbackMiss = "miss"
+	// FallbackQueued indicates that a write was queued.
+	FallbackQueued = "queued"
+	// FallbackRejected indicates that a write was rejected.
+	FallbackRejected = "rejected"
+)
+
+// Options represents tunable knobs that control the behavior of Store.
+type Options[S any] struct {
+	// Replica is the local SessionStore holding recently read or written
+	// sessions, used to serve Gets while the primary is unavailable. It
+	// should be configured with a Codec (see memory.Options), such that
+	// sessions held by the replica (and queued writes, see QueueWrites) are
+	// not affected by subsequent modification by the caller.
+	// Default if unspecified: a memory.Store holding at most 10000 sessions,
+	// serialized using memory.JSONCodec.
+	Replica *memory.Store[S]
+	// ReplicaTTL is the TTL of sessions cached in the replica on successful
+	// Gets from the primary (whose TTL is unknown). Consider setting this to
+	// the session TTL (e.g., session.Options.TTL).
+	// Default if unspecified: 30m
+	ReplicaTTL time.Duration
+	// WritePolicy determines how writes are handled while the primary is
+	// unavailable.
+	// Default if unspecified: RejectWrites
+	WritePolicy WritePolicy
+	// MaxQueue is the maximum number of writes queued under QueueWrites.
+	// Default if unspecified: 1000
+	MaxQueue int
+	// IsUnavailable reports whether the provided (non-nil) error returned by
+	// the primary indicates that it is unavailable.
+	// Default if unspecified: IsUnavailable
+	IsUnavailable func(error) bool
+	// OnFallback is a user-supplied callback invoked whenever an operation
+	// falls back due to the primary being unavailable, with the operation
+	// (e.g., store.OpGet) and outcome (e.g., FallbackHit), e.g., to export
+	// metrics. See also Stats.
+	// Default if unspecified: nil, in which case OnFallback is not invoked.
+	OnFallback func(op, outcome string)
+}
+
+// IsUnavailable is the default Options.IsUnavailable, which considers errors
+// wrapping breaker.ErrOpen, as well as those considered failures by
+// breaker.IsFailure, to indicate unavailability.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, breaker.ErrOpen) || breaker.IsFailure(err)
+}
+
+// Stats represents counts of fallback operations performed by Store.
+type Stats struct {
+	// FallbackHits is the number of Gets served from the replica.
+	FallbackHits int64
+	// FallbackMisses is the number of Gets that could not be served from the
+	// replica.
+	FallbackMisses int64
+	// QueuedWrites is the number of writes queued for replay.
+	QueuedWrites int64
+	// RejectedWrites is the number of writes rejected.
+	RejectedWrites int64
+	// ReplayedWrites is the number of queued writes replayed to the primary.
+	ReplayedWrites int64
+	// DroppedWrites is the number of queued writes dropped on replay due to
+	// an error other than unavailability.
+	DroppedWrites int64
+}
+
+type opKind int
+
+const (
+	opSet opKind = iota
+	opUpdate
+	opDel
+)
+
+// pendingWrite is a write queued for replay to the primary.
+type pendingWrite[S any] struct {
+	op      opKind
+	sid     string
+	s       *S
+	expires time.Time
+}
+
+// Store is a SessionStore that writes to a primary SessionStore and, while it
+// is unavailable, serves Gets from a local replica populated by recent
+// successful operations, and handles writes according to WritePolicy. Store
+// implements the optional store.Updater interface (which the replica is used
+// to support for queued writes), as well as store.UserIndex and
+// store.UserSessionLimiter, which are delegated to the primary without
+// fallback. Each requires support from the primary (see store.Supports).
+type Store[S any] struct {
+	// Clock can be used to override measurement of time in tests.
+	Clock     func() time.Time
+	primary   store.SessionStore[S]
+	opts      *Options[S]
+	mu        sync.Mutex
+	queue     []pendingWrite[S]
+	pending   map[string]int // number of queued writes by SID
+	replayMu  sync.Mutex     // serializes Replay
+	stats     struct{ hits, misses, queued, rejected, replayed, dropped atomic.Int64 }
+	stop      chan struct{}
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New returns a new Store wrapping the provided primary SessionStore and
+// respecting the provided options.
+func New[S any](primary store.SessionStore[S], opts *Options[S]) *Store[S] {
+	if opts.Replica == nil {
+		opts.Replica = memory.NewWithOptions(&memory.Options[S]{
+			MaxEntries: defaultReplicaEntries,
+			Codec:      memory.JSONCodec{},
+		})
+	}
+	if opts.ReplicaTTL == time.Duration(0) {
+		opts.ReplicaTTL = defaultReplicaTTL
+	}
+	if opts.MaxQueue == 0 {
+		opts.MaxQueue = defaultMaxQueue
+	}
+	if opts.IsUnavailable == nil {
+		opts.IsUnavailable = IsUnavailable
+	}
+	return &Store[S]{
+		Clock:   func() time.Time { return time.Now() },
+		primary: primary,
+		opts:    opts,
+		pending: make(map[string]int),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Stats returns counts of fallback operations performed by the Store.
+func (fs *Store[S]) Stats() Stats {
+	return Stats{
+		FallbackHits:   fs.stats.hits.Load(),
+		FallbackMisses: fs.stats.misses.Load(),
+		QueuedWrites:   fs.stats.queued.Load(),
+		RejectedWrites: fs.stats.rejected.Load(),
+		ReplayedWrites: fs.stats.replayed.Load(),
+		DroppedWrites:  fs.stats.dropped.Load(),
+	}
+}
+
+// QueueLen returns the number of writes queued for replay.
+func (fs *Store[S]) QueueLen() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return len(fs.queue)
+}
+
+func (fs *Store[S]) fallback(op, outcome string, counter *atomic.Int64) {
+	counter.Add(1)
+	if fs.opts.OnFallback != nil {
+		fs.opts.OnFallback(op, outcome)
+	}
+}
+
+// cache stores the provided session to the replica, replacing any existing
+// session. Failure to do so is non-critical.
+func (fs *Store[S]) cache(ctx context.Context, sid string, s *S, ttl time.Duration) {
+	if err := fs.opts.Replica.Update(ctx, sid, s, ttl); errors.Is(err, store.ErrSessionNotFound) {
+		fs.opts.Replica.Set(ctx, sid, s, ttl)
+	}
+}
+
+// enqueue queues the provided write for replay, having applied it to the
+// replica via apply, or returns ErrQueueFull. For writes other than Del, the
+// queued session is that read back from the replica, such that it is not
+// affected by subsequent modification by the caller.
+func (fs *Store[S]) enqueue(ctx context.Context, op string, w pendingWrite[S], apply func() error, cause error) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if len(fs.queue) >= fs.opts.MaxQueue {
+		fs.fallback(op, FallbackRejected, &fs.stats.rejected)
+		return fmt.Errorf("session store unavailable (error: %v): %w", cause, ErrQueueFull)
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	if w.op != opDel {
+		s, err := fs.opts.Replica.Get(ctx, w.sid)
+		if err != nil {
+			return fmt.Errorf("failed to read back queued session from replica: %w", err)
+		}
+		w.s = s
+	}
+	fs.queue = append(fs.queue, w)
+	fs.pending[w.sid]++
+	fs.fallback(op, FallbackQueued, &fs.stats.queued)
+	return nil
+}
+
+// isPending reports whether writes for the provided SID are queued.
+func (fs *Store[S]) isPending(sid string) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.pending[sid] > 0
+}
+
+// errPending is the cause reported for writes queued due to prior queued
+// writes for the same SID (see QueueWrites).
+var errPending = errors.New("prior writes for session pending replay")
+
+// Unwrap implements store.Unwrapper, returning the primary SessionStore.
+func (fs *Store[S]) Unwrap() store.SessionStore[S] {
+	return fs.primary
+}
+
+// Get implements store.SessionStore.
+func (fs *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	if fs.isPending(sid) {
+		rs, err := fs.opts.Replica.Get(ctx, sid)
+		if err != nil {
+			fs.fallback(store.OpGet, FallbackMiss, &fs.stats.misses)
+			return nil, err
+		}
+		fs.fallback(store.OpGet, FallbackHit, &fs.stats.hits)
+		return rs, nil
+	}
+	s, err := fs.primary.Get(ctx, sid)
+	if err == nil {
+		fs.cache(ctx, sid, s, fs.opts.ReplicaTTL)
+		return s, nil
+	}
+	if !fs.opts.IsUnavailable(err) {
+		if errors.Is(err, store.ErrSessionNotFound) {
+			fs.opts.Replica.Del(ctx, sid)
+		}
+		return nil, err
+	}
+	rs, rerr := fs.opts.Replica.Get(ctx, sid)
+	if rerr != nil {
+		fs.fallback(store.OpGet, FallbackMiss, &fs.stats.misses)
+		return nil, err
+	}
+	fs.fallback(store.OpGet, FallbackHit, &fs.stats.hits)
+	return rs, nil
+}
+
+// Set implements store.SessionStore.
+func (fs *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	w := pendingWrite[S]{op: opSet, sid: sid, expires: fs.Clock().Add(ttl)}
+	apply := func() error { return fs.opts.Replica.Set(ctx, sid, s, ttl) }
+	if fs.isPending(sid) {
+		return fs.enqueue(ctx, store.OpSet, w, apply, errPending)
+	}
+	err := fs.primary.Set(ctx, sid, s, ttl)
+	if err == nil {
+		fs.cache(ctx, sid, s, ttl)
+		return nil
+	}
+	if !fs.opts.IsUnavailable(err) {
+		return err
+	}
+	if fs.opts.WritePolicy != QueueWrites {
+		fs.fallback(store.OpSet, FallbackRejected, &fs.stats.rejected)
+		return err
+	}
+	return fs.enqueue(ctx, store.OpSet, w, apply, err)
+}
+
+// Update implements store.Updater.
+func (fs *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	u, ok := fs.primary.(store.Updater[S])
+	if !ok {
+		return store.Unsupported("store.Updater")
+	}
+	w := pendingWrite[S]{op: opUpdate, sid: sid, expires: fs.Clock().Add(ttl)}
+	apply := func() error { return fs.opts.Replica.Update(ctx, sid, s, ttl) }
+	if fs.isPending(sid) {
+		return fs.enqueue(ctx, store.OpUpdate, w, apply, errPending)
+	}
+	err := u.Update(ctx, sid, s, ttl)
+	if err == nil {
+		fs.cache(ctx, sid, s, ttl)
+		return nil
+	}
+	if !fs.opts.IsUnavailable(err) {
+		if errors.Is(err, store.ErrSessionNotFound) {
+			fs.opts.Replica.Del(ctx, sid)
+		}
+		return err
+	}
+	if fs.opts.WritePolicy != QueueWrites {
+		fs.fallback(store.OpUpdate, FallbackRejected, &fs.stats.rejected)
+		return err
+	}
+	return fs.enqueue(ctx, store.OpUpdate, w, apply, err)
+}
+
+// Del implements store.SessionStore. The session is always deleted from the
+// replica, such that it is no longer served while the primary is unavailable.
+func (fs *Store[S]) Del(ctx context.Context, sid string) error {
+	w := pendingWrite[S]{op: opDel, sid: sid}
+	if fs.isPending(sid) {
+		return fs.enqueue(ctx, store.OpDel, w, func() error {
+			if err := fs.opts.Replica.Del(ctx, sid); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+				return err
+			}
+			return nil
+		}, errPending)
+	}
+	rerr := fs.opts.Replica.Del(ctx, sid)
+	err := fs.primary.Del(ctx, sid)
+	if err == nil || !fs.opts.IsUnavailable(err) {
+		if errors.Is(err, store.ErrSessionNotFound) && rerr == nil {
+			// The session has only been written to the replica, pending
+			// replay (which will now be a no-op).
+			return nil
+		}
+		return err
+	}
+	if fs.opts.WritePolicy != QueueWrites {
+		fs.fallback(store.OpDel, FallbackRejected, &fs.stats.rejected)
+		return err
+	}
+	return fs.enqueue(ctx, store.OpDel, w, func() error { return nil }, err)
+}
+
+// SetLimited implements store.UserSessionLimiter, delegating to the primary
+// without fallback.
+func (fs *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	l, ok := fs.primary.(store.UserSessionLimiter[S])
+	if !ok {
+		return nil, store.Unsupported("store.UserSessionLimiter")
+	}
+	evicted, err := l.SetLimited(ctx, sid, s, ttl, limit, evict)
+	if err == nil {
+		fs.cache(ctx, sid, s, ttl)
+		for _, esid := range evicted {
+			fs.opts.Replica.Del(ctx, esid)
+		}
+	}
+	return evicted, err
+}
+
+// UserSessions implements store.UserIndex, delegating to the primary without
+// fallback.
+func (fs *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	ui, ok := fs.primary.(store.UserIndex)
+	if !ok {
+		return nil, store.Unsupported("store.UserIndex")
+	}
+	return ui.UserSessions(ctx, uid)
+}
+
+// replayOne applies the provided queued write to the primary.
+func (fs *Store[S]) replayOne(ctx context.Context, w pendingWrite[S]) error {
+	switch w.op {
+	case opSet:
+		ttl := w.expires.Sub(fs.Clock())
+		if ttl <= 0 {
+			return nil
+		}
+		if err := fs.primary.Set(ctx, w.sid, w.s, ttl); err != nil && !errors.Is(err, store.ErrSessionExists) {
+			return err
+		}
+	case opUpdate:
+		ttl := w.expires.Sub(fs.Clock())
+		if ttl <= 0 {
+			return nil
+		}
+		if err := fs.primary.(store.Updater[S]).Update(ctx, w.sid, w.s, ttl); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+			return err
+		}
+	case opDel:
+		if err := fs.primary.Del(ctx, w.sid); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay replays queued writes to the primary in order, returning the number
+// replayed. Replay stops at the first write that fails due to the primary
+// being unavailable (or the provided Context being done), returning the
+// associated error, such that it may be retried later. Writes failing for
+// other reasons are dropped. Other operations on the Store are not blocked
+// while Replay is in progress.
+func (fs *Store[S]) Replay(ctx context.Context) (int, error) {
+	fs.replayMu.Lock()
+	defer fs.replayMu.Unlock()
+	fs.mu.Lock()
+	queue := slices.Clone(fs.queue)
+	fs.mu.Unlock()
+	var n, done int
+	var err error
+	for _, w := range queue {
+		if rerr := fs.replayOne(ctx, w); rerr != nil {
+			if fs.opts.IsUnavailable(rerr) || ctx.Err() != nil {
+				err = rerr
+				break
+			}
+			fs.stats.dropped.Add(1)
+		} else {
+			fs.stats.replayed.Add(1)
+			n++
+		}
+		done++
+	}
+	// Only Replay removes writes from the queue, so the first done writes are
+	// exactly those processed above.
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, w := range fs.queue[:done] {
+		if fs.pending[w.sid]--; fs.pending[w.sid] == 0 {
+			delete(fs.pending, w.sid)
+		}
+	}
+	fs.queue = fs.queue[done:]
+	if len(fs.queue) == 0 {
+		fs.queue = nil
+	}
+	return n, err
+}
+
+// StartReplay starts a background goroutine that replays queued writes (see
+// Replay) at the provided interval, with each replay bounded by a Context with
+// the same timeout. Errors encountered while doing so are
+// passed to onError, if non-nil. Close must be called to stop the goroutine
+// once the Store is no longer needed. StartReplay must be called at most once.
+func (fs *Store[S]) StartReplay(interval time.Duration, onError func(error)) {
+	fs.workers.Add(1)
+	go func() {
+		defer fs.workers.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-fs.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				_, err := fs.Replay(ctx)
+				cancel()
+				if err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops any background goroutine started by StartReplay, waiting for it
+// to exit. Close is idempotent.
+func (fs *Store[S]) Close() {
+	fs.closeOnce.Do(func() {
+		close(fs.stop)
+		fs.workers.Wait()
+	})
+}
@@ -0,0 +1,362 @@
+package failover_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/failover"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+type fakeSession struct {
+	Name string
+}
+
+var errOutage = store.MarkRetryable(errors.New("outage"))
+
+// downStore wraps a memory Store, failing all operations with errOutage while
+// down is set.
+type downStore struct {
+	*memory.Store[fakeSession]
+	down bool
+}
+
+func (ds *downStore) Get(ctx context.Context, sid string) (*fakeSession, error) {
+	if ds.down {
+		return nil, errOutage
+	}
+	return ds.Store.Get(ctx, sid)
+}
+
+func (ds *downStore) Set(ctx context.Context, sid string, s *fakeSession, ttl time.Duration) error {
+	if ds.down {
+		return errOutage
+	}
+	return ds.Store.Set(ctx, sid, s, ttl)
+}
+
+func (ds *downStore) Update(ctx context.Context, sid string, s *fakeSession, ttl time.Duration) error {
+	if ds.down {
+		return errOutage
+	}
+	return ds.Store.Update(ctx, sid, s, ttl)
+}
+
+func (ds *downStore) Del(ctx context.Context, sid string) error {
+	if ds.down {
+		return errOutage
+	}
+	return ds.Store.Del(ctx, sid)
+}
+
+func TestFallbackReads(t *testing.T) {
+	ctx := context.Background()
+	ds := &downStore{Store: memory.New[fakeSession]()}
+	var fallbacks []string
+	fs := failover.New[fakeSession](ds, &failover.Options[fakeSession]{
+		OnFallback: func(op, outcome string) {
+			fallbacks = append(fallbacks, op+":"+outcome)
+		},
+	})
+
+	for _, sid := range []string{"foo", "bar"} {
+		if err := ds.Store.Set(ctx, sid, &fakeSession{Name: sid}, time.Hour); err != nil {
+			t.Fatalf("Set() returned unexpected error: %v", err)
+		}
+	}
+	// Only "foo" is read (and thus replicated) prior to the outage.
+	if _, err := fs.Get(ctx, "foo"); err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+
+	ds.down = true
+	if got, err := fs.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "foo"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if _, err := fs.Get(ctx, "bar"); !errors.Is(err, errOutage) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, errOutage)
+	}
+	if err := fs.Set(ctx, "baz", &fakeSession{Name: "baz"}, time.Hour); !errors.Is(err, errOutage) {
+		t.Errorf("Set() returned unexpected error - got: %v want: %v", err, errOutage)
+	}
+
+	wantFallbacks := []string{"get:hit", "get:miss", "set:rejected"}
+	if diff := cmp.Diff(wantFallbacks, fallbacks); diff != "" {
+		t.Errorf("OnFallback received unexpected calls (-want +got):\n%s", diff)
+	}
+	wantStats := failover.Stats{FallbackHits: 1, FallbackMisses: 1, RejectedWrites: 1}
+	if diff := cmp.Diff(wantStats, fs.Stats()); diff != "" {
+		t.Errorf("Stats() returned unexpected stats (-want +got):\n%s", diff)
+	}
+
+	// Sessions deleted from the primary are no longer served from the
+	// replica.
+	ds.down = false
+	if err := ds.Store.Del(ctx, "foo"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	if _, err := fs.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	ds.down = true
+	if _, err := fs.Get(ctx, "foo"); !errors.Is(err, errOutage) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, errOutage)
+	}
+}
+
+func TestQueueWrites(t *testing.T) {
+	ctx := context.Background()
+	ds := &downStore{Store: memory.New[fakeSession]()}
+	fs := failover.New[fakeSession](ds, &failover.Options[fakeSession]{
+		WritePolicy: failover.QueueWrites,
+		MaxQueue:    3,
+	})
+
+	if err := fs.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+
+	ds.down = true
+	if err := fs.Set(ctx, "bar", &fakeSession{Name: "bar"}, time.Hour); err != nil {
+		t.Errorf("Set() returned unexpected error: %v", err)
+	}
+	if err := fs.Update(ctx, "bar", &fakeSession{Name: "bar2"}, time.Hour); err != nil {
+		t.Errorf("Update() returned unexpected error: %v", err)
+	}
+	if err := fs.Del(ctx, "foo"); err != nil {
+		t.Errorf("Del() returned unexpected error: %v", err)
+	}
+	if err := fs.Set(ctx, "baz", &fakeSession{Name: "baz"}, time.Hour); !errors.Is(err, failover.ErrQueueFull) {
+		t.Errorf("Set() returned unexpected error - got: %v want: %v", err, failover.ErrQueueFull)
+	}
+
+	// Queued writes are visible while the primary is unavailable.
+	if got, err := fs.Get(ctx, "bar"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "bar2"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if _, err := fs.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+
+	if n, err := fs.Replay(ctx); !errors.Is(err, errOutage) || n != 0 {
+		t.Errorf("Replay() returned unexpected result - got: (%d, %v) want: (0, %v)", n, err, errOutage)
+	}
+	if got, want := fs.QueueLen(), 3; got != want {
+		t.Errorf("QueueLen() returned unexpected length - got: %d want: %d", got, want)
+	}
+
+	ds.down = false
+	if n, err := fs.Replay(ctx); err != nil || n != 3 {
+		t.Errorf("Replay() returned unexpected result - got: (%d, %v) want: (3, <nil>)", n, err)
+	}
+	if got, want := fs.QueueLen(), 0; got != want {
+		t.Errorf("QueueLen() returned unexpected length - got: %d want: %d", got, want)
+	}
+	if got, err := ds.Store.Get(ctx, "bar"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "bar2"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if _, err := ds.Store.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+
+	wantStats := failover.Stats{FallbackHits: 1, FallbackMisses: 1, QueuedWrites: 3, RejectedWrites: 1, ReplayedWrites: 3}
+	if diff := cmp.Diff(wantStats, fs.Stats()); diff != "" {
+		t.Errorf("Stats() returned unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	ctx := context.Background()
+	fs := failover.New[fakeSession](struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()}, &failover.Options[fakeSession]{})
+	if err := fs.Update(ctx, "foo", &fakeSession{}, time.Hour); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Update() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	if _, err := fs.UserSessions(ctx, "alice"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("UserSessions() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestPendingWrites(t *testing.T) {
+	ctx := context.Background()
+	ds := &downStore{Store: memory.New[fakeSession]()}
+	fs := failover.New[fakeSession](ds, &failover.Options[fakeSession]{
+		WritePolicy: failover.QueueWrites,
+	})
+
+	ds.down = true
+	s := &fakeSession{Name: "foo"}
+	if err := fs.Set(ctx, "foo", s, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	// Subsequent modification by the caller affects neither the replica nor
+	// the queued write.
+	s.Name = "modified"
+
+	// While writes for "foo" are queued, it is served from the replica, and
+	// subsequent writes are queued, even once the primary is available.
+	ds.down = false
+	if got, err := fs.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "foo"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if err := fs.Update(ctx, "foo", &fakeSession{Name: "foo2"}, time.Hour); err != nil {
+		t.Errorf("Update() returned unexpected error: %v", err)
+	}
+	if got, want := fs.QueueLen(), 2; got != want {
+		t.Errorf("QueueLen() returned unexpected length - got: %d want: %d", got, want)
+	}
+	if _, err := ds.Store.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+
+	if n, err := fs.Replay(ctx); err != nil || n != 2 {
+		t.Errorf("Replay() returned unexpected result - got: (%d, %v) want: (2, <nil>)", n, err)
+	}
+	if got, err := ds.Store.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "foo2"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+
+	// Once replayed, writes are no longer queued.
+	if err := fs.Del(ctx, "foo"); err != nil {
+		t.Errorf("Del() returned unexpected error: %v", err)
+	}
+	if got, want := fs.QueueLen(), 0; got != want {
+		t.Errorf("QueueLen() returned unexpected length - got: %d want: %d", got, want)
+	}
+	if _, err := ds.Store.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+}
+
+// gatedStore wraps a downStore, blocking Sets on gate (if non-nil) until it is
+// closed or the Context is done.
+type gatedStore struct {
+	*downStore
+	gate    chan struct{}
+	entered chan struct{}
+}
+
+func (gs *gatedStore) Set(ctx context.Context, sid string, s *fakeSession, ttl time.Duration) error {
+	if gs.gate != nil {
+		gs.entered <- struct{}{}
+		select {
+		case <-gs.gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return gs.downStore.Set(ctx, sid, s, ttl)
+}
+
+func TestReplayConcurrent(t *testing.T) {
+	ctx := context.Background()
+	gs := &gatedStore{downStore: &downStore{Store: memory.New[fakeSession]()}}
+	fs := failover.New[fakeSession](gs, &failover.Options[fakeSession]{
+		WritePolicy: failover.QueueWrites,
+	})
+
+	gs.down = true
+	if err := fs.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	gs.down = false
+	if err := gs.Store.Set(ctx, "bar", &fakeSession{Name: "bar"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+
+	gs.gate = make(chan struct{})
+	gs.entered = make(chan struct{})
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		n, err := fs.Replay(ctx)
+		done <- result{n, err}
+	}()
+	<-gs.entered
+
+	// Other operations are not blocked while replay is in progress.
+	if got, err := fs.Get(ctx, "bar"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "bar"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if err := fs.Update(ctx, "foo", &fakeSession{Name: "foo2"}, time.Hour); err != nil {
+		t.Errorf("Update() returned unexpected error: %v", err)
+	}
+
+	close(gs.gate)
+	if r := <-done; r.err != nil || r.n != 1 {
+		t.Errorf("Replay() returned unexpected result - got: (%d, %v) want: (1, <nil>)", r.n, r.err)
+	}
+	// The Update queued during replay remains queued.
+	if got, want := fs.QueueLen(), 1; got != want {
+		t.Errorf("QueueLen() returned unexpected length - got: %d want: %d", got, want)
+	}
+	if n, err := fs.Replay(ctx); err != nil || n != 1 {
+		t.Errorf("Replay() returned unexpected result - got: (%d, %v) want: (1, <nil>)", n, err)
+	}
+	if got, err := gs.Store.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "foo2"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+}
+
+func TestReplayContextDone(t *testing.T) {
+	ctx := context.Background()
+	gs := &gatedStore{downStore: &downStore{Store: memory.New[fakeSession]()}}
+	fs := failover.New[fakeSession](gs, &failover.Options[fakeSession]{
+		WritePolicy: failover.QueueWrites,
+	})
+
+	gs.down = true
+	if err := fs.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	gs.down = false
+	gs.gate = make(chan struct{})
+	gs.entered = make(chan struct{}, 1)
+
+	// Writes are not dropped when replay is abandoned.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if n, err := fs.Replay(cctx); !errors.Is(err, context.Canceled) || n != 0 {
+		t.Errorf("Replay() returned unexpected result - got: (%d, %v) want: (0, %v)", n, err, context.Canceled)
+	}
+	if got, want := fs.QueueLen(), 1; got != want {
+		t.Errorf("QueueLen() returned unexpected length - got: %d want: %d", got, want)
+	}
+	if got, want := fs.Stats().DroppedWrites, int64(0); got != want {
+		t.Errorf("Stats() returned unexpected DroppedWrites - got: %d want: %d", got, want)
+	}
+}
+
+func TestStoreSupports(t *testing.T) {
+	fs := failover.New[fakeSession](struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()}, &failover.Options[fakeSession]{})
+	if store.Supports[store.Updater[fakeSession]](fs) {
+		t.Error("Supports() unexpectedly returned true for Updater not implemented by the primary store")
+	}
+	if !store.Supports[store.Updater[fakeSession]](failover.New[fakeSession](memory.New[fakeSession](), &failover.Options[fakeSession]{})) {
+		t.Error("Supports() unexpectedly returned false for Updater implemented by the primary store")
+	}
+}
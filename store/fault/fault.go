@@ -0,0 +1,288 @@
+// Package fault provides a SessionStore wrapper that injects faults (errors
+// and latency) into operations, for testing the behavior of applications under
+// SessionStore failures.
+package fault
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+// ErrInjected is a convenience error for use as Rule.Err. Consider wrapping it
+// via store.MarkRetryable or store.MarkPermanent to control how the Manager
+// retry policy treats it.
+var ErrInjected = errors.New("injected fault")
+
+// Rule describes a fault injected into matching operations.
+type Rule struct {
+	// Ops are the operations to which the rule applies (e.g., store.OpGet). If
+	// empty, the rule applies to all operations.
+	Ops []string
+	// Err is returned by matching operations in place of invoking the wrapped
+	// SessionStore. If nil, the operation proceeds (e.g., after Latency).
+	Err error
+	// Latency is added to matching operations, prior to either returning Err
+	// or invoking the wrapped SessionStore. If the operation Context is done
+	// first, its error is returned instead.
+	Latency time.Duration
+	// FirstN, if positive, limits the rule to the first N matching operations,
+	// after which it no longer applies (e.g., to simulate a brief outage).
+	FirstN int
+	// Probability, if positive, is the probability with which the rule applies
+	// to each matching operation. Otherwise, it always applies.
+	Probability float64
+}
+
+// Call records an operation performed on the Store.
+type Call struct {
+	// Op is the operation name (e.g., store.OpGet).
+	Op string
+	// Key is the SID (or user identifier, for store.OpUserSessions) provided to the
+	// operation, if any.
+	Key string
+	// Err is the error returned by the operation, whether injected or returned
+	// by the wrapped SessionStore.
+	Err error
+	// Injected indicates whether Err was injected by a Rule.
+	Injected bool
+}
+
+// rule is a Rule together with the number of operations it has matched.
+type rule struct {
+	Rule
+	matched int
+}
+
+func (r *rule) matches(op string) bool {
+	return len(r.Ops) == 0 || slices.Contains(r.Ops, op)
+}
+
+// Store is a SessionStore wrapping another SessionStore, injecting faults into
+// operations according to the configured Rules, and recording all operations
+// (see Calls). Store implements the optional store.UserIndex,
+// store.UserSessionLimiter, store.Updater, and store.Enumerator interfaces,
+// delegating to the wrapped SessionStore if supported (see store.Supports).
+//
+// Multiple goroutines may use a given Store concurrently.
+type Store[S any] struct {
+	// Rand can be used to override the source of randomness used for
+	// Rule.Probability (e.g., to make tests deterministic). It must return a
+	// value in the interval [0, 1).
+	Rand  func() float64
+	s     store.SessionStore[S]
+	mu    sync.Mutex
+	rules []*rule
+	calls []Call
+}
+
+// New returns a new Store wrapping the provided SessionStore, with no Rules
+// configured.
+func New[S any](s store.SessionStore[S]) *Store[S] {
+	return &Store[S]{
+		Rand: rand.Float64,
+		s:    s,
+	}
+}
+
+// Inject adds the provided Rule. Rules are evaluated in the order added: the
+// Latency of all applicable Rules is added, and the Err of the first
+// applicable Rule with a non-nil Err is returned.
+func (fs *Store[S]) Inject(r Rule) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rules = append(fs.rules, &rule{Rule: r})
+}
+
+// Fail is shorthand for injecting a Rule returning err from the provided
+// operations (or all operations, if none are provided).
+func (fs *Store[S]) Fail(err error, ops ...string) {
+	fs.Inject(Rule{Ops: ops, Err: err})
+}
+
+// Reset removes all Rules and recorded Calls.
+func (fs *Store[S]) Reset() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rules = nil
+	fs.calls = nil
+}
+
+// Calls returns all operations performed on the Store, in order.
+func (fs *Store[S]) Calls() []Call {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return slices.Clone(fs.calls)
+}
+
+// CallCount returns the number of times the provided operation was performed
+// on the Store.
+func (fs *Store[S]) CallCount(op string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var n int
+	for _, c := range fs.calls {
+		if c.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// evaluate determines the fault to inject into the provided operation.
+func (fs *Store[S]) evaluate(op string) (time.Duration, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var latency time.Duration
+	var err error
+	for _, r := range fs.rules {
+		if !r.matches(op) {
+			continue
+		}
+		if r.FirstN > 0 {
+			if r.matched >= r.FirstN {
+				continue
+			}
+			r.matched++
+		}
+		if r.Probability > 0 && fs.Rand() >= r.Probability {
+			continue
+		}
+		latency += r.Latency
+		if err == nil {
+			err = r.Err
+		}
+	}
+	return latency, err
+}
+
+func (fs *Store[S]) record(op, key string, err error, injected bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.calls = append(fs.calls, Call{Op: op, Key: key, Err: err, Injected: injected})
+}
+
+// do injects any applicable fault into the provided operation, invoking fn
+// otherwise, and records the outcome.
+func (fs *Store[S]) do(ctx context.Context, op, key string, fn func() error) error {
+	latency, err := fs.evaluate(op)
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			fs.record(op, key, ctx.Err(), true)
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if err != nil {
+		fs.record(op, key, err, true)
+		return err
+	}
+	err = fn()
+	fs.record(op, key, err, false)
+	return err
+}
+
+// Unwrap implements store.Unwrapper.
+func (fs *Store[S]) Unwrap() store.SessionStore[S] {
+	return fs.s
+}
+
+// Get implements store.SessionStore.
+func (fs *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	var s *S
+	err := fs.do(ctx, store.OpGet, sid, func() (err error) {
+		s, err = fs.s.Get(ctx, sid)
+		return err
+	})
+	return s, err
+}
+
+// Set implements store.SessionStore.
+func (fs *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	return fs.do(ctx, store.OpSet, sid, func() error {
+		return fs.s.Set(ctx, sid, s, ttl)
+	})
+}
+
+// Del implements store.SessionStore.
+func (fs *Store[S]) Del(ctx context.Context, sid string) error {
+	return fs.do(ctx, store.OpDel, sid, func() error {
+		return fs.s.Del(ctx, sid)
+	})
+}
+
+// Update implements store.Updater.
+func (fs *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	return fs.do(ctx, store.OpUpdate, sid, func() error {
+		u, ok := fs.s.(store.Updater[S])
+		if !ok {
+			return store.Unsupported("store.Updater")
+		}
+		return u.Update(ctx, sid, s, ttl)
+	})
+}
+
+// SetLimited implements store.UserSessionLimiter.
+func (fs *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	var evicted []string
+	err := fs.do(ctx, store.OpSetLimited, sid, func() (err error) {
+		l, ok := fs.s.(store.UserSessionLimiter[S])
+		if !ok {
+			return store.Unsupported("store.UserSessionLimiter")
+		}
+		evicted, err = l.SetLimited(ctx, sid, s, ttl, limit, evict)
+		return err
+	})
+	return evicted, err
+}
+
+// UserSessions implements store.UserIndex.
+func (fs *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	var sids []string
+	err := fs.do(ctx, store.OpUserSessions, uid, func() (err error) {
+		ui, ok := fs.s.(store.UserIndex)
+		if !ok {
+			return store.Unsupported("store.UserIndex")
+		}
+		sids, err = ui.UserSessions(ctx, uid)
+		return err
+	})
+	return sids, err
+}
+
+// Scan implements store.Scanner.
+func (fs *Store[S]) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[S], string, error) {
+	var entries []store.Entry[S]
+	var next string
+	err := fs.do(ctx, store.OpScan, "", func() (err error) {
+		sc, ok := fs.s.(store.Scanner[S])
+		if !ok {
+			return store.Unsupported("store.Scanner")
+		}
+		entries, next, err = sc.Scan(ctx, cursor, count)
+		return err
+	})
+	return entries, next, err
+}
+
+// Count implements store.Enumerator.
+func (fs *Store[S]) Count(ctx context.Context) (int, error) {
+	var n int
+	err := fs.do(ctx, store.OpCount, "", func() (err error) {
+		e, ok := fs.s.(store.Enumerator[S])
+		if !ok {
+			return store.Unsupported("store.Enumerator")
+		}
+		n, err = e.Count(ctx)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,152 @@
+package fault_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/fault"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+type fakeSession struct {
+	Name string
+}
+
+func TestRules(t *testing.T) {
+	errTransient := store.MarkRetryable(fault.ErrInjected)
+	testCases := []struct {
+		name  string
+		rules []fault.Rule
+		rand  []float64
+		want  []error
+	}{
+		{
+			name: "no rules",
+			want: []error{nil, nil, nil},
+		},
+		{
+			name:  "fail",
+			rules: []fault.Rule{{Ops: []string{store.OpGet}, Err: errTransient}},
+			want:  []error{errTransient, errTransient, errTransient},
+		},
+		{
+			name:  "other op",
+			rules: []fault.Rule{{Ops: []string{store.OpSet}, Err: errTransient}},
+			want:  []error{nil, nil, nil},
+		},
+		{
+			name:  "first n",
+			rules: []fault.Rule{{Err: errTransient, FirstN: 2}},
+			want:  []error{errTransient, errTransient, nil},
+		},
+		{
+			name:  "probabilistic",
+			rules: []fault.Rule{{Err: errTransient, Probability: 0.5}},
+			rand:  []float64{0.7, 0.2, 0.5},
+			want:  []error{nil, errTransient, nil},
+		},
+		{
+			name: "first applicable error wins",
+			rules: []fault.Rule{
+				{Err: store.ErrSessionNotFound, FirstN: 1},
+				{Err: errTransient},
+			},
+			want: []error{store.ErrSessionNotFound, errTransient, errTransient},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ms := memory.New[fakeSession]()
+			if err := ms.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+				t.Fatalf("Set() returned unexpected error: %v", err)
+			}
+			fs := fault.New[fakeSession](ms)
+			fs.Rand = func() float64 {
+				r := tc.rand[0]
+				tc.rand = tc.rand[1:]
+				return r
+			}
+			for _, r := range tc.rules {
+				fs.Inject(r)
+			}
+			var wantCalls []fault.Call
+			for i, want := range tc.want {
+				_, err := fs.Get(ctx, "foo")
+				if err != want {
+					t.Errorf("Get() #%d returned unexpected error - got: %v want: %v", i, err, want)
+				}
+				wantCalls = append(wantCalls, fault.Call{Op: store.OpGet, Key: "foo", Err: want, Injected: want != nil})
+			}
+			if diff := cmp.Diff(wantCalls, fs.Calls(), cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Calls() returned unexpected calls (-want +got):\n%s", diff)
+			}
+			if got, want := fs.CallCount(store.OpGet), len(tc.want); got != want {
+				t.Errorf("CallCount() returned unexpected count - got: %d want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLatency(t *testing.T) {
+	fs := fault.New[fakeSession](memory.New[fakeSession]())
+	fs.Inject(fault.Rule{Ops: []string{store.OpSet}, Latency: 20 * time.Millisecond})
+
+	start := time.Now()
+	if err := fs.Set(context.Background(), "foo", &fakeSession{}, time.Hour); err != nil {
+		t.Errorf("Set() returned unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Set() returned sooner than expected: %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := fs.Set(ctx, "bar", &fakeSession{}, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set() returned unexpected error - got: %v want: %v", err, context.DeadlineExceeded)
+	}
+
+	fs.Reset()
+	if _, err := fs.Get(context.Background(), "bar"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	want := []fault.Call{{Op: store.OpGet, Key: "bar", Err: store.ErrSessionNotFound}}
+	if diff := cmp.Diff(want, fs.Calls(), cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Calls() returned unexpected calls (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	ctx := context.Background()
+	fs := fault.New[fakeSession](struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()})
+	if err := fs.Update(ctx, "foo", &fakeSession{}, time.Hour); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Update() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	if _, err := fs.Count(ctx); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Count() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	// Injected faults take precedence.
+	fs.Fail(fault.ErrInjected, store.OpUpdate)
+	if err := fs.Update(ctx, "foo", &fakeSession{}, time.Hour); !errors.Is(err, fault.ErrInjected) {
+		t.Errorf("Update() returned unexpected error - got: %v want: %v", err, fault.ErrInjected)
+	}
+}
+
+func TestStoreSupports(t *testing.T) {
+	fs := fault.New[fakeSession](struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()})
+	if store.Supports[store.Updater[fakeSession]](fs) {
+		t.Error("Supports() unexpectedly returned true for Updater not implemented by the wrapped store")
+	}
+	if !store.Supports[store.Updater[fakeSession]](fault.New[fakeSession](memory.New[fakeSession]())) {
+		t.Error("Supports() unexpectedly returned false for Updater implemented by the wrapped store")
+	}
+}
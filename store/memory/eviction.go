@@ -8,6 +8,7 @@ import (
 type trackedItem struct {
 	expires time.Time
 	key     string
+	index   int // position in trackedItems, maintained by heap operations
 }
 
 type trackedItems []*trackedItem
@@ -22,10 +23,14 @@ func (ti trackedItems) Less(i, j int) bool {
 
 func (ti trackedItems) Swap(i, j int) {
 	ti[i], ti[j] = ti[j], ti[i]
+	ti[i].index = i
+	ti[j].index = j
 }
 
 func (ti *trackedItems) Push(e any) {
-	*ti = append(*ti, e.(*trackedItem))
+	item := e.(*trackedItem)
+	item.index = len(*ti)
+	*ti = append(*ti, item)
 }
 
 func (ti *trackedItems) Pop() any {
@@ -33,6 +38,7 @@ func (ti *trackedItems) Pop() any {
 	e := (*ti)[n-1]
 	(*ti)[n-1] = nil
 	*ti = (*ti)[:n-1]
+	e.index = -1
 	return e
 }
 
@@ -46,11 +52,13 @@ func newEvictionQueue() *evictionQueue {
 	return eq
 }
 
-func (eq *evictionQueue) Push(key string, expires time.Time) {
-	heap.Push(&eq.items, &trackedItem{
+func (eq *evictionQueue) Push(key string, expires time.Time) *trackedItem {
+	item := &trackedItem{
 		expires: expires,
 		key:     key,
-	})
+	}
+	heap.Push(&eq.items, item)
+	return item
 }
 
 func (eq *evictionQueue) Pop() *trackedItem {
@@ -61,6 +69,12 @@ func (eq *evictionQueue) Peek() *trackedItem {
 	return eq.items[0]
 }
 
+// Remove removes the provided item, which must have been returned by Push and
+// not yet removed, from the queue.
+func (eq *evictionQueue) Remove(item *trackedItem) {
+	heap.Remove(&eq.items, item.index)
+}
+
 func (eq *evictionQueue) Len() int {
 	return eq.items.Len()
 }
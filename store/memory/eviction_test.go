@@ -59,3 +59,19 @@ func TestEvictionQueue(t *testing.T) {
 		})
 	}
 }
+
+func TestEvictionQueueRemove(t *testing.T) {
+	now := time.Now()
+	eq := newEvictionQueue()
+	a := eq.Push("a", now.Add(time.Minute))
+	b := eq.Push("b", now.Add(2*time.Minute))
+	c := eq.Push("c", now.Add(3*time.Minute))
+	eq.Remove(a)
+	eq.Remove(c)
+	if got, want := eq.Len(), 1; got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+	if got, want := eq.Peek(), b; got != want {
+		t.Errorf("Peek() = %v, want %v", got, want)
+	}
+}
@@ -2,44 +2,287 @@
 package memory
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/swfrench/simple-session/store"
 )
 
+// EvictionPolicy determines which session is evicted when a Store reaches its
+// configured capacity.
+type EvictionPolicy int
+
+const (
+	// EvictLeastRecentlyUsed evicts the session that was least recently
+	// accessed via Get or Set.
+	EvictLeastRecentlyUsed EvictionPolicy = iota
+	// EvictSoonestExpiring evicts the session closest to its expiration time.
+	EvictSoonestExpiring
+)
+
+// Options represents tunable knobs that control the behavior of Store.
+type Options[S any] struct {
+	// MaxEntries is the maximum number of sessions held by the Store. When
+	// reached, sessions are evicted according to EvictionPolicy to make room
+	// for new ones.
+	// Default if unspecified: 0, in which case the number of sessions is not
+	// bounded.
+	MaxEntries int
+	// MaxBytes is the maximum total size of sessions held by the Store, as
+	// measured by SizeOf. When reached, sessions are evicted according to
+	// EvictionPolicy to make room for new ones.
+	// Default if unspecified: 0, in which case total size is not bounded.
+	MaxBytes int
+	// SizeOf is a user-supplied function returning the size in bytes of the
+	// provided session, used to enforce MaxBytes.
+	// Default if unspecified: the length of the session encoded by Codec, or
+	// its JSON encoding if Codec is also unspecified.
+	SizeOf func(*S) (int, error)
+	// EvictionPolicy determines which session is evicted when either of
+	// MaxEntries or MaxBytes is reached.
+	// Default if unspecified: EvictLeastRecentlyUsed
+	EvictionPolicy EvictionPolicy
+	// OnEvict is a user-supplied callback invoked with the SID of each session
+	// evicted to enforce MaxEntries or MaxBytes (sessions removed on expiry are
+	// not reported). It is invoked while the Store lock is held, and thus must
+	// not call back into the Store.
+	// Default if unspecified: nil, in which case OnEvict is not invoked.
+	OnEvict func(sid string)
+	// Codec, if provided, is used to serialize sessions on Set and deserialize
+	// them on Get, such that the Store holds copies rather than pointers to
+	// the caller's objects. This is useful to faithfully emulate a serializing
+	// store (e.g., store/redis) in tests.
+	// Default if unspecified: nil, in which case pointers are stored as-is.
+	Codec Codec
+	// UserID is a user-supplied function returning the identifier of the user
+	// associated with the provided session, used to maintain an index of
+	// sessions by user (see UserSessions). Sessions for which UserID returns
+	// the empty string are not indexed.
+	// Default if unspecified: nil, in which case no index is maintained.
+	UserID func(*S) string
+}
+
+// Codec marshals sessions to and from their serialized representation.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is a Codec using encoding/json, consistent with store/redis.
+type JSONCodec struct{}
+
+// Marshal returns the JSON encoding of v.
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal parses the JSON-encoded data and stores the result in v.
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
 // Store is a simple in-memory session store, for use in tests or where an
 // external store is not available.
 //
-// Notably, it _does not_ "store" sessions as values in serialized form, but
-// simply stores pointers to the original objects passed to it (i.e., if you
-// mutate said objects, the objects in the store are also mutated, since they
-// are one and the same).
+// Notably, unless configured with a Codec (see Options and NewSerializing), it
+// _does not_ "store" sessions as values in serialized form, but simply stores
+// pointers to the original objects passed to it (i.e., if you mutate said
+// objects, the objects in the store are also mutated, since they are one and
+// the same).
 //
 // Eviction: Expired sessions are garbage collected on entry to any Store
-// method.
+// method, and optionally by a background janitor (see StartJanitor). If the
+// Store is configured with a maximum capacity (see Options), unexpired sessions
+// may also be evicted to make room for new ones.
 type Store[S any] struct {
 	// Clock can be overridden in tests (e.g., to test eviciton logic).
 	Clock     func() time.Time
 	mu        sync.Mutex
-	items     map[string]*S
+	opts      *Options[S]
+	items     map[string]*entry[S]
 	evictions *evictionQueue
+	recency   *list.List // front is most recently used
+	bytes     int
+	users     map[string]map[string]*entry[S] // user ID -> SID -> entry
+	stop      chan struct{}
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+	// snapshotPath is the destination of periodic snapshots, if any.
+	snapshotPath string
+	// ticks, if non-nil, replaces the ticker used by background goroutines
+	// (e.g., to drive them from tests).
+	ticks <-chan time.Time
 }
 
-// New returns a new Store instance.
+// entry is a single stored session, together with its bookkeeping state.
+type entry[S any] struct {
+	val     *S
+	data    []byte // populated in place of val when a Codec is configured
+	expires time.Time
+	size    int
+	elem    *list.Element
+	tracked *trackedItem
+	uid     string
+}
+
+// New returns a new Store instance with no capacity bounds.
 func New[S any]() *Store[S] {
+	return NewWithOptions(&Options[S]{})
+}
+
+// NewWithOptions returns a new Store instance respecting the provided options.
+func NewWithOptions[S any](opts *Options[S]) *Store[S] {
 	ms := &Store[S]{
 		Clock:     func() time.Time { return time.Now() },
-		items:     make(map[string]*S),
+		opts:      opts,
+		items:     make(map[string]*entry[S]),
 		evictions: newEvictionQueue(),
+		recency:   list.New(),
+		users:     make(map[string]map[string]*entry[S]),
+		stop:      make(chan struct{}),
 	}
 	return ms
 }
 
+// NewSerializing returns a new Store instance with no capacity bounds, which
+// stores sessions in serialized form using JSONCodec.
+func NewSerializing[S any]() *Store[S] {
+	return NewWithOptions(&Options[S]{Codec: JSONCodec{}})
+}
+
+// every invokes fn at the provided interval on a background goroutine until
+// the Store is closed.
+func (ms *Store[S]) every(interval time.Duration, fn func()) {
+	ms.workers.Add(1)
+	go func() {
+		defer ms.workers.Done()
+		ticks := ms.ticks
+		if ticks == nil {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+		for {
+			select {
+			case <-ms.stop:
+				return
+			case <-ticks:
+				fn()
+			}
+		}
+	}()
+}
+
+// StartJanitor starts a background goroutine that garbage collects expired
+// sessions at the provided interval, independent of calls to other Store
+// methods (i.e., so that an idle Store does not retain expired sessions). Close
+// must be called to stop the goroutine once the Store is no longer needed.
+// Note: Clock must not be overridden after StartJanitor is called.
+func (ms *Store[S]) StartJanitor(interval time.Duration) {
+	ms.every(interval, ms.sweep)
+}
+
+// sweep garbage collects expired sessions.
+func (ms *Store[S]) sweep() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.evict(ms.Clock())
+}
+
+// Close stops any background goroutines started by the Store, waiting for them
+// to exit. If periodic snapshots were started (see StartSnapshots), a final
+// snapshot is then written, and any error doing so is returned. The Store
+// remains usable after Close, but expired sessions will only be garbage
+// collected on entry to Store methods. Close is idempotent.
+func (ms *Store[S]) Close() error {
+	var err error
+	ms.closeOnce.Do(func() {
+		close(ms.stop)
+		ms.workers.Wait()
+		if ms.snapshotPath != "" {
+			err = ms.SnapshotToFile(ms.snapshotPath)
+		}
+	})
+	return err
+}
+
+// Len returns the number of sessions held by the Store, including any expired
+// sessions that have not yet been garbage collected.
+func (ms *Store[S]) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return len(ms.items)
+}
+
+func (ms *Store[S]) sizeOf(s *S, data []byte) (int, error) {
+	if ms.opts.SizeOf != nil {
+		return ms.opts.SizeOf(s)
+	}
+	if ms.opts.Codec == nil {
+		var err error
+		if data, err = json.Marshal(s); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (ms *Store[S]) remove(sid string) {
+	e := ms.items[sid]
+	ms.recency.Remove(e.elem)
+	ms.evictions.Remove(e.tracked)
+	ms.bytes -= e.size
+	delete(ms.items, sid)
+	if e.uid != "" {
+		delete(ms.users[e.uid], sid)
+		if len(ms.users[e.uid]) == 0 {
+			delete(ms.users, e.uid)
+		}
+	}
+}
+
 func (ms *Store[S]) evict(t time.Time) {
 	for ms.evictions.Len() > 0 && ms.evictions.Peek().expires.Before(t) {
-		delete(ms.items, ms.evictions.Pop().key)
+		ms.remove(ms.evictions.Peek().key)
+	}
+}
+
+// evictOne evicts a single unexpired session according to the configured
+// EvictionPolicy, returning false if there was nothing to evict.
+func (ms *Store[S]) evictOne() bool {
+	var sid string
+	switch ms.opts.EvictionPolicy {
+	case EvictSoonestExpiring:
+		if ms.evictions.Len() > 0 {
+			sid = ms.evictions.Peek().key
+		}
+	default:
+		if back := ms.recency.Back(); back != nil {
+			sid = back.Value.(string)
+		}
+	}
+	if sid == "" {
+		return false
+	}
+	ms.remove(sid)
+	if ms.opts.OnEvict != nil {
+		ms.opts.OnEvict(sid)
+	}
+	return true
+}
+
+// makeRoom evicts sessions as needed to admit a new session of the provided
+// size.
+func (ms *Store[S]) makeRoom(size int) {
+	for ms.opts.MaxEntries > 0 && len(ms.items) >= ms.opts.MaxEntries && ms.evictOne() {
+	}
+	for ms.opts.MaxBytes > 0 && ms.bytes+size > ms.opts.MaxBytes && ms.evictOne() {
 	}
 }
 
@@ -49,16 +292,26 @@ func (ms *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 	ms.evict(ms.Clock())
-	s, ok := ms.items[sid]
+	e, ok := ms.items[sid]
 	if !ok {
 		return nil, store.ErrSessionNotFound
 	}
+	ms.recency.MoveToFront(e.elem)
+	if ms.opts.Codec == nil {
+		return e.val, nil
+	}
+	s := new(S)
+	if err := ms.opts.Codec.Unmarshal(e.data, s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session data (error: %v): %w", err, store.ErrInvalidStoredSessionData)
+	}
 	return s, nil
 }
 
 // Set stores the provided session data associated with the provided SID and
 // TTL, returning ErrSessionExists if a session is already associated with the
-// former.
+// former. ErrInvalidSessionData is returned if the Store is configured with a
+// Codec that cannot marshal the session, or with MaxBytes and the session is
+// larger than the latter (or its size cannot be determined).
 func (ms *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
@@ -67,11 +320,168 @@ func (ms *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration
 	if _, ok := ms.items[sid]; ok {
 		return store.ErrSessionExists
 	}
-	ms.items[sid] = s
-	ms.evictions.Push(sid, t.Add(ttl))
+	return ms.insert(sid, s, t.Add(ttl))
+}
+
+// insert stores the provided session data associated with the provided SID and
+// absolute expiration time. The caller must hold the Store lock and have
+// verified that no session is already associated with the SID.
+func (ms *Store[S]) insert(sid string, s *S, expires time.Time) error {
+	data, size, err := ms.encode(s)
+	if err != nil {
+		return err
+	}
+	ms.insertEncoded(sid, s, data, size, expires)
+	return nil
+}
+
+// encode prepares the provided session data for storage, returning its
+// marshaled form (if a Codec is configured) and size (if MaxBytes is
+// configured), or an error if it cannot be stored.
+func (ms *Store[S]) encode(s *S) ([]byte, int, error) {
+	var data []byte
+	if ms.opts.Codec != nil {
+		var err error
+		if data, err = ms.opts.Codec.Marshal(s); err != nil {
+			return nil, 0, fmt.Errorf("failed to marshal session data (error: %v): %w", err, store.ErrInvalidSessionData)
+		}
+	}
+	var size int
+	if ms.opts.MaxBytes > 0 {
+		var err error
+		size, err = ms.sizeOf(s, data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to determine session size (error: %v): %w", err, store.ErrInvalidSessionData)
+		}
+		if size > ms.opts.MaxBytes {
+			return nil, 0, fmt.Errorf("session size %d exceeds store capacity %d: %w", size, ms.opts.MaxBytes, store.ErrInvalidSessionData)
+		}
+	}
+	return data, size, nil
+}
+
+// insertEncoded behaves as insert, for session data previously prepared by
+// encode.
+func (ms *Store[S]) insertEncoded(sid string, s *S, data []byte, size int, expires time.Time) {
+	ms.makeRoom(size)
+	e := &entry[S]{
+		expires: expires,
+		size:    size,
+		elem:    ms.recency.PushFront(sid),
+		tracked: ms.evictions.Push(sid, expires),
+	}
+	if ms.opts.Codec != nil {
+		e.data = data
+	} else {
+		e.val = s
+	}
+	ms.items[sid] = e
+	ms.bytes += size
+	if ms.opts.UserID != nil {
+		if e.uid = ms.opts.UserID(s); e.uid != "" {
+			if ms.users[e.uid] == nil {
+				ms.users[e.uid] = make(map[string]*entry[S])
+			}
+			ms.users[e.uid][sid] = e
+		}
+	}
+}
+
+// Update replaces the stored session data associated with the provided SID,
+// which will now expire after the provided TTL, returning ErrSessionNotFound if
+// no stored session exists.
+func (ms *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t := ms.Clock()
+	ms.evict(t)
+	if _, ok := ms.items[sid]; !ok {
+		return store.ErrSessionNotFound
+	}
+	// Encode prior to removal, such that the existing session is retained if
+	// the replacement cannot be stored.
+	data, size, err := ms.encode(s)
+	if err != nil {
+		return err
+	}
+	ms.remove(sid)
+	ms.insertEncoded(sid, s, data, size, t.Add(ttl))
 	return nil
 }
 
+// SetLimited behaves as Set, but additionally ensures that at most limit
+// unexpired sessions are associated with the user of the provided session
+// (see Options.UserID). If storing the session would exceed the limit, then if
+// evict is false ErrUserSessionLimit is returned, otherwise the sessions
+// soonest to expire are deleted to make room and their SIDs are returned.
+// Sessions not associated with any user are stored as with Set.
+func (ms *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	if ms.opts.UserID == nil {
+		return nil, errUserIDRequired
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t := ms.Clock()
+	ms.evict(t)
+	if _, ok := ms.items[sid]; ok {
+		return nil, store.ErrSessionExists
+	}
+	uid := ms.opts.UserID(s)
+	if uid == "" || limit <= 0 || len(ms.users[uid]) < limit {
+		return nil, ms.insert(sid, s, t.Add(ttl))
+	}
+	if !evict {
+		return nil, store.ErrUserSessionLimit
+	}
+	// As in Update, encode prior to evicting existing sessions.
+	data, size, err := ms.encode(s)
+	if err != nil {
+		return nil, err
+	}
+	var existing []*entry[S]
+	for _, e := range ms.users[uid] {
+		existing = append(existing, e)
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].expires.Before(existing[j].expires)
+	})
+	var evicted []string
+	for _, e := range existing[:len(existing)-limit+1] {
+		evicted = append(evicted, e.tracked.key)
+		ms.remove(e.tracked.key)
+	}
+	ms.insertEncoded(sid, s, data, size, t.Add(ttl))
+	return evicted, nil
+}
+
+// errUserIDRequired is returned by SetLimited if Options.UserID is not
+// configured. It is marked as permanent, since retrying cannot succeed.
+var errUserIDRequired = store.MarkPermanent(errors.New("user session limits require Options.UserID"))
+
+// UserIndexEnabled implements store.UserIndexProber, reporting whether
+// Options.UserID is configured.
+func (ms *Store[S]) UserIndexEnabled() bool {
+	return ms.opts.UserID != nil
+}
+
+// UserSessions returns the SIDs of unexpired sessions associated with the
+// provided user identifier, ordered by increasing expiration time. The Store
+// must be configured with a UserID function (see Options), otherwise no
+// sessions are returned.
+func (ms *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.evict(ms.Clock())
+	var sids []string
+	for sid := range ms.users[uid] {
+		sids = append(sids, sid)
+	}
+	sort.Slice(sids, func(i, j int) bool {
+		return ms.items[sids[i]].expires.Before(ms.items[sids[j]].expires)
+	})
+	return sids, nil
+}
+
 // Del deletes the stored session data associated with the provided SID,
 // returning ErrSessionNotFound if no stored session exists.
 func (ms *Store[S]) Del(ctx context.Context, sid string) error {
@@ -81,7 +491,54 @@ func (ms *Store[S]) Del(ctx context.Context, sid string) error {
 	if _, ok := ms.items[sid]; !ok {
 		return store.ErrSessionNotFound
 	}
-	// Note: We let the evictions entry get cleaned up lazily.
-	delete(ms.items, sid)
+	ms.remove(sid)
 	return nil
 }
+
+// Scan implements store.Scanner, returning up to count unexpired sessions (or
+// all unexpired sessions, if count is not positive) in SID order, starting
+// after the SID provided as the cursor.
+func (ms *Store[S]) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[S], string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t := ms.Clock()
+	ms.evict(t)
+	sids := make([]string, 0, len(ms.items))
+	for sid := range ms.items {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+	// Resume after the cursor, which need not still be present.
+	i := sort.SearchStrings(sids, cursor)
+	if i < len(sids) && sids[i] == cursor {
+		i++
+	}
+	sids = sids[i:]
+	next := ""
+	if count > 0 && len(sids) > count {
+		sids = sids[:count]
+		next = sids[count-1]
+	}
+	entries := make([]store.Entry[S], 0, len(sids))
+	for _, sid := range sids {
+		e := ms.items[sid]
+		s := e.val
+		if ms.opts.Codec != nil {
+			s = new(S)
+			if err := ms.opts.Codec.Unmarshal(e.data, s); err != nil {
+				return nil, "", fmt.Errorf("failed to unmarshal session data (error: %v): %w", err, store.ErrInvalidStoredSessionData)
+			}
+		}
+		entries = append(entries, store.Entry[S]{SID: sid, Session: s, TTL: e.expires.Sub(t)})
+	}
+	return entries, next, nil
+}
+
+// Count implements store.Enumerator, returning the number of unexpired
+// sessions.
+func (ms *Store[S]) Count(ctx context.Context) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.evict(ms.Clock())
+	return len(ms.items), nil
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	ms := New[string]()
+	ms.Clock = func() time.Time { return now }
+	for sid, ttl := range map[string]time.Duration{"foo": time.Hour, "bar": 2 * time.Hour} {
+		s := sid
+		if err := ms.Set(ctx, sid, &s, ttl); err != nil {
+			t.Fatalf("Set() returned unexpected error: %v", err)
+		}
+	}
+
+	// Unexpired sessions are retained.
+	ms.sweep()
+	if got, want := ms.Len(), 2; got != want {
+		t.Fatalf("Len() returned unexpected length before expiration - got: %d want: %d", got, want)
+	}
+
+	// Expired sessions are collected without any further calls to Get, Set,
+	// or Del.
+	now = now.Add(90 * time.Minute)
+	ms.sweep()
+	if got, want := ms.Len(), 1; got != want {
+		t.Fatalf("Len() returned unexpected length after expiration - got: %d want: %d", got, want)
+	}
+
+	// The janitor sweeps on each tick.
+	ticks := make(chan time.Time)
+	ms.ticks = ticks
+	ms.StartJanitor(time.Hour)
+	now = now.Add(time.Hour)
+	ticks <- now
+	// Close waits for the janitor, and thus the sweep, to complete.
+	if err := ms.Close(); err != nil {
+		t.Fatalf("Close() returned unexpected error: %v", err)
+	}
+	if got, want := ms.Len(), 0; got != want {
+		t.Errorf("Len() returned unexpected length after janitor tick - got: %d want: %d", got, want)
+	}
+}
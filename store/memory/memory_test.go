@@ -3,12 +3,14 @@ package memory_test
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/swfrench/simple-session/store"
 	"github.com/swfrench/simple-session/store/memory"
+	"github.com/swfrench/simple-session/store/storetest"
 )
 
 type fakeSession struct {
@@ -25,209 +27,523 @@ func fakeSessionValueNew() *fakeSession {
 	return &fakeSession{SID: "booop"}
 }
 
-func TestMemoryStoreGet(t *testing.T) {
+func TestMemoryStoreCapacity(t *testing.T) {
+	now := time.Now()
+	// op is a Set (or Get, if get is true) of the session with the given SID.
+	type op struct {
+		sid string
+		ttl time.Duration
+		get bool
+	}
 	testCases := []struct {
-		name    string
-		arrange func(t *testing.T, s *memory.Store[fakeSession])
-		get     func(s *memory.Store[fakeSession]) (*fakeSession, error)
-		want    *fakeSession
-		err     error
+		name        string
+		opts        *memory.Options[fakeSession]
+		ops         []op
+		wantEvicted []string
+		wantKept    []string
 	}{
 		{
-			name: "found",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
+			name: "unbounded",
+			opts: &memory.Options[fakeSession]{},
+			ops: []op{
+				{sid: "a", ttl: time.Hour},
+				{sid: "b", ttl: time.Hour},
+				{sid: "c", ttl: time.Hour},
 			},
-			get: func(s *memory.Store[fakeSession]) (*fakeSession, error) {
-				return s.Get(context.Background(), fakeSessionID)
+			wantKept: []string{"a", "b", "c"},
+		},
+		{
+			name: "max entries lru",
+			opts: &memory.Options[fakeSession]{MaxEntries: 2},
+			ops: []op{
+				{sid: "a", ttl: time.Hour},
+				{sid: "b", ttl: time.Hour},
+				{sid: "c", ttl: time.Hour},
 			},
-			want: fakeSessionValue(),
+			wantEvicted: []string{"a"},
+			wantKept:    []string{"b", "c"},
 		},
 		{
-			name: "not found",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
+			name: "max entries lru with get",
+			opts: &memory.Options[fakeSession]{MaxEntries: 2},
+			ops: []op{
+				{sid: "a", ttl: time.Hour},
+				{sid: "b", ttl: time.Hour},
+				{sid: "a", get: true},
+				{sid: "c", ttl: time.Hour},
 			},
-			get: func(s *memory.Store[fakeSession]) (*fakeSession, error) {
-				return s.Get(context.Background(), "beep")
+			wantEvicted: []string{"b"},
+			wantKept:    []string{"a", "c"},
+		},
+		{
+			name: "max entries soonest expiring",
+			opts: &memory.Options[fakeSession]{MaxEntries: 2, EvictionPolicy: memory.EvictSoonestExpiring},
+			ops: []op{
+				{sid: "a", ttl: 2 * time.Hour},
+				{sid: "b", ttl: time.Hour},
+				{sid: "c", ttl: 3 * time.Hour},
 			},
-			err: store.ErrSessionNotFound,
+			wantEvicted: []string{"b"},
+			wantKept:    []string{"a", "c"},
 		},
 		{
-			name: "not found evicted",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				now := time.Now()
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
-				s.Clock = func() time.Time { return now.Add(90 * time.Minute) }
+			name: "max bytes",
+			opts: &memory.Options[fakeSession]{
+				MaxBytes: 25,
+				SizeOf:   func(*fakeSession) (int, error) { return 10, nil },
 			},
-			get: func(s *memory.Store[fakeSession]) (*fakeSession, error) {
-				return s.Get(context.Background(), fakeSessionID)
+			ops: []op{
+				{sid: "a", ttl: time.Hour},
+				{sid: "b", ttl: time.Hour},
+				{sid: "c", ttl: time.Hour},
 			},
-			err: store.ErrSessionNotFound,
+			wantEvicted: []string{"a"},
+			wantKept:    []string{"b", "c"},
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			ms := memory.New[fakeSession]()
-			tc.arrange(t, ms)
-			fs, err := tc.get(ms)
-			if gotErr, wantErr := err != nil, tc.err != nil; gotErr != wantErr {
-				t.Fatalf("Get() returned unexpected error - got error: %t, want error: %t", gotErr, wantErr)
+			var evicted []string
+			tc.opts.OnEvict = func(sid string) {
+				evicted = append(evicted, sid)
 			}
-			if tc.err != nil {
-				if !errors.Is(err, tc.err) {
-					t.Fatalf("Get() returned unexpected error type - got: %v, want: %v", err, tc.err)
+			ms := memory.NewWithOptions(tc.opts)
+			ms.Clock = func() time.Time { return now }
+			for _, o := range tc.ops {
+				if o.get {
+					if _, err := ms.Get(context.Background(), o.sid); err != nil {
+						t.Fatalf("Get(%q) returned unexpected error: %v", o.sid, err)
+					}
+				} else if err := ms.Set(context.Background(), o.sid, &fakeSession{SID: o.sid}, o.ttl); err != nil {
+					t.Fatalf("Set(%q) returned unexpected error: %v", o.sid, err)
 				}
-				return
 			}
-			if diff := cmp.Diff(tc.want, fs); diff != "" {
-				t.Errorf("Get() returned incorrect content (+got, -want):\n%s", diff)
+			if diff := cmp.Diff(tc.wantEvicted, evicted); diff != "" {
+				t.Errorf("OnEvict() observed incorrect SID sequence (+got, -want):\n%s", diff)
+			}
+			for _, sid := range tc.wantKept {
+				if _, err := ms.Get(context.Background(), sid); err != nil {
+					t.Errorf("Get(%q) returned unexpected error for retained session: %v", sid, err)
+				}
+			}
+			for _, sid := range tc.wantEvicted {
+				if _, err := ms.Get(context.Background(), sid); !errors.Is(err, store.ErrSessionNotFound) {
+					t.Errorf("Get(%q) returned unexpected error for evicted session - got: %v, want: %v", sid, err, store.ErrSessionNotFound)
+				}
 			}
 		})
 	}
 }
 
-func TestMemoryStoreSet(t *testing.T) {
+func TestMemoryStoreSetTooLarge(t *testing.T) {
+	ms := memory.NewWithOptions(&memory.Options[fakeSession]{MaxBytes: 4})
+	err := ms.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour)
+	if !errors.Is(err, store.ErrInvalidSessionData) {
+		t.Errorf("Set() returned unexpected error for oversized session - got: %v, want: %v", err, store.ErrInvalidSessionData)
+	}
+}
+
+func TestMemoryStoreDelDoesNotEvictReplacement(t *testing.T) {
+	now := time.Now()
+	ms := memory.New[fakeSession]()
+	ms.Clock = func() time.Time { return now }
+	if err := ms.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
+		t.Fatalf("Unexpected error initializing memory store: %v", err)
+	}
+	if err := ms.Del(context.Background(), fakeSessionID); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	if err := ms.Set(context.Background(), fakeSessionID, fakeSessionValueNew(), 2*time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	// Advance past the expiration of the deleted session, but not that of its
+	// replacement.
+	ms.Clock = func() time.Time { return now.Add(90 * time.Minute) }
+	val, err := ms.Get(context.Background(), fakeSessionID)
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(fakeSessionValueNew(), val); diff != "" {
+		t.Errorf("Get() returned unexpected value (+got, -want):\n%s", diff)
+	}
+}
+
+func TestMemoryStoreCloseIdempotent(t *testing.T) {
+	ms := memory.New[fakeSession]()
+	ms.StartJanitor(time.Millisecond)
+	if err := ms.Close(); err != nil {
+		t.Errorf("Close() returned unexpected error: %v", err)
+	}
+	if err := ms.Close(); err != nil {
+		t.Errorf("Close() returned unexpected error on second call: %v", err)
+	}
+}
+
+func TestMemoryStoreSerializing(t *testing.T) {
+	ms := memory.NewSerializing[fakeSession]()
+	fs := fakeSessionValue()
+	if err := ms.Set(context.Background(), fakeSessionID, fs, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+
+	// Verify that mutating the original object does not mutate the stored
+	// session.
+	fs.SID = "mutated"
+	got, err := ms.Get(context.Background(), fakeSessionID)
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(fakeSessionValue(), got); diff != "" {
+		t.Errorf("Get() returned unexpected value after mutating original (+got, -want):\n%s", diff)
+	}
+
+	// Similarly, verify that mutating the returned object does not mutate the
+	// stored session.
+	got.SID = "mutated"
+	again, err := ms.Get(context.Background(), fakeSessionID)
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(fakeSessionValue(), again); diff != "" {
+		t.Errorf("Get() returned unexpected value after mutating returned object (+got, -want):\n%s", diff)
+	}
+}
+
+type unmarshallableSession struct {
+	C chan int `json:"c"`
+}
+
+func TestMemoryStoreSerializingInvalidData(t *testing.T) {
+	ms := memory.NewSerializing[unmarshallableSession]()
+	err := ms.Set(context.Background(), fakeSessionID, &unmarshallableSession{C: make(chan int)}, time.Hour)
+	if !errors.Is(err, store.ErrInvalidSessionData) {
+		t.Errorf("Set() returned unexpected error for unmarshallable session - got: %v, want: %v", err, store.ErrInvalidSessionData)
+	}
+}
+
+func TestMemoryStoreUserSessions(t *testing.T) {
+	now := time.Now()
+	ms := memory.NewWithOptions(&memory.Options[fakeSession]{
+		// Index sessions by the leading character of their SID.
+		UserID: func(s *fakeSession) string { return s.SID[:1] },
+	})
+	ms.Clock = func() time.Time { return now }
+	for sid, ttl := range map[string]time.Duration{
+		"a1": 2 * time.Hour,
+		"a2": time.Hour,
+		"a3": 3 * time.Hour,
+		"b1": time.Hour,
+	} {
+		if err := ms.Set(context.Background(), sid, &fakeSession{SID: sid}, ttl); err != nil {
+			t.Fatalf("Set(%q) returned unexpected error: %v", sid, err)
+		}
+	}
+	if err := ms.Del(context.Background(), "a3"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	sids, err := ms.UserSessions(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a2", "a1"}, sids); diff != "" {
+		t.Errorf("UserSessions() returned unexpected SIDs (+got, -want):\n%s", diff)
+	}
+
+	// Verify that expired sessions are dropped from the index.
+	ms.Clock = func() time.Time { return now.Add(90 * time.Minute) }
+	sids, err = ms.UserSessions(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a1"}, sids); diff != "" {
+		t.Errorf("UserSessions() returned unexpected SIDs after expiration (+got, -want):\n%s", diff)
+	}
+	sids, err = ms.UserSessions(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if len(sids) != 0 {
+		t.Errorf("UserSessions() returned unexpected SIDs after expiration - got: %v, want none", sids)
+	}
+}
+
+func TestMemoryStoreSetLimited(t *testing.T) {
 	testCases := []struct {
-		name    string
-		arrange func(t *testing.T, s *memory.Store[fakeSession])
-		set     func(s *memory.Store[fakeSession]) error
-		assert  func(t *testing.T, s *memory.Store[fakeSession])
-		err     error
+		name        string
+		evict       bool
+		err         error
+		wantEvicted []string
+		wantUser    []string
 	}{
 		{
-			name: "succeeds",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-			},
-			set: func(s *memory.Store[fakeSession]) error {
-				return s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour)
-			},
-			assert: func(t *testing.T, s *memory.Store[fakeSession]) {
-				val, err := s.Get(context.Background(), fakeSessionID)
-				if err != nil {
-					t.Errorf("Get() returned unexpected error during verification: %v", err)
-				} else if diff := cmp.Diff(fakeSessionValue(), val); diff != "" {
-					t.Errorf("Get() returned unexpected value during verification (+got, -want):\n%s", diff)
-				}
-			},
-		},
-		{
-			name: "exists",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
-			},
-			set: func(s *memory.Store[fakeSession]) error {
-				return s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour)
-			},
-			err: store.ErrSessionExists,
+			name:     "reject",
+			err:      store.ErrUserSessionLimit,
+			wantUser: []string{"a2", "a1"},
 		},
 		{
-			name: "succeeds evicted",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				now := time.Now()
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
-				s.Clock = func() time.Time { return now.Add(90 * time.Minute) }
-			},
-			set: func(s *memory.Store[fakeSession]) error {
-				return s.Set(context.Background(), fakeSessionID, fakeSessionValueNew(), time.Hour)
-			},
-			assert: func(t *testing.T, s *memory.Store[fakeSession]) {
-				val, err := s.Get(context.Background(), fakeSessionID)
-				if err != nil {
-					t.Errorf("Get() returned unexpected error during verification: %v", err)
-				} else if diff := cmp.Diff(fakeSessionValueNew(), val); diff != "" {
-					t.Errorf("Get() returned unexpected value during verification (+got, -want):\n%s", diff)
-				}
-			},
+			name:        "evict",
+			evict:       true,
+			wantEvicted: []string{"a2"},
+			wantUser:    []string{"a1", "a3"},
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			ms := memory.New[fakeSession]()
-			tc.arrange(t, ms)
-			err := tc.set(ms)
-			if gotErr, wantErr := err != nil, tc.err != nil; gotErr != wantErr {
-				t.Fatalf("Set() returned unexpected error - got error: %t, want error: %t", gotErr, wantErr)
-			}
-			if tc.err != nil {
-				if !errors.Is(err, tc.err) {
-					t.Fatalf("Set() returned unexpected error type - got: %v, want: %v", err, tc.err)
+			ms := memory.NewWithOptions(&memory.Options[fakeSession]{
+				UserID: func(s *fakeSession) string { return s.SID[:1] },
+			})
+			for sid, ttl := range map[string]time.Duration{
+				"a1": 2 * time.Hour,
+				"a2": time.Hour,
+				"b1": time.Hour,
+			} {
+				if _, err := ms.SetLimited(context.Background(), sid, &fakeSession{SID: sid}, ttl, 2, tc.evict); err != nil {
+					t.Fatalf("SetLimited(%q) returned unexpected error: %v", sid, err)
 				}
-				return
 			}
-			tc.assert(t, ms)
+			evicted, err := ms.SetLimited(context.Background(), "a3", &fakeSession{SID: "a3"}, 3*time.Hour, 2, tc.evict)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("SetLimited() returned unexpected error - got: %v, want: %v", err, tc.err)
+			}
+			if diff := cmp.Diff(tc.wantEvicted, evicted); diff != "" {
+				t.Errorf("SetLimited() returned unexpected evicted SIDs (+got, -want):\n%s", diff)
+			}
+			sids, err := ms.UserSessions(context.Background(), "a")
+			if err != nil {
+				t.Fatalf("UserSessions() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantUser, sids); diff != "" {
+				t.Errorf("UserSessions() returned unexpected SIDs (+got, -want):\n%s", diff)
+			}
 		})
 	}
 }
 
-func TestMemoryStoreDel(t *testing.T) {
-	testCases := []struct {
-		name    string
-		arrange func(t *testing.T, s *memory.Store[fakeSession])
-		del     func(s *memory.Store[fakeSession]) error
-		err     error
+func TestMemoryStoreUpdate(t *testing.T) {
+	now := time.Now()
+	ms := memory.New[fakeSession]()
+	ms.Clock = func() time.Time { return now }
+	if err := ms.Update(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Update() returned unexpected error for missing session - got: %v, want: %v", err, store.ErrSessionNotFound)
+	}
+	if err := ms.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
+		t.Fatalf("Unexpected error initializing memory store: %v", err)
+	}
+	if err := ms.Update(context.Background(), fakeSessionID, fakeSessionValueNew(), 2*time.Hour); err != nil {
+		t.Fatalf("Update() returned unexpected error: %v", err)
+	}
+	// Verify that both the value and expiration were updated.
+	ms.Clock = func() time.Time { return now.Add(90 * time.Minute) }
+	val, err := ms.Get(context.Background(), fakeSessionID)
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(fakeSessionValueNew(), val); diff != "" {
+		t.Errorf("Get() returned unexpected value after Update() (+got, -want):\n%s", diff)
+	}
+}
+
+// failingSession fails to marshal if Fail is true.
+type failingSession struct {
+	Fail bool
+}
+
+func (s *failingSession) MarshalJSON() ([]byte, error) {
+	if s.Fail {
+		return nil, errors.New("marshal failed")
+	}
+	return []byte(`{}`), nil
+}
+
+func TestMemoryStoreUpdateInvalidData(t *testing.T) {
+	ms := memory.NewSerializing[failingSession]()
+	if err := ms.Set(context.Background(), fakeSessionID, &failingSession{}, time.Hour); err != nil {
+		t.Fatalf("Unexpected error initializing memory store: %v", err)
+	}
+	err := ms.Update(context.Background(), fakeSessionID, &failingSession{Fail: true}, time.Hour)
+	if !errors.Is(err, store.ErrInvalidSessionData) {
+		t.Errorf("Update() returned unexpected error for unmarshallable session - got: %v, want: %v", err, store.ErrInvalidSessionData)
+	}
+	// Verify that the existing session was retained.
+	if _, err := ms.Get(context.Background(), fakeSessionID); err != nil {
+		t.Errorf("Get() returned unexpected error after failed Update(): %v", err)
+	}
+}
+
+func TestMemoryStoreSetLimitedInvalidData(t *testing.T) {
+	ms := memory.NewWithOptions(&memory.Options[failingSession]{
+		Codec:  memory.JSONCodec{},
+		UserID: func(*failingSession) string { return "u" },
+	})
+	if err := ms.Set(context.Background(), fakeSessionID, &failingSession{}, time.Hour); err != nil {
+		t.Fatalf("Unexpected error initializing memory store: %v", err)
+	}
+	_, err := ms.SetLimited(context.Background(), "beep", &failingSession{Fail: true}, time.Hour, 1, true)
+	if !errors.Is(err, store.ErrInvalidSessionData) {
+		t.Errorf("SetLimited() returned unexpected error for unmarshallable session - got: %v, want: %v", err, store.ErrInvalidSessionData)
+	}
+	// Verify that the existing session was not evicted.
+	if _, err := ms.Get(context.Background(), fakeSessionID); err != nil {
+		t.Errorf("Get() returned unexpected error after failed SetLimited(): %v", err)
+	}
+}
+
+func TestMemoryStoreScan(t *testing.T) {
+	now := time.Now()
+	ms := memory.NewSerializing[fakeSession]()
+	ms.Clock = func() time.Time { return now }
+	for _, sid := range []string{"d", "a", "c", "b"} {
+		if err := ms.Set(context.Background(), sid, &fakeSession{SID: sid}, time.Hour); err != nil {
+			t.Fatalf("Unexpected error initializing memory store: %v", err)
+		}
+	}
+	if err := ms.Set(context.Background(), "e", &fakeSession{SID: "e"}, time.Minute); err != nil {
+		t.Fatalf("Unexpected error initializing memory store: %v", err)
+	}
+	ms.Clock = func() time.Time { return now.Add(30 * time.Minute) }
+	var got []store.Entry[fakeSession]
+	var cursor string
+	for {
+		entries, next, err := ms.Scan(context.Background(), cursor, 3)
+		if err != nil {
+			t.Fatalf("Scan() returned unexpected error: %v", err)
+		}
+		got = append(got, entries...)
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	var want []store.Entry[fakeSession]
+	for _, sid := range []string{"a", "b", "c", "d"} {
+		want = append(want, store.Entry[fakeSession]{SID: sid, Session: &fakeSession{SID: sid}, TTL: 30 * time.Minute})
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Scan() returned unexpected entries (+got, -want):\n%s", diff)
+	}
+}
+
+func TestMemoryStoreScanModified(t *testing.T) {
+	ctx := context.Background()
+	ms := memory.New[fakeSession]()
+	for _, sid := range []string{"a", "b", "c", "d", "e"} {
+		if err := ms.Set(ctx, sid, &fakeSession{SID: sid}, time.Hour); err != nil {
+			t.Fatalf("Unexpected error initializing memory store: %v", err)
+		}
+	}
+	var got []string
+	entries, cursor, err := ms.Scan(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("Scan() returned unexpected error: %v", err)
+	}
+	for _, e := range entries {
+		got = append(got, e.SID)
+	}
+	// Sessions deleted during the scan are not returned, and an interleaved
+	// scan does not disrupt this one.
+	if err := ms.Del(ctx, "c"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	if _, _, err := ms.Scan(ctx, "", 0); err != nil {
+		t.Fatalf("Scan() returned unexpected error: %v", err)
+	}
+	for cursor != "" {
+		entries, cursor, err = ms.Scan(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("Scan() returned unexpected error: %v", err)
+		}
+		for _, e := range entries {
+			got = append(got, e.SID)
+		}
+	}
+	if diff := cmp.Diff([]string{"a", "b", "d", "e"}, got); diff != "" {
+		t.Errorf("Scan() returned unexpected SIDs (-want +got):\n%s", diff)
+	}
+}
+
+func TestMemoryStoreScanInterleaved(t *testing.T) {
+	ctx := context.Background()
+	ms := memory.New[fakeSession]()
+	want := []string{"a", "b", "c", "d", "e", "f", "g"}
+	for _, sid := range want {
+		if err := ms.Set(ctx, sid, &fakeSession{SID: sid}, time.Hour); err != nil {
+			t.Fatalf("Unexpected error initializing memory store: %v", err)
+		}
+	}
+	// Scans with different batch sizes, advanced in lockstep, do not disrupt
+	// one another.
+	scans := []struct {
+		count  int
+		cursor string
+		done   bool
+		got    []string
+	}{{count: 2}, {count: 3}}
+	for remaining := len(scans); remaining > 0; {
+		for i := range scans {
+			sc := &scans[i]
+			if sc.done {
+				continue
+			}
+			entries, next, err := ms.Scan(ctx, sc.cursor, sc.count)
+			if err != nil {
+				t.Fatalf("Scan() returned unexpected error: %v", err)
+			}
+			for _, e := range entries {
+				sc.got = append(sc.got, e.SID)
+			}
+			if sc.cursor = next; next == "" {
+				sc.done = true
+				remaining--
+			}
+		}
+	}
+	for _, sc := range scans {
+		if diff := cmp.Diff(want, sc.got); diff != "" {
+			t.Errorf("Scan() with count %d returned unexpected SIDs (-want +got):\n%s", sc.count, diff)
+		}
+	}
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		codec memory.Codec
 	}{
-		{
-			name: "found",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
-			},
-			del: func(s *memory.Store[fakeSession]) error {
-				return s.Del(context.Background(), fakeSessionID)
-			},
-		},
-		{
-			name: "not found",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
-				}
-			},
-			del: func(s *memory.Store[fakeSession]) error {
-				return s.Del(context.Background(), "beep")
-			},
-			err: store.ErrSessionNotFound,
-		},
-		{
-			name: "not found evicted",
-			arrange: func(t *testing.T, s *memory.Store[fakeSession]) {
+		{name: "pointers"},
+		{name: "serializing", codec: memory.JSONCodec{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			storetest.RunConformance(t, func(t *testing.T) (store.SessionStore[storetest.Session], func(time.Duration)) {
+				ms := memory.NewWithOptions(&memory.Options[storetest.Session]{
+					Codec:  tc.codec,
+					UserID: func(s *storetest.Session) string { return s.UID },
+				})
 				now := time.Now()
-				if err := s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
-					t.Fatalf("Unexpected error initializing memory store: %v", err)
+				var mu sync.Mutex
+				ms.Clock = func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
 				}
-				s.Clock = func() time.Time { return now.Add(90 * time.Minute) }
-			},
-			del: func(s *memory.Store[fakeSession]) error {
-				return s.Del(context.Background(), fakeSessionID)
-			},
-			err: store.ErrSessionNotFound,
-		},
-	}
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			ms := memory.New[fakeSession]()
-			tc.arrange(t, ms)
-			err := tc.del(ms)
-			if gotErr, wantErr := err != nil, tc.err != nil; gotErr != wantErr {
-				t.Fatalf("Del() returned unexpected error - got error: %t, want error: %t", gotErr, wantErr)
-			}
-			if tc.err != nil {
-				if !errors.Is(err, tc.err) {
-					t.Errorf("Del() returned unexpected error type - got: %v, want: %v", err, tc.err)
+				return ms, func(d time.Duration) {
+					mu.Lock()
+					defer mu.Unlock()
+					now = now.Add(d)
 				}
-			}
+			})
 		})
 	}
 }
+
+func TestMemoryStoreSetLimitedNoUserID(t *testing.T) {
+	ms := memory.New[fakeSession]()
+	if ms.UserIndexEnabled() {
+		t.Error("UserIndexEnabled() unexpectedly returned true without Options.UserID")
+	}
+	_, err := ms.SetLimited(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour, 2, false)
+	if err == nil {
+		t.Fatal("SetLimited() unexpectedly succeeded without Options.UserID")
+	}
+	if store.IsRetryable(err) {
+		t.Errorf("SetLimited() returned unexpectedly retryable error: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+// snapshotEntry is the serialized form of a single session in a snapshot.
+// Snapshots consist of a sequence of newline-delimited JSON snapshotEntry
+// objects.
+type snapshotEntry struct {
+	SID     string    `json:"sid"`
+	Expires time.Time `json:"expires"`
+	// Data is the session encoded by the Store Codec, or JSONCodec if none is
+	// configured.
+	Data []byte `json:"data"`
+}
+
+func (ms *Store[S]) codec() Codec {
+	if ms.opts.Codec != nil {
+		return ms.opts.Codec
+	}
+	return JSONCodec{}
+}
+
+// Snapshot writes all unexpired sessions, together with their absolute
+// expiration times, to the provided Writer. The result may later be loaded via
+// Restore (e.g., to preserve sessions across a graceful restart).
+func (ms *Store[S]) Snapshot(w io.Writer) error {
+	var entries []snapshotEntry
+	err := func() error {
+		ms.mu.Lock()
+		defer ms.mu.Unlock()
+		ms.evict(ms.Clock())
+		for sid, e := range ms.items {
+			data := e.data
+			if ms.opts.Codec == nil {
+				var err error
+				if data, err = (JSONCodec{}).Marshal(e.val); err != nil {
+					return fmt.Errorf("failed to marshal session data: %w", err)
+				}
+			}
+			entries = append(entries, snapshotEntry{SID: sid, Expires: e.expires, Data: data})
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return fmt.Errorf("failed to write snapshot entry: %w", err)
+		}
+	}
+	return bw.Flush()
+}
+
+// Restore loads sessions from a snapshot previously produced by Snapshot.
+// Sessions that have expired in the meantime are skipped, as are sessions whose
+// SID is already present in the Store. Sessions are otherwise subject to the
+// configured capacity bounds, as with Set.
+//
+// Entries that cannot be decoded (e.g., due to a change in the session type)
+// are also skipped, such that the remainder of the snapshot is still loaded. If
+// any are encountered, the returned error reports their number and wraps
+// store.ErrInvalidStoredSessionData.
+func (ms *Store[S]) Restore(r io.Reader) error {
+	codec := ms.codec()
+	br := bufio.NewReader(r)
+	var skipped int
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read snapshot entry: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var se snapshotEntry
+			s := new(S)
+			if json.Unmarshal(line, &se) != nil || codec.Unmarshal(se.Data, s) != nil {
+				skipped++
+			} else if err := ms.restoreOne(se.SID, s, se.Expires); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	if skipped > 0 {
+		return fmt.Errorf("skipped %d undecodable snapshot entries: %w", skipped, store.ErrInvalidStoredSessionData)
+	}
+	return nil
+}
+
+func (ms *Store[S]) restoreOne(sid string, s *S, expires time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t := ms.Clock()
+	ms.evict(t)
+	if _, ok := ms.items[sid]; ok || expires.Before(t) {
+		return nil
+	}
+	return ms.insert(sid, s, expires)
+}
+
+// SnapshotToFile writes a snapshot (see Snapshot) to the file at the provided
+// path. The snapshot is first written to a temporary file in the same
+// directory, which then replaces the destination, such that a partially written
+// snapshot is never observed.
+func (ms *Store[S]) SnapshotToFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary snapshot file: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if err := ms.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	// Ensure the snapshot is durable before it replaces the destination, such
+	// that a crash cannot leave an empty or truncated file in its place.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync temporary snapshot file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary snapshot file: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace snapshot file: %w", err)
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir makes a best-effort attempt to sync the directory at the provided
+// path, such that a preceding rename within it is durable. Errors are ignored,
+// as not all platforms support syncing directories.
+func syncDir(path string) {
+	d, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// RestoreFromFile loads sessions (see Restore) from the snapshot file at the
+// provided path. If the file does not exist, the returned error will satisfy
+// errors.Is(err, fs.ErrNotExist).
+func (ms *Store[S]) RestoreFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer f.Close()
+	return ms.Restore(f)
+}
+
+// StartSnapshots starts a background goroutine that writes a snapshot to the
+// file at the provided path (see SnapshotToFile) at the provided interval.
+// Errors encountered while doing so are passed to onError, if non-nil. Close
+// must be called to stop the goroutine once the Store is no longer needed,
+// which will also write a final snapshot. StartSnapshots must be called at
+// most once.
+func (ms *Store[S]) StartSnapshots(path string, interval time.Duration, onError func(error)) {
+	ms.snapshotPath = path
+	ms.every(interval, func() {
+		if err := ms.SnapshotToFile(path); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
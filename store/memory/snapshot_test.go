@@ -0,0 +1,156 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/memory"
+)
+
+func TestMemoryStoreSnapshotRestore(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts func() *memory.Options[fakeSession]
+	}{
+		{
+			name: "pointers",
+			opts: func() *memory.Options[fakeSession] { return &memory.Options[fakeSession]{} },
+		},
+		{
+			name: "serializing",
+			opts: func() *memory.Options[fakeSession] {
+				return &memory.Options[fakeSession]{Codec: memory.JSONCodec{}}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Now()
+			src := memory.NewWithOptions(tc.opts())
+			src.Clock = func() time.Time { return now }
+			for sid, ttl := range map[string]time.Duration{
+				"short": time.Minute,
+				"long":  time.Hour,
+			} {
+				if err := src.Set(context.Background(), sid, &fakeSession{SID: sid}, ttl); err != nil {
+					t.Fatalf("Set(%q) returned unexpected error: %v", sid, err)
+				}
+			}
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("Snapshot() returned unexpected error: %v", err)
+			}
+
+			// Restore at a time after which "short" has expired.
+			dst := memory.NewWithOptions(tc.opts())
+			dst.Clock = func() time.Time { return now.Add(30 * time.Minute) }
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("Restore() returned unexpected error: %v", err)
+			}
+			if got, want := dst.Len(), 1; got != want {
+				t.Errorf("Len() after Restore() = %d, want %d", got, want)
+			}
+			got, err := dst.Get(context.Background(), "long")
+			if err != nil {
+				t.Fatalf("Get() returned unexpected error for restored session: %v", err)
+			}
+			if diff := cmp.Diff(&fakeSession{SID: "long"}, got); diff != "" {
+				t.Errorf("Get() returned unexpected value for restored session (+got, -want):\n%s", diff)
+			}
+			if _, err := dst.Get(context.Background(), "short"); !errors.Is(err, store.ErrSessionNotFound) {
+				t.Errorf("Get() returned unexpected error for expired session - got: %v, want: %v", err, store.ErrSessionNotFound)
+			}
+
+			// Verify that the absolute expiration time was preserved.
+			dst.Clock = func() time.Time { return now.Add(61 * time.Minute) }
+			if _, err := dst.Get(context.Background(), "long"); !errors.Is(err, store.ErrSessionNotFound) {
+				t.Errorf("Get() returned unexpected error for restored session past expiration - got: %v, want: %v", err, store.ErrSessionNotFound)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRestoreMalformed(t *testing.T) {
+	ms := memory.New[fakeSession]()
+	if err := ms.Restore(bytes.NewBufferString("invalid")); !errors.Is(err, store.ErrInvalidStoredSessionData) {
+		t.Errorf("Restore() returned unexpected error on malformed snapshot - got: %v, want: %v", err, store.ErrInvalidStoredSessionData)
+	}
+}
+
+func TestMemoryStoreRestoreSkipsUndecodable(t *testing.T) {
+	src := memory.New[fakeSession]()
+	for _, sid := range []string{"foo", "bar"} {
+		if err := src.Set(context.Background(), sid, &fakeSession{SID: sid}, time.Hour); err != nil {
+			t.Fatalf("Set(%q) returned unexpected error: %v", sid, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot() returned unexpected error: %v", err)
+	}
+	// Interleave a malformed entry and an entry whose session data cannot be
+	// decoded with the valid entries.
+	expires := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	lines := strings.SplitAfter(buf.String(), "\n")
+	snapshot := lines[0] + "invalid\n" + lines[1] + `{"sid":"baz","expires":"` + expires + `","data":"bm9wZQ=="}` + "\n"
+
+	dst := memory.New[fakeSession]()
+	err := dst.Restore(strings.NewReader(snapshot))
+	if !errors.Is(err, store.ErrInvalidStoredSessionData) {
+		t.Errorf("Restore() returned unexpected error - got: %v, want: %v", err, store.ErrInvalidStoredSessionData)
+	}
+	if err == nil || !strings.Contains(err.Error(), "skipped 2 ") {
+		t.Errorf("Restore() returned error not reporting skipped entries - got: %v", err)
+	}
+	for _, sid := range []string{"foo", "bar"} {
+		got, err := dst.Get(context.Background(), sid)
+		if err != nil {
+			t.Fatalf("Get(%q) returned unexpected error for restored session: %v", sid, err)
+		}
+		if diff := cmp.Diff(&fakeSession{SID: sid}, got); diff != "" {
+			t.Errorf("Get(%q) returned unexpected value for restored session (+got, -want):\n%s", sid, diff)
+		}
+	}
+	if got, want := dst.Len(), 2; got != want {
+		t.Errorf("Len() after Restore() = %d, want %d", got, want)
+	}
+}
+
+func TestMemoryStoreSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.snapshot")
+
+	ms := memory.New[fakeSession]()
+	if err := ms.RestoreFromFile(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("RestoreFromFile() returned unexpected error for missing file - got: %v, want: %v", err, fs.ErrNotExist)
+	}
+	ms.StartSnapshots(path, time.Hour, func(err error) {
+		t.Errorf("Unexpected periodic snapshot error: %v", err)
+	})
+	if err := ms.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	// Close writes a final snapshot.
+	if err := ms.Close(); err != nil {
+		t.Fatalf("Close() returned unexpected error: %v", err)
+	}
+
+	restored := memory.New[fakeSession]()
+	if err := restored.RestoreFromFile(path); err != nil {
+		t.Fatalf("RestoreFromFile() returned unexpected error: %v", err)
+	}
+	got, err := restored.Get(context.Background(), fakeSessionID)
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error for restored session: %v", err)
+	}
+	if diff := cmp.Diff(fakeSessionValue(), got); diff != "" {
+		t.Errorf("Get() returned unexpected value for restored session (+got, -want):\n%s", diff)
+	}
+}
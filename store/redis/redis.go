@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	goredis "github.com/redis/go-redis/v9"
@@ -14,25 +16,199 @@ import (
 
 // ErrRedisClient indicates that an unexpected error was returned by the Redis
 // client.
-var ErrRedisClient = errors.New("redis client error")
+var ErrRedisClient error = redisClientError{}
+
+// redisClientError is the type of ErrRedisClient, which reports its class to
+// metrics.ErrorClass (see metrics.Classifier).
+type redisClientError struct{}
+
+func (redisClientError) Error() string      { return "redis client error" }
+func (redisClientError) ErrorClass() string { return "redis_client" }
+
+// transientReplyPrefixes are prefixes of Redis error replies indicating
+// conditions expected to resolve on their own (e.g., during failover).
+var transientReplyPrefixes = []string{"LOADING ", "READONLY ", "MASTERDOWN ", "TRYAGAIN ", "CLUSTERDOWN "}
+
+// clientError wraps the provided unexpected error returned by the Redis client
+// as an ErrRedisClient, marking it as retryable or permanent (see
+// store.IsRetryable). Error replies from Redis are permanent unless they
+// indicate a transient condition, while all other errors (e.g., network
+// errors or connection pool timeouts) are retryable, unless caused by
+// Context cancellation or deadline expiration.
+func clientError(err error) error {
+	werr := fmt.Errorf("unexpected error returned by Redis (error: %v): %w", err, ErrRedisClient)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return store.MarkPermanent(werr)
+	}
+	var rerr goredis.Error
+	if errors.As(err, &rerr) {
+		for _, prefix := range transientReplyPrefixes {
+			if strings.HasPrefix(rerr.Error(), prefix) {
+				return store.MarkRetryable(werr)
+			}
+		}
+		return store.MarkPermanent(werr)
+	}
+	return store.MarkRetryable(werr)
+}
+
+// Options represents tunable knobs that control the behavior of Store.
+type Options[S any] struct {
+	// UserID is a user-supplied function returning the identifier of the user
+	// associated with the provided session, used to maintain an index of
+	// sessions by user (see UserSessions). Sessions for which UserID returns
+	// the empty string are not indexed. When set, sessions must be stored with
+	// a positive TTL.
+	// The index for each user is stored as a sorted set of SIDs (scored by
+	// expiration time) under a key of the form "<prefix>-users:<user ID>",
+	// which itself expires along with the last session it contains.
+	// Default if unspecified: nil, in which case no index is maintained.
+	UserID func(*S) string
+}
 
 // Store is a Redis-based store for session data of type S, implementing the
 // store.SessionStore interface. S must be marshallable to JSON.
 type Store[S any] struct {
 	rc     *goredis.Client
 	prefix string
+	opts   *Options[S]
 }
 
 // New returns a new Store using the provided Redis client. Keys will be stored
 // with the provided prefix.
 func New[S any](rc *goredis.Client, prefix string) *Store[S] {
-	return &Store[S]{rc: rc, prefix: prefix}
+	return NewWithOptions[S](rc, prefix, &Options[S]{})
+}
+
+// NewWithOptions returns a new Store using the provided Redis client and
+// respecting the provided options. Keys will be stored with the provided
+// prefix.
+func NewWithOptions[S any](rc *goredis.Client, prefix string, opts *Options[S]) *Store[S] {
+	return &Store[S]{rc: rc, prefix: prefix, opts: opts}
 }
 
 func (rs *Store[S]) sessionKey(sid string) string {
 	return fmt.Sprintf("%s:%s", rs.prefix, sid)
 }
 
+func (rs *Store[S]) userKey(uid string) string {
+	return fmt.Sprintf("%s-users:%s", rs.prefix, uid)
+}
+
+func (rs *Store[S]) userID(s *S) string {
+	if rs.opts.UserID == nil {
+		return ""
+	}
+	return rs.opts.UserID(s)
+}
+
+// setIndexedScript atomically stores a session and adds it to the associated
+// user index, first dropping expired index entries and then extending the
+// index expiration to cover the longest-lived session therein. If a positive
+// limit is provided, the number of unexpired sessions in the index is first
+// checked against it, and either the new session is rejected, or the sessions
+// soonest to expire are deleted to make room.
+// Returns a table whose first element is the status (setStored, setExists, or
+// setLimited), with any subsequent elements being the SIDs of deleted sessions.
+//
+// KEYS[1]: session key
+// KEYS[2]: user index key
+// ARGV[1]: session value
+// ARGV[2]: session TTL (ms)
+// ARGV[3]: SID
+// ARGV[4]: session expiration (unix ms)
+// ARGV[5]: current time (unix ms)
+// ARGV[6]: limit (0 if unlimited)
+// ARGV[7]: "1" if sessions should be deleted to satisfy limit
+// ARGV[8]: session key prefix (i.e., "<prefix>:")
+//
+// Note: Session keys deleted to satisfy limit are not declared in KEYS, and
+// thus this script is not compatible with Redis Cluster.
+var setIndexedScript = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return {0}
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[5])
+local limit = tonumber(ARGV[6])
+local evicted = {}
+if limit > 0 then
+	local n = redis.call('ZCARD', KEYS[2])
+	if n >= limit then
+		if ARGV[7] ~= '1' then
+			return {-1}
+		end
+		evicted = redis.call('ZRANGE', KEYS[2], 0, n - limit)
+		for _, sid in ipairs(evicted) do
+			redis.call('DEL', ARGV[8] .. sid)
+			redis.call('ZREM', KEYS[2], sid)
+		end
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[3])
+local last = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[2], last[2])
+return {1, unpack(evicted)}
+`)
+
+// updateIndexedScript atomically replaces an existing session and updates its
+// score in the associated user index, with index maintenance otherwise as in
+// setIndexedScript. Returns 1 if the session was replaced, or 0 if it does not
+// exist.
+//
+// KEYS[1]: session key
+// KEYS[2]: user index key
+// ARGV[1]: session value
+// ARGV[2]: session TTL (ms)
+// ARGV[3]: SID
+// ARGV[4]: session expiration (unix ms)
+// ARGV[5]: current time (unix ms)
+var updateIndexedScript = goredis.NewScript(`
+if not redis.call('SET', KEYS[1], ARGV[1], 'XX', 'PX', ARGV[2]) then
+	return 0
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[5])
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[3])
+local last = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+redis.call('PEXPIREAT', KEYS[2], last[2])
+return 1
+`)
+
+// Status codes returned by setIndexedScript.
+const (
+	setLimited = -1
+	setExists  = 0
+	setStored  = 1
+)
+
+// setIndexed stores the provided session data using setIndexedScript,
+// returning the SIDs of any sessions deleted to satisfy limit.
+func (rs *Store[S]) setIndexed(ctx context.Context, sid, uid string, val []byte, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	now := time.Now()
+	evictArg := "0"
+	if evict {
+		evictArg = "1"
+	}
+	r, err := setIndexedScript.Run(ctx, rs.rc,
+		[]string{rs.sessionKey(sid), rs.userKey(uid)},
+		val, ttl.Milliseconds(), sid, now.Add(ttl).UnixMilli(), now.UnixMilli(),
+		limit, evictArg, rs.sessionKey("")).Slice()
+	if err != nil {
+		return nil, clientError(err)
+	}
+	switch r[0].(int64) {
+	case setExists:
+		return nil, store.ErrSessionExists
+	case setLimited:
+		return nil, store.ErrUserSessionLimit
+	}
+	var evicted []string
+	for _, e := range r[1:] {
+		evicted = append(evicted, e.(string))
+	}
+	return evicted, nil
+}
+
 // Get returns the stored session data associated with the provided SID, or
 // ErrSessionNotFound if no stored session exists.
 func (rs *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
@@ -41,7 +217,7 @@ func (rs *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
 		if err == goredis.Nil {
 			return nil, store.ErrSessionNotFound
 		}
-		return nil, fmt.Errorf("unexpected error returned by Redis (error: %v): %w", err, ErrRedisClient)
+		return nil, clientError(err)
 	}
 	s := new(S)
 	if err := json.Unmarshal([]byte(val), s); err != nil {
@@ -58,9 +234,13 @@ func (rs *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration
 	if err != nil {
 		return fmt.Errorf("failed to marshal session data (error: %v): %w", err, store.ErrInvalidSessionData)
 	}
+	if uid := rs.userID(s); uid != "" {
+		_, err := rs.setIndexed(ctx, sid, uid, val, ttl, 0, false)
+		return err
+	}
 	set, err := rs.rc.SetNX(ctx, rs.sessionKey(sid), val, ttl).Result()
 	if err != nil {
-		return fmt.Errorf("unexpected error returned by Redis (error: %v): %w", err, ErrRedisClient)
+		return clientError(err)
 	}
 	if !set {
 		return store.ErrSessionExists
@@ -68,15 +248,246 @@ func (rs *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration
 	return nil
 }
 
+// Update replaces the stored session data associated with the provided SID,
+// which will now expire after the provided TTL, returning ErrSessionNotFound if
+// no stored session exists.
+func (rs *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	val, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session data (error: %v): %w", err, store.ErrInvalidSessionData)
+	}
+	var updated bool
+	if uid := rs.userID(s); uid != "" {
+		now := time.Now()
+		updated, err = updateIndexedScript.Run(ctx, rs.rc,
+			[]string{rs.sessionKey(sid), rs.userKey(uid)},
+			val, ttl.Milliseconds(), sid, now.Add(ttl).UnixMilli(), now.UnixMilli()).Bool()
+	} else {
+		updated, err = rs.rc.SetXX(ctx, rs.sessionKey(sid), val, ttl).Result()
+	}
+	if err != nil {
+		return clientError(err)
+	}
+	if !updated {
+		return store.ErrSessionNotFound
+	}
+	return nil
+}
+
+// SetLimited behaves as Set, but additionally ensures that at most limit
+// unexpired sessions are associated with the user of the provided session
+// (see Options.UserID). If storing the session would exceed the limit, then if
+// evict is false ErrUserSessionLimit is returned, otherwise the sessions
+// soonest to expire are deleted to make room and their SIDs are returned. The
+// check and any deletions are performed atomically with storing the session.
+// Sessions not associated with any user are stored as with Set.
+func (rs *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	if rs.opts.UserID == nil {
+		return nil, errUserIDRequired
+	}
+	uid := rs.userID(s)
+	if uid == "" {
+		return nil, rs.Set(ctx, sid, s, ttl)
+	}
+	val, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session data (error: %v): %w", err, store.ErrInvalidSessionData)
+	}
+	return rs.setIndexed(ctx, sid, uid, val, ttl, limit, evict)
+}
+
+// errUserIDRequired is returned by SetLimited if Options.UserID is not
+// configured. It is marked as permanent, since retrying cannot succeed.
+var errUserIDRequired = store.MarkPermanent(errors.New("user session limits require Options.UserID"))
+
+// UserIndexEnabled implements store.UserIndexProber, reporting whether
+// Options.UserID is configured.
+func (rs *Store[S]) UserIndexEnabled() bool {
+	return rs.opts.UserID != nil
+}
+
 // Del deletes the stored session data associated with the provided SID,
 // returning ErrSessionNotFound if no stored session exists.
 func (rs *Store[S]) Del(ctx context.Context, sid string) error {
+	if rs.opts.UserID != nil {
+		return rs.delIndexed(ctx, sid)
+	}
 	r := rs.rc.Del(ctx, rs.sessionKey(sid))
 	if err := r.Err(); err != nil {
-		return fmt.Errorf("unexpected error returned by Redis (error: %v): %w", err, ErrRedisClient)
+		return clientError(err)
 	}
 	if r.Val() != 1 {
 		return store.ErrSessionNotFound
 	}
 	return nil
 }
+
+// delIndexedScript atomically deletes a session and removes it from the
+// associated user index (if any), provided that the stored session value is
+// unchanged since it was read (i.e., the user index is that of the session
+// being deleted). Returns 1 if the session was deleted, 0 if it does not
+// exist, or -1 if its value has changed.
+//
+// KEYS[1]: session key
+// KEYS[2]: user index key (optional)
+// ARGV[1]: expected session value
+// ARGV[2]: SID
+var delIndexedScript = goredis.NewScript(`
+local val = redis.call('GET', KEYS[1])
+if not val then
+	return 0
+end
+if val ~= ARGV[1] then
+	return -1
+end
+redis.call('DEL', KEYS[1])
+if #KEYS > 1 then
+	redis.call('ZREM', KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// delIndexedMaxAttempts bounds the number of attempts made by delIndexed when
+// the session is concurrently modified.
+const delIndexedMaxAttempts = 5
+
+var errConcurrentModification = errors.New("session modified concurrently during delete")
+
+// delIndexed deletes the stored session data associated with the provided SID,
+// together with its entry in the associated user index (if any), using
+// delIndexedScript.
+func (rs *Store[S]) delIndexed(ctx context.Context, sid string) error {
+	for i := 0; i < delIndexedMaxAttempts; i++ {
+		val, err := rs.rc.Get(ctx, rs.sessionKey(sid)).Result()
+		if err != nil {
+			if err == goredis.Nil {
+				return store.ErrSessionNotFound
+			}
+			return clientError(err)
+		}
+		keys := []string{rs.sessionKey(sid)}
+		// Sessions that fail to unmarshal cannot be associated with a user, but
+		// are still deleted.
+		s := new(S)
+		if err := json.Unmarshal([]byte(val), s); err == nil {
+			if uid := rs.userID(s); uid != "" {
+				keys = append(keys, rs.userKey(uid))
+			}
+		}
+		r, err := delIndexedScript.Run(ctx, rs.rc, keys, val, sid).Int()
+		if err != nil {
+			return clientError(err)
+		}
+		switch r {
+		case 1:
+			return nil
+		case 0:
+			return store.ErrSessionNotFound
+		}
+	}
+	return store.MarkRetryable(errConcurrentModification)
+}
+
+// UserSessions returns the SIDs of unexpired sessions associated with the
+// provided user identifier, ordered by increasing expiration time. The Store
+// must be configured with a UserID function (see Options), otherwise no
+// sessions are returned.
+func (rs *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	sids, err := rs.rc.ZRangeByScore(ctx, rs.userKey(uid), &goredis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return sids, nil
+}
+
+// globEscaper escapes characters with special meaning in SCAN MATCH patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// countBatchSize is the COUNT hint used by SCAN in Count.
+const countBatchSize = 1000
+
+// sessionPattern returns the SCAN MATCH pattern matching all session keys.
+func (rs *Store[S]) sessionPattern() string {
+	return globEscaper.Replace(rs.sessionKey("")) + "*"
+}
+
+// Scan implements store.Scanner using SCAN over session keys (i.e., those of
+// the form "<prefix>:<SID>"), with count passed as the COUNT hint. The cursor
+// is the decimal representation of the SCAN cursor. As with SCAN, a session
+// may be returned more than once over the course of a scan.
+func (rs *Store[S]) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[S], string, error) {
+	var c uint64
+	if cursor != "" {
+		var err error
+		if c, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("invalid scan cursor %q: %w", cursor, err)
+		}
+	}
+	keys, next, err := rs.rc.Scan(ctx, c, rs.sessionPattern(), int64(count)).Result()
+	if err != nil {
+		return nil, "", clientError(err)
+	}
+	gets := make([]*goredis.StringCmd, len(keys))
+	ttls := make([]*goredis.DurationCmd, len(keys))
+	if len(keys) > 0 {
+		_, err := rs.rc.Pipelined(ctx, func(p goredis.Pipeliner) error {
+			for i, key := range keys {
+				gets[i] = p.Get(ctx, key)
+				ttls[i] = p.PTTL(ctx, key)
+			}
+			return nil
+		})
+		if err != nil && err != goredis.Nil {
+			return nil, "", clientError(err)
+		}
+	}
+	var entries []store.Entry[S]
+	for i, key := range keys {
+		val, err := gets[i].Result()
+		if err == goredis.Nil {
+			continue // expired or deleted since SCAN
+		} else if err != nil {
+			return nil, "", clientError(err)
+		}
+		ttl := ttls[i].Val()
+		if ttl < 0 {
+			// Negative values indicate the key has since been deleted (-2), or
+			// has no expiration (-1).
+			if ttl != -1 {
+				continue
+			}
+			ttl = 0
+		}
+		s := new(S)
+		if err := json.Unmarshal([]byte(val), s); err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal session data from Redis (error: %v): %w", err, store.ErrInvalidStoredSessionData)
+		}
+		entries = append(entries, store.Entry[S]{
+			SID:     strings.TrimPrefix(key, rs.sessionKey("")),
+			Session: s,
+			TTL:     ttl,
+		})
+	}
+	if next == 0 {
+		return entries, "", nil
+	}
+	return entries, strconv.FormatUint(next, 10), nil
+}
+
+// Count implements store.Enumerator, counting session keys using SCAN. Since
+// SCAN may return a given key more than once, the count is approximate if
+// sessions are stored concurrently.
+func (rs *Store[S]) Count(ctx context.Context) (int, error) {
+	var n int
+	iter := rs.rc.Scan(ctx, 0, rs.sessionPattern(), countBatchSize).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, clientError(err)
+	}
+	return n, nil
+}
@@ -11,6 +11,7 @@ import (
 	goredis "github.com/redis/go-redis/v9"
 	"github.com/swfrench/simple-session/store"
 	"github.com/swfrench/simple-session/store/redis"
+	"github.com/swfrench/simple-session/store/storetest"
 )
 
 type fakeSession struct {
@@ -57,30 +58,6 @@ func TestStoreGet(t *testing.T) {
 		want    *fakeSession
 		err     error
 	}{
-		{
-			name: "found",
-			arrange: func(t *testing.T, rc *goredis.Client) {
-				if err := rc.Set(context.Background(), fakeSessionKey, []byte(fakeSessionData), 0).Err(); err != nil {
-					t.Fatalf("Unexpected error initializing Redis: %v", err)
-				}
-			},
-			get: func(s *redis.Store[fakeSession]) (*fakeSession, error) {
-				return s.Get(context.Background(), fakeSessionID)
-			},
-			want: fakeSessionValue(),
-		},
-		{
-			name: "not found",
-			arrange: func(t *testing.T, rc *goredis.Client) {
-				if err := rc.Set(context.Background(), fakeSessionKey, []byte(fakeSessionData), 0).Err(); err != nil {
-					t.Fatalf("Unexpected error initializing Redis: %v", err)
-				}
-			},
-			get: func(s *redis.Store[fakeSession]) (*fakeSession, error) {
-				return s.Get(context.Background(), "beep")
-			},
-			err: store.ErrSessionNotFound,
-		},
 		{
 			name: "malformed",
 			arrange: func(t *testing.T, rc *goredis.Client) {
@@ -150,18 +127,6 @@ func TestStoreSet(t *testing.T) {
 				}
 			},
 		},
-		{
-			name: "exists",
-			arrange: func(t *testing.T, rc *goredis.Client) {
-				if err := rc.Set(context.Background(), fakeSessionKey, []byte(fakeSessionData), 0).Err(); err != nil {
-					t.Fatalf("Unexpected error initializing Redis: %v", err)
-				}
-			},
-			set: func(s *redis.Store[fakeSession]) error {
-				return s.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour)
-			},
-			err: store.ErrSessionExists,
-		},
 		{
 			name: "redis error",
 			arrange: func(t *testing.T, rc *goredis.Client) {
@@ -200,29 +165,6 @@ func TestStoreDel(t *testing.T) {
 		del     func(s *redis.Store[fakeSession]) error
 		err     error
 	}{
-		{
-			name: "found",
-			arrange: func(t *testing.T, rc *goredis.Client) {
-				if err := rc.Set(context.Background(), fakeSessionKey, []byte(fakeSessionData), 0).Err(); err != nil {
-					t.Fatalf("Unexpected error initializing Redis: %v", err)
-				}
-			},
-			del: func(s *redis.Store[fakeSession]) error {
-				return s.Del(context.Background(), fakeSessionID)
-			},
-		},
-		{
-			name: "not found",
-			arrange: func(t *testing.T, rc *goredis.Client) {
-				if err := rc.Set(context.Background(), fakeSessionKey, []byte(fakeSessionData), 0).Err(); err != nil {
-					t.Fatalf("Unexpected error initializing Redis: %v", err)
-				}
-			},
-			del: func(s *redis.Store[fakeSession]) error {
-				return s.Del(context.Background(), "beep")
-			},
-			err: store.ErrSessionNotFound,
-		},
 		{
 			name: "redis error",
 			arrange: func(t *testing.T, rc *goredis.Client) {
@@ -251,3 +193,301 @@ func TestStoreDel(t *testing.T) {
 		})
 	}
 }
+
+func TestStoreUserSessions(t *testing.T) {
+	sb := mustCreateStoreBundle(t)
+	defer sb.close()
+	rs := redis.NewWithOptions(sb.rc, "session", &redis.Options[fakeSession]{
+		// Index sessions by the leading character of their SID.
+		UserID: func(s *fakeSession) string { return s.SID[:1] },
+	})
+	for sid, ttl := range map[string]time.Duration{
+		"a1": 2 * time.Hour,
+		"a2": time.Hour,
+		"a3": 3 * time.Hour,
+		"b1": time.Hour,
+	} {
+		if err := rs.Set(context.Background(), sid, &fakeSession{SID: sid}, ttl); err != nil {
+			t.Fatalf("Set(%q) returned unexpected error: %v", sid, err)
+		}
+	}
+	if err := rs.Set(context.Background(), "a1", &fakeSession{SID: "a1"}, time.Hour); !errors.Is(err, store.ErrSessionExists) {
+		t.Errorf("Set() returned unexpected error for existing session - got: %v, want: %v", err, store.ErrSessionExists)
+	}
+	if err := rs.Del(context.Background(), "a3"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	if err := rs.Del(context.Background(), "a3"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Del() returned unexpected error for deleted session - got: %v, want: %v", err, store.ErrSessionNotFound)
+	}
+	sids, err := rs.UserSessions(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a2", "a1"}, sids); diff != "" {
+		t.Errorf("UserSessions() returned unexpected SIDs (+got, -want):\n%s", diff)
+	}
+
+	// Verify that the index does not expire before its longest-lived session
+	// (note that the expiration is not shortened on Del).
+	if got, want := sb.mr.TTL("session-users:a"), 2*time.Hour; got < want {
+		t.Errorf("Unexpected TTL for user index - got: %v, want: at least %v", got, want)
+	}
+}
+
+func TestStoreDelConcurrentUserChange(t *testing.T) {
+	sb := mustCreateStoreBundle(t)
+	defer sb.close()
+	var modify func()
+	rs := redis.NewWithOptions(sb.rc, "session", &redis.Options[fakeSession]{
+		UserID: func(s *fakeSession) string {
+			if f := modify; f != nil {
+				// Simulate a concurrent Update moving the session to another user
+				// after Del has read it.
+				modify = nil
+				f()
+			}
+			return s.SID
+		},
+	})
+	if err := rs.Set(context.Background(), "k", &fakeSession{SID: "a"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	modify = func() {
+		if err := rs.Update(context.Background(), "k", &fakeSession{SID: "b"}, time.Hour); err != nil {
+			t.Fatalf("Update() returned unexpected error: %v", err)
+		}
+	}
+	if err := rs.Del(context.Background(), "k"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	sids, err := rs.UserSessions(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if len(sids) != 0 {
+		t.Errorf("UserSessions() returned stale SIDs after Del(): %v", sids)
+	}
+}
+
+func TestStoreSetLimited(t *testing.T) {
+	testCases := []struct {
+		name        string
+		evict       bool
+		err         error
+		wantEvicted []string
+		wantUser    []string
+	}{
+		{
+			name:     "reject",
+			err:      store.ErrUserSessionLimit,
+			wantUser: []string{"a2", "a1"},
+		},
+		{
+			name:        "evict",
+			evict:       true,
+			wantEvicted: []string{"a2"},
+			wantUser:    []string{"a1", "a3"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sb := mustCreateStoreBundle(t)
+			defer sb.close()
+			rs := redis.NewWithOptions(sb.rc, "session", &redis.Options[fakeSession]{
+				UserID: func(s *fakeSession) string { return s.SID[:1] },
+			})
+			for sid, ttl := range map[string]time.Duration{
+				"a1": 2 * time.Hour,
+				"a2": time.Hour,
+				"b1": time.Hour,
+			} {
+				if _, err := rs.SetLimited(context.Background(), sid, &fakeSession{SID: sid}, ttl, 2, tc.evict); err != nil {
+					t.Fatalf("SetLimited(%q) returned unexpected error: %v", sid, err)
+				}
+			}
+			evicted, err := rs.SetLimited(context.Background(), "a3", &fakeSession{SID: "a3"}, 3*time.Hour, 2, tc.evict)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("SetLimited() returned unexpected error - got: %v, want: %v", err, tc.err)
+			}
+			if diff := cmp.Diff(tc.wantEvicted, evicted); diff != "" {
+				t.Errorf("SetLimited() returned unexpected evicted SIDs (+got, -want):\n%s", diff)
+			}
+			sids, err := rs.UserSessions(context.Background(), "a")
+			if err != nil {
+				t.Fatalf("UserSessions() returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.wantUser, sids); diff != "" {
+				t.Errorf("UserSessions() returned unexpected SIDs (+got, -want):\n%s", diff)
+			}
+			for _, sid := range tc.wantEvicted {
+				if _, err := rs.Get(context.Background(), sid); !errors.Is(err, store.ErrSessionNotFound) {
+					t.Errorf("Get(%q) returned unexpected error for evicted session - got: %v, want: %v", sid, err, store.ErrSessionNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts *redis.Options[fakeSession]
+	}{
+		{
+			name: "unindexed",
+			opts: &redis.Options[fakeSession]{},
+		},
+		{
+			name: "indexed",
+			opts: &redis.Options[fakeSession]{
+				UserID: func(s *fakeSession) string { return s.SID },
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sb := mustCreateStoreBundle(t)
+			defer sb.close()
+			rs := redis.NewWithOptions(sb.rc, "session", tc.opts)
+			if err := rs.Update(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); !errors.Is(err, store.ErrSessionNotFound) {
+				t.Errorf("Update() returned unexpected error for missing session - got: %v, want: %v", err, store.ErrSessionNotFound)
+			}
+			if err := rs.Set(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour); err != nil {
+				t.Fatalf("Set() returned unexpected error: %v", err)
+			}
+			if err := rs.Update(context.Background(), fakeSessionID, fakeSessionValue(), 2*time.Hour); err != nil {
+				t.Fatalf("Update() returned unexpected error: %v", err)
+			}
+			if got, want := sb.mr.TTL(fakeSessionKey), 2*time.Hour; got != want {
+				t.Errorf("Unexpected TTL after Update() - got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClientErrorRetryable(t *testing.T) {
+	testCases := []struct {
+		name      string
+		arrange   func(sb *redisStoreBundle)
+		ctx       func() context.Context
+		retryable bool
+	}{
+		{
+			name:      "transient error reply",
+			arrange:   func(sb *redisStoreBundle) { sb.mr.SetError("LOADING Redis is loading the dataset in memory") },
+			retryable: true,
+		},
+		{
+			name: "permanent error reply",
+			arrange: func(sb *redisStoreBundle) {
+				sb.mr.SetError("WRONGTYPE Operation against a key holding the wrong kind of value")
+			},
+			retryable: false,
+		},
+		{
+			name:      "network error",
+			arrange:   func(sb *redisStoreBundle) { sb.mr.Close() },
+			retryable: true,
+		},
+		{
+			name:    "canceled",
+			arrange: func(sb *redisStoreBundle) {},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			retryable: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sb := mustCreateStoreBundle(t)
+			defer sb.close()
+			tc.arrange(sb)
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx()
+			}
+			_, err := sb.rs.Get(ctx, fakeSessionID)
+			if !errors.Is(err, redis.ErrRedisClient) {
+				t.Fatalf("Get() returned unexpected error - got: %v want: %v", err, redis.ErrRedisClient)
+			}
+			if got, want := store.IsRetryable(err), tc.retryable; got != want {
+				t.Errorf("IsRetryable(%v) - got: %v want: %v", err, got, want)
+			}
+		})
+	}
+}
+
+func TestStoreScan(t *testing.T) {
+	sb := mustCreateStoreBundle(t)
+	defer sb.close()
+	ctx := context.Background()
+	want := make(map[string]store.Entry[fakeSession])
+	for _, sid := range []string{"a", "b", "c", "d", "e"} {
+		if err := sb.rs.Set(ctx, sid, &fakeSession{SID: sid}, time.Hour); err != nil {
+			t.Fatalf("Set() returned unexpected error: %v", err)
+		}
+		want[sid] = store.Entry[fakeSession]{SID: sid, Session: &fakeSession{SID: sid}, TTL: time.Hour}
+	}
+	// Keys outside the session keyspace are not returned.
+	if err := sb.rc.Set(ctx, "session-users:alice", "x", 0).Err(); err != nil {
+		t.Fatalf("Unexpected error initializing Redis: %v", err)
+	}
+	if err := sb.rc.Set(ctx, "other:f", fakeSessionData, 0).Err(); err != nil {
+		t.Fatalf("Unexpected error initializing Redis: %v", err)
+	}
+	got := make(map[string]store.Entry[fakeSession])
+	var cursor string
+	for {
+		entries, next, err := sb.rs.Scan(ctx, cursor, 2)
+		if err != nil {
+			t.Fatalf("Scan() returned unexpected error: %v", err)
+		}
+		for _, e := range entries {
+			got[e.SID] = e
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Scan() returned unexpected entries (+got, -want):\n%s", diff)
+	}
+	if n, err := sb.rs.Count(ctx); err != nil || n != len(want) {
+		t.Errorf("Count() returned unexpected result - got: (%d, %v) want: (%d, <nil>)", n, err, len(want))
+	}
+	if _, _, err := sb.rs.Scan(ctx, "bogus", 2); err == nil {
+		t.Errorf("Scan() with invalid cursor unexpectedly succeeded")
+	}
+}
+
+func TestStoreConformance(t *testing.T) {
+	storetest.RunConformance(t, func(t *testing.T) (store.SessionStore[storetest.Session], func(time.Duration)) {
+		mr := miniredis.RunT(t)
+		rc := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rc.Close() })
+		rs := redis.NewWithOptions(rc, "session", &redis.Options[storetest.Session]{
+			UserID: func(s *storetest.Session) string { return s.UID },
+		})
+		return rs, mr.FastForward
+	})
+}
+
+func TestStoreSetLimitedNoUserID(t *testing.T) {
+	sb := mustCreateStoreBundle(t)
+	defer sb.close()
+	rs := sb.rs
+	if rs.UserIndexEnabled() {
+		t.Error("UserIndexEnabled() unexpectedly returned true without Options.UserID")
+	}
+	_, err := rs.SetLimited(context.Background(), fakeSessionID, fakeSessionValue(), time.Hour, 2, false)
+	if err == nil {
+		t.Fatal("SetLimited() unexpectedly succeeded without Options.UserID")
+	}
+	if store.IsRetryable(err) {
+		t.Errorf("SetLimited() returned unexpectedly retryable error: %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+// Package replicated provides a SessionStore wrapper that replicates sessions
+// across multiple SessionStores, e.g., while migrating from one backend to
+// another.
+package replicated
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+const defaultBackfillTTL = 30 * time.Minute
+
+var (
+	// ErrNoBackends indicates that no backends were provided to New.
+	ErrNoBackends = errors.New("no backends provided")
+	// ErrConsistency indicates that a write was not acknowledged by enough
+	// backends to satisfy the configured Consistency.
+	ErrConsistency = errors.New("replicated write consistency not met")
+)
+
+// Consistency determines how many backends must acknowledge a write for it to
+// be considered successful.
+type Consistency int
+
+const (
+	// All requires that all backends acknowledge a write.
+	All Consistency = iota
+	// First requires that at least one backend acknowledge a write.
+	First
+	// Quorum requires that a majority of backends acknowledge a write.
+	Quorum
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case All:
+		return "all"
+	case First:
+		return "first"
+	case Quorum:
+		return "quorum"
+	}
+	return "unknown"
+}
+
+// required returns the number of acknowledgements required out of n backends.
+func (c Consistency) required(n int) int {
+	switch c {
+	case First:
+		return 1
+	case Quorum:
+		return n/2 + 1
+	}
+	return n
+}
+
+// Options represents tunable knobs that control the behavior of Store.
+type Options struct {
+	// Consistency determines how many backends must acknowledge a write.
+	// Default if unspecified: All
+	Consistency Consistency
+	// Backfill enables writing sessions found on a later backend in the read
+	// order (i.e., during Get) back to the earlier backends that did not have
+	// them.
+	// Default if unspecified: false
+	Backfill bool
+	// BackfillTTL is the TTL of sessions written during backfill (since the
+	// remaining TTL of the session is unknown), as well as that of tombstones
+	// recorded on failed deletion (see Del). Consider setting this to the
+	// session TTL (e.g., session.Options.TTL).
+	// Default if unspecified: 30m
+	BackfillTTL time.Duration
+	// OnBackfillError is a user-supplied callback invoked when backfill fails
+	// for a given backend (by index), e.g., to log the error. Backfill failures
+	// do not otherwise affect Get.
+	// Default if unspecified: nil, in which case OnBackfillError is not
+	// invoked.
+	OnBackfillError func(backend int, err error)
+}
+
+// Store is a SessionStore replicating sessions across multiple backend
+// SessionStores. Writes are fanned out to all backends concurrently, and
+// succeed if acknowledged by enough backends to satisfy Consistency (except
+// for Del, which must be acknowledged by all backends). Reads
+// consult backends in order, returning the first session found.
+//
+// For example, while migrating from one Redis instance to another, a Store
+// with backends (new, old) and Backfill enabled writes sessions to both, while
+// reading from the new instance with fallback to the old.
+//
+// Store implements the optional store.UserIndex, store.UserSessionLimiter,
+// and store.Updater interfaces, which require support from all backends (or
+// the first backend, for store.UserSessionLimiter).
+type Store[S any] struct {
+	// Clock can be used to override measurement of time in tests.
+	Clock      func() time.Time
+	backends   []store.SessionStore[S]
+	opts       *Options
+	mu         sync.Mutex
+	tombstones map[string]time.Time // SID -> expiration
+}
+
+// New returns a new Store replicating sessions across the provided backends,
+// which are read in the order provided, and respecting the provided options.
+// At least one backend must be provided, otherwise ErrNoBackends is returned.
+func New[S any](backends []store.SessionStore[S], opts *Options) (*Store[S], error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackends
+	}
+	if opts.BackfillTTL == time.Duration(0) {
+		opts.BackfillTTL = defaultBackfillTTL
+	}
+	return &Store[S]{
+		Clock:      func() time.Time { return time.Now() },
+		backends:   backends,
+		opts:       opts,
+		tombstones: make(map[string]time.Time),
+	}, nil
+}
+
+// fanOut invokes fn concurrently for each of the provided backend indices,
+// returning the resulting errors by position.
+func fanOut(idxs []int, fn func(i int) error) []error {
+	errs := make([]error, len(idxs))
+	var wg sync.WaitGroup
+	for j, i := range idxs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[j] = fn(i)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func (rs *Store[S]) all() []int {
+	idxs := make([]int, len(rs.backends))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	return idxs
+}
+
+// write fans fn out to all backends, evaluating the outcome according to the
+// provided Consistency. Errors satisfying errors.Is(err, notFound) (unless notFound is
+// nil) count as acknowledgements, but if every backend returns such an error,
+// an error wrapping notFound is returned. If the write fails and undo is
+// non-nil, undo is invoked for each backend that acknowledged the write.
+func (rs *Store[S]) write(op string, c Consistency, fn func(i int) error, notFound error, undo func(i int) error) error {
+	errs := fanOut(rs.all(), fn)
+	var acked []int
+	var misses int
+	var collision error
+	var failures []error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			acked = append(acked, i)
+		case notFound != nil && errors.Is(err, notFound):
+			acked = append(acked, i)
+			misses++
+		case errors.Is(err, store.ErrSessionExists):
+			if collision == nil {
+				collision = fmt.Errorf("%s on backend %d: %w", op, i, err)
+			}
+		default:
+			failures = append(failures, fmt.Errorf("%s on backend %d: %w", op, i, err))
+		}
+	}
+	if misses == len(errs) {
+		return fmt.Errorf("%s on all backends: %w", op, notFound)
+	}
+	var err error
+	if collision != nil {
+		// Treat collisions as terminal, such that a new SID is generated.
+		err = collision
+	} else if len(acked) < c.required(len(errs)) {
+		err = fmt.Errorf("%d of %d backends acknowledged %s (consistency: %v): %w",
+			len(acked), len(errs), op, c, errors.Join(append([]error{ErrConsistency}, failures...)...))
+	}
+	if err != nil && undo != nil {
+		fanOut(acked, undo)
+	}
+	return err
+}
+
+// undoSet returns a function that deletes the provided SID from a given
+// backend, on a best-effort basis, in order to roll back a failed Set.
+func (rs *Store[S]) undoSet(ctx context.Context, sid string) func(i int) error {
+	// The write may have failed due to ctx, which should not prevent cleanup.
+	ctx = context.WithoutCancel(ctx)
+	return func(i int) error {
+		return rs.backends[i].Del(ctx, sid)
+	}
+}
+
+// Get implements store.SessionStore. If no backend has the requested session,
+// Get returns the first error other than store.ErrSessionNotFound, if any.
+func (rs *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	if rs.tombstoned(sid) {
+		return nil, store.ErrSessionNotFound
+	}
+	var firstErr error
+	var missed []int
+	for i, b := range rs.backends {
+		s, err := b.Get(ctx, sid)
+		if err == nil {
+			if rs.opts.Backfill {
+				rs.backfill(ctx, missed, sid, s)
+			}
+			return s, nil
+		}
+		if errors.Is(err, store.ErrSessionNotFound) {
+			missed = append(missed, i)
+		} else if firstErr == nil {
+			firstErr = fmt.Errorf("get on backend %d: %w", i, err)
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, store.ErrSessionNotFound
+}
+
+func (rs *Store[S]) backfill(ctx context.Context, idxs []int, sid string, s *S) {
+	errs := fanOut(idxs, func(i int) error {
+		return rs.backends[i].Set(ctx, sid, s, rs.opts.BackfillTTL)
+	})
+	for j, err := range errs {
+		if err != nil && !errors.Is(err, store.ErrSessionExists) && rs.opts.OnBackfillError != nil {
+			rs.opts.OnBackfillError(idxs[j], err)
+		}
+	}
+}
+
+// Set implements store.SessionStore. If the write fails (e.g., due to
+// Consistency not being met), the session is deleted from the backends that
+// acknowledged it, on a best-effort basis.
+func (rs *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	return rs.write("set", rs.opts.Consistency, func(i int) error {
+		return rs.backends[i].Set(ctx, sid, s, ttl)
+	}, nil, rs.undoSet(ctx, sid))
+}
+
+// Del implements store.SessionStore. Regardless of Consistency, Del must be
+// acknowledged by all backends, since a session remaining on any backend
+// would otherwise continue to be served by Get. A backend not having the
+// session counts as an acknowledgement, while store.ErrSessionNotFound is
+// returned only if no backend had the session.
+//
+// If Del fails, the SID is tombstoned for BackfillTTL, during which Get
+// returns store.ErrSessionNotFound for it, rather than serving (and
+// backfilling) the session from the backends that did not delete it. Note
+// that tombstones are local to the Store.
+func (rs *Store[S]) Del(ctx context.Context, sid string) error {
+	err := rs.write("del", All, func(i int) error {
+		return rs.backends[i].Del(ctx, sid)
+	}, store.ErrSessionNotFound, nil)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err == nil || errors.Is(err, store.ErrSessionNotFound) {
+		delete(rs.tombstones, sid)
+		return err
+	}
+	now := rs.Clock()
+	for tsid, expires := range rs.tombstones {
+		if !now.Before(expires) {
+			delete(rs.tombstones, tsid)
+		}
+	}
+	rs.tombstones[sid] = now.Add(rs.opts.BackfillTTL)
+	return err
+}
+
+// tombstoned reports whether the provided SID is tombstoned (see Del).
+func (rs *Store[S]) tombstoned(sid string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	expires, ok := rs.tombstones[sid]
+	return ok && rs.Clock().Before(expires)
+}
+
+// Update implements store.Updater. As with Del, store.ErrSessionNotFound is
+// returned only if no backend had the session.
+func (rs *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	us := make([]store.Updater[S], len(rs.backends))
+	for i, b := range rs.backends {
+		u, ok := b.(store.Updater[S])
+		if !ok {
+			return store.Unsupported("store.Updater")
+		}
+		us[i] = u
+	}
+	return rs.write("update", rs.opts.Consistency, func(i int) error {
+		return us[i].Update(ctx, sid, s, ttl)
+	}, store.ErrSessionNotFound, nil)
+}
+
+// SetLimited implements store.UserSessionLimiter. The limit is enforced by the
+// first backend, after which the session is written to, and any evicted
+// sessions deleted from, the remaining backends. The first backend must
+// succeed, while the remaining writes are evaluated according to Consistency
+// (with the first backend counting as an acknowledgement). As with Set, if the
+// write fails, the session is deleted from the backends that acknowledged it
+// (including the first), though evicted sessions are not restored.
+func (rs *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	l, ok := rs.backends[0].(store.UserSessionLimiter[S])
+	if !ok {
+		return nil, store.Unsupported("store.UserSessionLimiter")
+	}
+	evicted, err := l.SetLimited(ctx, sid, s, ttl, limit, evict)
+	if err != nil {
+		return nil, fmt.Errorf("set_limited on backend 0: %w", err)
+	}
+	err = rs.write("set_limited", rs.opts.Consistency, func(i int) error {
+		if i == 0 {
+			return nil
+		}
+		b := rs.backends[i]
+		for _, esid := range evicted {
+			if err := b.Del(ctx, esid); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+				return err
+			}
+		}
+		return b.Set(ctx, sid, s, ttl)
+	}, nil, rs.undoSet(ctx, sid))
+	return evicted, err
+}
+
+// UserIndexEnabled implements store.UserIndexProber, reporting whether all
+// backends maintain a user index (see store.UserIndexEnabled).
+func (rs *Store[S]) UserIndexEnabled() bool {
+	for _, b := range rs.backends {
+		if !store.UserIndexEnabled(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// UserSessions implements store.UserIndex, returning the union of the SIDs
+// returned by all backends. SIDs are ordered by increasing expiration time
+// within those returned by each backend, in read order, but not overall.
+func (rs *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	var sids []string
+	seen := make(map[string]bool)
+	for i, b := range rs.backends {
+		ui, ok := b.(store.UserIndex)
+		if !ok {
+			return nil, store.Unsupported("store.UserIndex")
+		}
+		bsids, err := ui.UserSessions(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("user_sessions on backend %d: %w", i, err)
+		}
+		for _, sid := range bsids {
+			if !seen[sid] {
+				seen[sid] = true
+				sids = append(sids, sid)
+			}
+		}
+	}
+	return sids, nil
+}
@@ -0,0 +1,303 @@
+package replicated_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/memory"
+	"github.com/swfrench/simple-session/store/replicated"
+)
+
+type fakeSession struct {
+	Name string
+	UID  string
+}
+
+var errOutage = errors.New("outage")
+
+// downStore wraps a memory Store, failing writes and reads with errOutage
+// while down is set.
+type downStore struct {
+	*memory.Store[fakeSession]
+	down bool
+}
+
+func (ds *downStore) Get(ctx context.Context, sid string) (*fakeSession, error) {
+	if ds.down {
+		return nil, errOutage
+	}
+	return ds.Store.Get(ctx, sid)
+}
+
+func (ds *downStore) Set(ctx context.Context, sid string, s *fakeSession, ttl time.Duration) error {
+	if ds.down {
+		return errOutage
+	}
+	return ds.Store.Set(ctx, sid, s, ttl)
+}
+
+func (ds *downStore) Del(ctx context.Context, sid string) error {
+	if ds.down {
+		return errOutage
+	}
+	return ds.Store.Del(ctx, sid)
+}
+
+func newBackends(n int) ([]*downStore, []store.SessionStore[fakeSession]) {
+	var ds []*downStore
+	var ss []store.SessionStore[fakeSession]
+	for range n {
+		d := &downStore{Store: memory.New[fakeSession]()}
+		ds = append(ds, d)
+		ss = append(ss, d)
+	}
+	return ds, ss
+}
+
+func TestNoBackends(t *testing.T) {
+	if _, err := replicated.New[fakeSession](nil, &replicated.Options{}); !errors.Is(err, replicated.ErrNoBackends) {
+		t.Errorf("New() returned unexpected error - got: %v want: %v", err, replicated.ErrNoBackends)
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	testCases := []struct {
+		name        string
+		consistency replicated.Consistency
+		down        int
+		wantErr     error
+		// Del must be acknowledged by all backends, regardless of
+		// consistency.
+		wantDelErr error
+	}{
+		{name: "all", consistency: replicated.All},
+		{name: "all with one down", consistency: replicated.All, down: 1, wantErr: replicated.ErrConsistency, wantDelErr: replicated.ErrConsistency},
+		{name: "quorum with one down", consistency: replicated.Quorum, down: 1, wantDelErr: replicated.ErrConsistency},
+		{name: "quorum with two down", consistency: replicated.Quorum, down: 2, wantErr: replicated.ErrConsistency, wantDelErr: replicated.ErrConsistency},
+		{name: "first with two down", consistency: replicated.First, down: 2, wantDelErr: replicated.ErrConsistency},
+		{name: "first with all down", consistency: replicated.First, down: 3, wantErr: replicated.ErrConsistency, wantDelErr: replicated.ErrConsistency},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ds, ss := newBackends(3)
+			rs, err := replicated.New(ss, &replicated.Options{Consistency: tc.consistency})
+			if err != nil {
+				t.Fatalf("New() returned unexpected error: %v", err)
+			}
+			for i := range tc.down {
+				ds[i].down = true
+			}
+			if err := rs.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Set() returned unexpected error - got: %v want: %v", err, tc.wantErr)
+			}
+			if err := rs.Del(ctx, "foo"); !errors.Is(err, tc.wantDelErr) {
+				t.Errorf("Del() returned unexpected error - got: %v want: %v", err, tc.wantDelErr)
+			}
+		})
+	}
+}
+
+func TestReadOrderAndBackfill(t *testing.T) {
+	ctx := context.Background()
+	ds, ss := newBackends(2)
+	rs, err := replicated.New(ss, &replicated.Options{Backfill: true})
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+
+	// Session present only on the secondary (e.g., the old backend).
+	if err := ds[1].Store.Set(ctx, "foo", &fakeSession{Name: "old"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	if got, err := rs.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "old"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if _, err := ds[0].Store.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() on primary returned unexpected error after backfill: %v", err)
+	}
+
+	// Reads prefer the primary.
+	if err := ds[0].Store.Update(ctx, "foo", &fakeSession{Name: "new"}, time.Hour); err != nil {
+		t.Fatalf("Update() returned unexpected error: %v", err)
+	}
+	if got, err := rs.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "new"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+
+	// Reads fall back to the secondary if the primary is unavailable.
+	ds[0].down = true
+	if got, err := rs.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	} else if diff := cmp.Diff(&fakeSession{Name: "old"}, got); diff != "" {
+		t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+	}
+	if _, err := rs.Get(ctx, "bar"); !errors.Is(err, errOutage) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, errOutage)
+	}
+
+	ds[0].down = false
+	if _, err := rs.Get(ctx, "bar"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	if err := rs.Del(ctx, "foo"); err != nil {
+		t.Errorf("Del() returned unexpected error: %v", err)
+	}
+	if err := rs.Del(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Del() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+}
+
+func TestPartialDel(t *testing.T) {
+	ctx := context.Background()
+	ds, ss := newBackends(2)
+	rs, err := replicated.New(ss, &replicated.Options{
+		Consistency: replicated.First,
+		Backfill:    true,
+		BackfillTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	now := time.Now()
+	rs.Clock = func() time.Time { return now }
+	if err := rs.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+
+	// Deletion fails on the secondary, which retains the session.
+	ds[1].down = true
+	if err := rs.Del(ctx, "foo"); !errors.Is(err, replicated.ErrConsistency) {
+		t.Errorf("Del() returned unexpected error - got: %v want: %v", err, replicated.ErrConsistency)
+	}
+	ds[1].down = false
+
+	// The session is neither served nor backfilled from the secondary.
+	if _, err := rs.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	if _, err := ds[0].Store.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get() on primary returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+
+	// Once the tombstone expires, the retained session is visible again,
+	// unless deletion has since succeeded.
+	rs.Clock = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := rs.Get(ctx, "foo"); err != nil {
+		t.Errorf("Get() returned unexpected error: %v", err)
+	}
+	if err := rs.Del(ctx, "foo"); err != nil {
+		t.Errorf("Del() returned unexpected error: %v", err)
+	}
+	for i, d := range ds {
+		if _, err := d.Store.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+			t.Errorf("Get() on backend %d returned unexpected error - got: %v want: %v", i, err, store.ErrSessionNotFound)
+		}
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	ctx := context.Background()
+	var ss []store.SessionStore[fakeSession]
+	var ms []*memory.Store[fakeSession]
+	for range 2 {
+		m := memory.NewWithOptions(&memory.Options[fakeSession]{
+			UserID: func(s *fakeSession) string { return s.UID },
+		})
+		ms = append(ms, m)
+		ss = append(ss, m)
+	}
+	rs, err := replicated.New(ss, &replicated.Options{})
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	if err := rs.Set(ctx, "foo", &fakeSession{UID: "alice"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	if err := ms[1].Set(ctx, "bar", &fakeSession{UID: "alice"}, time.Hour); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	got, err := rs.UserSessions(ctx, "alice")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"foo", "bar"}, got); diff != "" {
+		t.Errorf("UserSessions() returned unexpected SIDs (-want +got):\n%s", diff)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limited bool
+		down    int // index of the backend that is down, if non-zero
+		exists  int // index of the backend already storing the SID, if non-zero
+		wantErr error
+	}{
+		{name: "set consistency", down: 2, wantErr: replicated.ErrConsistency},
+		{name: "set collision", exists: 1, wantErr: store.ErrSessionExists},
+		{name: "set limited consistency", limited: true, down: 2, wantErr: replicated.ErrConsistency},
+		{name: "set limited collision", limited: true, exists: 1, wantErr: store.ErrSessionExists},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			var ds []*downStore
+			var ss []store.SessionStore[fakeSession]
+			for range 3 {
+				d := &downStore{Store: memory.NewWithOptions(&memory.Options[fakeSession]{
+					UserID: func(s *fakeSession) string { return s.UID },
+				})}
+				ds = append(ds, d)
+				ss = append(ss, d)
+			}
+			rs, err := replicated.New(ss, &replicated.Options{})
+			if err != nil {
+				t.Fatalf("New() returned unexpected error: %v", err)
+			}
+			if tc.exists != 0 {
+				if err := ds[tc.exists].Set(ctx, "foo", &fakeSession{Name: "existing"}, time.Hour); err != nil {
+					t.Fatalf("Set() returned unexpected error: %v", err)
+				}
+			}
+			if tc.down != 0 {
+				ds[tc.down].down = true
+			}
+
+			s := &fakeSession{Name: "foo", UID: "alice"}
+			if tc.limited {
+				_, err = rs.SetLimited(ctx, "foo", s, time.Hour, 1, false)
+			} else {
+				err = rs.Set(ctx, "foo", s, time.Hour)
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Set() returned unexpected error - got: %v want: %v", err, tc.wantErr)
+			}
+
+			// The session is deleted from the backends that acknowledged it,
+			// leaving only the pre-existing session, if any.
+			for i, d := range ds {
+				d.down = false
+				got, err := d.Get(ctx, "foo")
+				if i == tc.exists && tc.exists != 0 {
+					if diff := cmp.Diff(&fakeSession{Name: "existing"}, got); err != nil || diff != "" {
+						t.Errorf("Get() on backend %d returned unexpected result (-want +got):\n%s (error: %v)", i, diff, err)
+					}
+				} else if !errors.Is(err, store.ErrSessionNotFound) {
+					t.Errorf("Get() on backend %d returned unexpected error - got: %v want: %v", i, err, store.ErrSessionNotFound)
+				}
+			}
+			if got, err := ds[0].UserSessions(ctx, "alice"); err != nil || len(got) != 0 {
+				t.Errorf("UserSessions() returned unexpected result - got: (%v, %v) want: ([], <nil>)", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+// Package sharded provides a SessionStore wrapper that distributes sessions
+// across multiple SessionStores (shards) using rendezvous hashing.
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-rendezvous"
+	"github.com/swfrench/simple-session/store"
+)
+
+var (
+	// ErrNoShards indicates that no shards were provided to New.
+	ErrNoShards = errors.New("no shards provided")
+	// ErrInvalidShard indicates that a shard provided to New is invalid (e.g.,
+	// has an empty or duplicate name, or a nil SessionStore).
+	ErrInvalidShard = errors.New("invalid shard")
+)
+
+// Shard represents a named SessionStore holding a subset of sessions.
+type Shard[S any] struct {
+	// Name uniquely identifies the shard, and determines which SIDs are
+	// assigned to it. It must remain stable across restarts (and resharding),
+	// e.g., the address of the backing Redis instance.
+	Name string
+	// Store is the SessionStore backing the shard.
+	Store store.SessionStore[S]
+}
+
+// Store is a SessionStore distributing sessions across shards by SID, using
+// rendezvous (highest random weight) hashing on the shard names. As a result,
+// adding a shard remaps only those SIDs newly assigned to it, and removing a
+// shard remaps only those SIDs previously assigned to it (see Migrate).
+//
+// Store implements the optional store.Updater and store.UserIndex interfaces,
+// which require support from all shards. Store does not implement
+// store.UserSessionLimiter, since a given user's sessions may span shards.
+type Store[S any] struct {
+	shards map[string]store.SessionStore[S]
+	rdv    *rendezvous.Rendezvous
+	names  []string
+}
+
+// New returns a new Store distributing sessions across the provided shards.
+// At least one shard must be provided, otherwise ErrNoShards is returned.
+func New[S any](shards []Shard[S]) (*Store[S], error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShards
+	}
+	ss := &Store[S]{shards: make(map[string]store.SessionStore[S])}
+	for i, sh := range shards {
+		if sh.Name == "" || sh.Store == nil {
+			return nil, fmt.Errorf("shard %d has empty name or nil store: %w", i, ErrInvalidShard)
+		}
+		if _, ok := ss.shards[sh.Name]; ok {
+			return nil, fmt.Errorf("duplicate shard name %q: %w", sh.Name, ErrInvalidShard)
+		}
+		ss.shards[sh.Name] = sh.Store
+		ss.names = append(ss.names, sh.Name)
+	}
+	ss.rdv = rendezvous.New(ss.names, xxhash.Sum64String)
+	return ss, nil
+}
+
+// ShardFor returns the name of the shard to which the provided SID is
+// assigned.
+func (ss *Store[S]) ShardFor(sid string) string {
+	return ss.rdv.Lookup(sid)
+}
+
+func (ss *Store[S]) shard(sid string) store.SessionStore[S] {
+	return ss.shards[ss.ShardFor(sid)]
+}
+
+// Get implements store.SessionStore.
+func (ss *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	return ss.shard(sid).Get(ctx, sid)
+}
+
+// Set implements store.SessionStore.
+func (ss *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	return ss.shard(sid).Set(ctx, sid, s, ttl)
+}
+
+// Del implements store.SessionStore.
+func (ss *Store[S]) Del(ctx context.Context, sid string) error {
+	return ss.shard(sid).Del(ctx, sid)
+}
+
+// Update implements store.Updater.
+func (ss *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	u, ok := ss.shard(sid).(store.Updater[S])
+	if !ok {
+		return store.Unsupported("store.Updater")
+	}
+	return u.Update(ctx, sid, s, ttl)
+}
+
+// UserSessions implements store.UserIndex, returning the SIDs associated with
+// the provided user across all shards. SIDs are ordered by increasing
+// expiration time within those returned by each shard, in the order shards
+// were provided to New, but not overall.
+func (ss *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	var sids []string
+	for _, name := range ss.names {
+		ui, ok := ss.shards[name].(store.UserIndex)
+		if !ok {
+			return nil, store.Unsupported("store.UserIndex")
+		}
+		ssids, err := ui.UserSessions(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("user_sessions on shard %q: %w", name, err)
+		}
+		sids = append(sids, ssids...)
+	}
+	return sids, nil
+}
+
+// UserIndexEnabled implements store.UserIndexProber, reporting whether all
+// shards maintain a user index (see store.UserIndexEnabled).
+func (ss *Store[S]) UserIndexEnabled() bool {
+	for _, name := range ss.names {
+		if !store.UserIndexEnabled(ss.shards[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MigrateStats summarizes the outcome of Migrate.
+type MigrateStats struct {
+	// Moved is the number of sessions moved to a different shard.
+	Moved int
+	// Unchanged is the number of SIDs whose shard assignment is unchanged.
+	Unchanged int
+	// Missing is the number of SIDs not found on their previous shard (e.g.,
+	// since they have expired).
+	Missing int
+}
+
+// Migrate moves sessions with the provided SIDs from their assigned shard in
+// from to their assigned shard in to, e.g., when resharding. The two Stores
+// will typically share some shards, such that only remapped sessions are
+// moved. Moved sessions are written with the provided TTL, since their
+// remaining TTL is unknown (consider using the session TTL, e.g.,
+// session.Options.TTL), and are then deleted from their previous shard.
+//
+// The SIDs to migrate must be enumerated by the caller (e.g., using
+// UserSessions, or by scanning the backing stores). Migrate stops at the first
+// error, returning the stats accumulated until then. It is safe to retry, as
+// sessions already moved are reported as Missing.
+//
+// Sessions not yet moved are not visible via to. To continue serving them
+// while Migrate runs, consider reading via a replicated.Store with backends
+// (to, from).
+func Migrate[S any](ctx context.Context, from, to *Store[S], sids []string, ttl time.Duration) (MigrateStats, error) {
+	var stats MigrateStats
+	for _, sid := range sids {
+		if from.ShardFor(sid) == to.ShardFor(sid) {
+			stats.Unchanged++
+			continue
+		}
+		src, dst := from.shard(sid), to.shard(sid)
+		s, err := src.Get(ctx, sid)
+		if errors.Is(err, store.ErrSessionNotFound) {
+			stats.Missing++
+			continue
+		} else if err != nil {
+			return stats, fmt.Errorf("failed to read session from shard %q: %w", from.ShardFor(sid), err)
+		}
+		if err := dst.Set(ctx, sid, s, ttl); err != nil && !errors.Is(err, store.ErrSessionExists) {
+			return stats, fmt.Errorf("failed to write session to shard %q: %w", to.ShardFor(sid), err)
+		}
+		if err := src.Del(ctx, sid); err != nil && !errors.Is(err, store.ErrSessionNotFound) {
+			return stats, fmt.Errorf("failed to delete session from shard %q: %w", from.ShardFor(sid), err)
+		}
+		stats.Moved++
+	}
+	return stats, nil
+}
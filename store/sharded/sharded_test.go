@@ -0,0 +1,178 @@
+package sharded_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/memory"
+	"github.com/swfrench/simple-session/store/sharded"
+)
+
+type fakeSession struct {
+	Name string
+	UID  string
+}
+
+func newShards(names ...string) ([]sharded.Shard[fakeSession], map[string]*memory.Store[fakeSession]) {
+	var shards []sharded.Shard[fakeSession]
+	stores := make(map[string]*memory.Store[fakeSession])
+	for _, name := range names {
+		ms := memory.NewWithOptions(&memory.Options[fakeSession]{
+			UserID: func(s *fakeSession) string { return s.UID },
+		})
+		shards = append(shards, sharded.Shard[fakeSession]{Name: name, Store: ms})
+		stores[name] = ms
+	}
+	return shards, stores
+}
+
+func sids(n int) []string {
+	var sids []string
+	for i := range n {
+		sids = append(sids, fmt.Sprintf("sid-%d", i))
+	}
+	return sids
+}
+
+func TestNew(t *testing.T) {
+	shards, _ := newShards("a", "a")
+	testCases := []struct {
+		name    string
+		shards  []sharded.Shard[fakeSession]
+		wantErr error
+	}{
+		{name: "no shards", wantErr: sharded.ErrNoShards},
+		{name: "duplicate name", shards: shards, wantErr: sharded.ErrInvalidShard},
+		{name: "empty name", shards: []sharded.Shard[fakeSession]{{Store: memory.New[fakeSession]()}}, wantErr: sharded.ErrInvalidShard},
+		{name: "nil store", shards: []sharded.Shard[fakeSession]{{Name: "a"}}, wantErr: sharded.ErrInvalidShard},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := sharded.New(tc.shards); !errors.Is(err, tc.wantErr) {
+				t.Errorf("New() returned unexpected error - got: %v want: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	ctx := context.Background()
+	shards, stores := newShards("a", "b", "c")
+	ss, err := sharded.New(shards)
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	for _, sid := range sids(300) {
+		if err := ss.Set(ctx, sid, &fakeSession{Name: sid, UID: "alice"}, time.Hour); err != nil {
+			t.Fatalf("Set() returned unexpected error: %v", err)
+		}
+		if _, err := stores[ss.ShardFor(sid)].Get(ctx, sid); err != nil {
+			t.Errorf("Get() on shard %q returned unexpected error: %v", ss.ShardFor(sid), err)
+		}
+		if got, err := ss.Get(ctx, sid); err != nil {
+			t.Errorf("Get() returned unexpected error: %v", err)
+		} else if diff := cmp.Diff(&fakeSession{Name: sid, UID: "alice"}, got); diff != "" {
+			t.Errorf("Get() returned unexpected session (-want +got):\n%s", diff)
+		}
+	}
+	for name, ms := range stores {
+		// Expect roughly 100 per shard.
+		if n := ms.Len(); n < 50 || n > 150 {
+			t.Errorf("Shard %q holds unexpected number of sessions: %d", name, n)
+		}
+	}
+	got, err := ss.UserSessions(ctx, "alice")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if len(got) != 300 {
+		t.Errorf("UserSessions() returned unexpected number of SIDs - got: %d want: %d", len(got), 300)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	shards, stores := newShards("a", "b", "c")
+	from, err := sharded.New(shards)
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	extra, extraStores := newShards("d")
+	stores["d"] = extraStores["d"]
+	to, err := sharded.New(append(shards, extra...))
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+
+	all := sids(400)
+	for _, sid := range all {
+		if err := from.Set(ctx, sid, &fakeSession{Name: sid}, time.Hour); err != nil {
+			t.Fatalf("Set() returned unexpected error: %v", err)
+		}
+	}
+	var remapped int
+	for _, sid := range all {
+		if got, prev := to.ShardFor(sid), from.ShardFor(sid); got != prev {
+			remapped++
+			if got != "d" {
+				t.Errorf("SID %q remapped from shard %q to existing shard %q", sid, prev, got)
+			}
+		}
+	}
+	// Expect roughly a quarter of SIDs to be remapped.
+	if remapped < 50 || remapped > 150 {
+		t.Errorf("Unexpected number of remapped SIDs: %d", remapped)
+	}
+
+	stats, err := sharded.Migrate(ctx, from, to, all, time.Hour)
+	if err != nil {
+		t.Fatalf("Migrate() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(sharded.MigrateStats{Moved: remapped, Unchanged: len(all) - remapped}, stats); diff != "" {
+		t.Errorf("Migrate() returned unexpected stats (-want +got):\n%s", diff)
+	}
+	for _, sid := range all {
+		if _, err := to.Get(ctx, sid); err != nil {
+			t.Errorf("Get() returned unexpected error after migration: %v", err)
+		}
+	}
+	var total int
+	for _, ms := range stores {
+		total += ms.Len()
+	}
+	if total != len(all) {
+		t.Errorf("Unexpected total number of sessions after migration - got: %d want: %d", total, len(all))
+	}
+
+	// Migration is idempotent.
+	stats, err = sharded.Migrate(ctx, from, to, all, time.Hour)
+	if err != nil {
+		t.Fatalf("Migrate() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(sharded.MigrateStats{Missing: remapped, Unchanged: len(all) - remapped}, stats); diff != "" {
+		t.Errorf("Migrate() returned unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	ctx := context.Background()
+	ss, err := sharded.New([]sharded.Shard[fakeSession]{
+		{Name: "a", Store: struct {
+			store.SessionStore[fakeSession]
+		}{memory.New[fakeSession]()}},
+	})
+	if err != nil {
+		t.Fatalf("New() returned unexpected error: %v", err)
+	}
+	if err := ss.Update(ctx, "foo", &fakeSession{}, time.Hour); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Update() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+	if _, err := ss.UserSessions(ctx, "alice"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("UserSessions() returned unexpected error - got: %v want: %v", err, errors.ErrUnsupported)
+	}
+}
@@ -5,6 +5,7 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -23,12 +24,213 @@ var (
 	// storage is invalid, and cannot be used. For example, this may occur if it
 	// cannot be successfully unmarshalled.
 	ErrInvalidStoredSessionData = errors.New("invalid stored session data")
+	// ErrUserSessionLimit indicates that storing the provided session would
+	// exceed the limit on concurrent sessions for the associated user.
+	ErrUserSessionLimit = errors.New("user session limit reached")
 )
 
 // SessionStore represents an abstract Session storage object. See the redis and
 // memory subpackages for concrete implementations thereof.
+//
+// SessionStores that wrap another SessionStore (e.g., to add instrumentation)
+// may implement the optional interfaces below regardless of whether the
+// wrapped SessionStore does, in which case unsupported operations return an
+// error satisfying errors.Is(err, errors.ErrUnsupported). Such SessionStores
+// should implement Unwrapper, such that support can be determined in advance
+// via Supports.
 type SessionStore[S any] interface {
 	Get(context.Context, string) (*S, error)
 	Set(context.Context, string, *S, time.Duration) error
 	Del(context.Context, string) error
 }
+
+// Operation names, identifying SessionStore methods (including those of the
+// optional interfaces below), e.g., as used by the metrics and fault packages.
+const (
+	OpGet          = "get"
+	OpSet          = "set"
+	OpDel          = "del"
+	OpUpdate       = "update"
+	OpSetLimited   = "set_limited"
+	OpUserSessions = "user_sessions"
+	OpScan         = "scan"
+	OpCount        = "count"
+)
+
+// UserIndex is an optional interface implemented by SessionStores that maintain
+// an index of stored sessions by user identifier. See the redis and memory
+// subpackages for details on how the user identifier is associated with
+// sessions.
+type UserIndex interface {
+	// UserSessions returns the SIDs of unexpired sessions associated with the
+	// provided user identifier, ordered by increasing expiration time.
+	UserSessions(context.Context, string) ([]string, error)
+}
+
+// UserSessionLimiter is an optional interface implemented by SessionStores that
+// can atomically enforce a limit on the number of concurrent sessions
+// associated with a given user (see UserIndex).
+type UserSessionLimiter[S any] interface {
+	// SetLimited behaves as Set, but additionally ensures that at most limit
+	// unexpired sessions are associated with the user of the provided session.
+	// If storing the session would exceed the limit, then if evict is false
+	// ErrUserSessionLimit is returned, otherwise the sessions soonest to
+	// expire are deleted to make room and their SIDs are returned.
+	SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error)
+}
+
+// Updater is an optional interface implemented by SessionStores that support
+// replacing the data of an existing session.
+type Updater[S any] interface {
+	// Update replaces the stored session data associated with the provided
+	// SID, which will now expire after the provided TTL, returning
+	// ErrSessionNotFound if no stored session exists.
+	Update(ctx context.Context, sid string, s *S, ttl time.Duration) error
+}
+
+// Entry represents a stored session, as returned by Scanner.
+type Entry[S any] struct {
+	// SID is the session identifier.
+	SID string
+	// Session is the stored session data.
+	Session *S
+	// TTL is the remaining time until the session expires, or zero if the
+	// session does not expire.
+	TTL time.Duration
+}
+
+// Scanner is an optional interface implemented by SessionStores that support
+// enumerating stored sessions.
+type Scanner[S any] interface {
+	// Scan returns a batch of (approximately count) unexpired sessions,
+	// starting from the provided cursor (the empty string to start a new
+	// scan), together with the cursor from which to continue. The returned
+	// cursor is the empty string once the scan is complete. A batch may be
+	// empty even if the scan is not complete. Sessions stored or deleted
+	// during the scan may or may not be returned.
+	Scan(ctx context.Context, cursor string, count int) ([]Entry[S], string, error)
+}
+
+// Enumerator is an optional interface implemented by SessionStores that support
+// both enumerating (see Scanner) and counting stored sessions.
+type Enumerator[S any] interface {
+	Scanner[S]
+	// Count returns the number of stored sessions, without reading their
+	// data. Depending on the SessionStore, the count may be approximate
+	// (e.g., may include sessions that have expired but not yet been removed).
+	Count(ctx context.Context) (int, error)
+}
+
+// Unsupported returns an error satisfying errors.Is(err, errors.ErrUnsupported),
+// for use by SessionStores wrapping another SessionStore that does not
+// implement the named optional interface (e.g., "store.Updater").
+func Unsupported(iface string) error {
+	return fmt.Errorf("wrapped session store does not implement %s: %w", iface, errors.ErrUnsupported)
+}
+
+// Unwrapper is implemented by SessionStores wrapping another SessionStore
+// (e.g., to add instrumentation), returning the wrapped SessionStore.
+type Unwrapper[S any] interface {
+	Unwrap() SessionStore[S]
+}
+
+// Supports reports whether the provided SessionStore implements the optional
+// interface T (e.g., Updater[S]), and if it wraps another SessionStore (see
+// Unwrapper), whether the latter does as well, recursively.
+func Supports[T any, S any](s SessionStore[S]) bool {
+	for {
+		if _, ok := s.(T); !ok {
+			return false
+		}
+		u, ok := s.(Unwrapper[S])
+		if !ok {
+			return true
+		}
+		s = u.Unwrap()
+	}
+}
+
+// UserIndexProber is an optional interface implemented by SessionStores that
+// implement UserIndex (and possibly UserSessionLimiter), but for which the
+// user index is only maintained in certain configurations (e.g., the memory
+// and redis SessionStores require Options.UserID).
+type UserIndexProber interface {
+	// UserIndexEnabled reports whether the user index is maintained, and thus
+	// whether UserIndex and UserSessionLimiter are usable.
+	UserIndexEnabled() bool
+}
+
+// UserIndexEnabled reports whether the provided SessionStore, and if it wraps
+// another SessionStore (see Unwrapper), the latter, recursively, maintain a
+// user index (see UserIndexProber). SessionStores not implementing
+// UserIndexProber are assumed to do so if they implement UserIndex. Consider
+// using this together with Supports.
+func UserIndexEnabled[S any](s SessionStore[S]) bool {
+	for {
+		if p, ok := s.(UserIndexProber); ok && !p.UserIndexEnabled() {
+			return false
+		}
+		u, ok := s.(Unwrapper[S])
+		if !ok {
+			return true
+		}
+		s = u.Unwrap()
+	}
+}
+
+// classifiedError marks the wrapped error as retryable or permanent.
+type classifiedError struct {
+	err       error
+	retryable bool
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// MarkRetryable wraps the provided error returned by a SessionStore operation,
+// marking it as retryable (e.g., a transient backend failure). See
+// IsRetryable.
+func MarkRetryable(err error) error {
+	return &classifiedError{err: err, retryable: true}
+}
+
+// MarkPermanent wraps the provided error returned by a SessionStore operation,
+// marking it as permanent (i.e., the operation will not succeed if retried).
+// See IsRetryable.
+func MarkPermanent(err error) error {
+	return &classifiedError{err: err, retryable: false}
+}
+
+// IsRetryable reports whether the SessionStore operation that returned the
+// provided (non-nil) error may succeed if retried. Errors marked via
+// MarkRetryable or MarkPermanent are classified accordingly. Otherwise,
+// ErrSessionNotFound, ErrInvalidSessionData, ErrInvalidStoredSessionData,
+// ErrUserSessionLimit, errors.ErrUnsupported, and Context cancellation and
+// deadline errors are permanent, while all other errors (including
+// ErrSessionExists, which may be resolved by retrying with a new SID) are
+// retryable.
+func IsRetryable(err error) bool {
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return ce.retryable
+	}
+	for _, perm := range []error{
+		ErrSessionNotFound,
+		ErrInvalidSessionData,
+		ErrInvalidStoredSessionData,
+		ErrUserSessionLimit,
+		errors.ErrUnsupported,
+		context.Canceled,
+		context.DeadlineExceeded,
+	} {
+		if errors.Is(err, perm) {
+			return false
+		}
+	}
+	return true
+}
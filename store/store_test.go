@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/swfrench/simple-session/store"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unknown", err: errors.New("nope"), want: true},
+		{name: "exists", err: store.ErrSessionExists, want: true},
+		{name: "not found", err: fmt.Errorf("wrapped: %w", store.ErrSessionNotFound), want: false},
+		{name: "invalid data", err: store.ErrInvalidSessionData, want: false},
+		{name: "invalid stored data", err: store.ErrInvalidStoredSessionData, want: false},
+		{name: "user session limit", err: store.ErrUserSessionLimit, want: false},
+		{name: "unsupported", err: errors.ErrUnsupported, want: false},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "marked permanent", err: store.MarkPermanent(errors.New("nope")), want: false},
+		{name: "marked retryable", err: fmt.Errorf("wrapped: %w", store.MarkRetryable(store.ErrInvalidStoredSessionData)), want: true},
+	}
+	for _, tc := range testCases {
+		if got := store.IsRetryable(tc.err); got != tc.want {
+			t.Errorf("IsRetryable(%v) for %s - got: %v want: %v", tc.err, tc.name, got, tc.want)
+		}
+	}
+	if err := store.MarkRetryable(store.ErrSessionNotFound); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("MarkRetryable() does not wrap the provided error - got: %v", err)
+	}
+}
+
+type fakeSession struct{}
+
+// baseStore implements only store.SessionStore.
+type baseStore struct{}
+
+func (baseStore) Get(context.Context, string) (*fakeSession, error)              { return nil, nil }
+func (baseStore) Set(context.Context, string, *fakeSession, time.Duration) error { return nil }
+func (baseStore) Del(context.Context, string) error                              { return nil }
+func (baseStore) UserSessions(ctx context.Context, uid string) ([]string, error) { return nil, nil }
+
+// updaterStore additionally implements store.Updater.
+type updaterStore struct {
+	baseStore
+}
+
+func (updaterStore) Update(context.Context, string, *fakeSession, time.Duration) error { return nil }
+
+// wrapperStore implements store.Updater regardless of the wrapped store.
+type wrapperStore struct {
+	updaterStore
+	s store.SessionStore[fakeSession]
+}
+
+func (ws wrapperStore) Unwrap() store.SessionStore[fakeSession] { return ws.s }
+
+func TestSupports(t *testing.T) {
+	testCases := []struct {
+		name        string
+		s           store.SessionStore[fakeSession]
+		wantUpdater bool
+	}{
+		{
+			name: "unsupported",
+			s:    baseStore{},
+		},
+		{
+			name:        "supported",
+			s:           updaterStore{},
+			wantUpdater: true,
+		},
+		{
+			name: "wrapped unsupported",
+			s:    wrapperStore{s: wrapperStore{s: baseStore{}}},
+		},
+		{
+			name:        "wrapped supported",
+			s:           wrapperStore{s: wrapperStore{s: updaterStore{}}},
+			wantUpdater: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := store.Supports[store.Updater[fakeSession]](tc.s), tc.wantUpdater; got != want {
+				t.Errorf("Supports[Updater]() returned unexpected result - got: %t want: %t", got, want)
+			}
+			// All of the above implement store.UserIndex.
+			if !store.Supports[store.UserIndex](tc.s) {
+				t.Error("Supports[UserIndex]() unexpectedly returned false")
+			}
+		})
+	}
+}
+
+// proberStore implements store.UserIndexProber.
+type proberStore struct {
+	baseStore
+	enabled bool
+}
+
+func (ps proberStore) UserIndexEnabled() bool { return ps.enabled }
+
+func TestUserIndexEnabled(t *testing.T) {
+	testCases := []struct {
+		name string
+		s    store.SessionStore[fakeSession]
+		want bool
+	}{
+		{name: "not prober", s: baseStore{}, want: true},
+		{name: "enabled", s: proberStore{enabled: true}, want: true},
+		{name: "disabled", s: proberStore{}},
+		{name: "wrapped enabled", s: wrapperStore{s: proberStore{enabled: true}}, want: true},
+		{name: "wrapped disabled", s: wrapperStore{s: wrapperStore{s: proberStore{}}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := store.UserIndexEnabled(tc.s); got != tc.want {
+				t.Errorf("UserIndexEnabled() returned unexpected result - got: %t want: %t", got, tc.want)
+			}
+		})
+	}
+}
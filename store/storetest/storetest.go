@@ -0,0 +1,369 @@
+// Package storetest provides a conformance test suite for SessionStore
+// implementations, verifying that they honor the contract documented in
+// package store.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+)
+
+// Session is the session type stored by SessionStores under test.
+type Session struct {
+	Name string `json:"name"`
+	// UID identifies the user associated with the session. SessionStores
+	// implementing store.UserIndex should be configured to index sessions by
+	// UID (e.g., via memory.Options.UserID).
+	UID     string `json:"uid,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	// Extra is used to construct sessions that cannot be serialized, in which
+	// case it is set to a value that cannot be marshalled to JSON.
+	Extra any `json:"extra,omitempty"`
+}
+
+// Factory returns a new, empty SessionStore for use by a single test, together
+// with a function that advances the notion of time used by the SessionStore to
+// expire sessions (e.g., by overriding memory.Store.Clock). The SessionStore
+// should be cleaned up via t.Cleanup, if needed.
+type Factory func(t *testing.T) (s store.SessionStore[Session], advance func(time.Duration))
+
+const largePayloadSize = 1 << 20
+
+// RunConformance runs the conformance test suite against SessionStores
+// returned by the provided Factory. In addition to the required
+// store.SessionStore methods, the optional store.Updater, store.UserIndex,
+// store.UserSessionLimiter, store.Scanner, and store.Enumerator interfaces
+// are tested if implemented.
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, Factory)
+	}{
+		{"GetNotFound", testGetNotFound},
+		{"SetGet", testSetGet},
+		{"SetExists", testSetExists},
+		{"Del", testDel},
+		{"InvalidSessionData", testInvalidSessionData},
+		{"Expiry", testExpiry},
+		{"LargePayload", testLargePayload},
+		{"ConcurrentSet", testConcurrentSet},
+		{"Update", testUpdate},
+		{"UserIndex", testUserIndex},
+		{"UserSessionLimiter", testUserSessionLimiter},
+		{"Scanner", testScanner},
+		{"Enumerator", testEnumerator},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, factory)
+		})
+	}
+}
+
+func mustSet(t *testing.T, s store.SessionStore[Session], sid string, sess *Session, ttl time.Duration) {
+	t.Helper()
+	if err := s.Set(context.Background(), sid, sess, ttl); err != nil {
+		t.Fatalf("Set(%q) returned unexpected error: %v", sid, err)
+	}
+}
+
+func checkGet(t *testing.T, s store.SessionStore[Session], sid string, want *Session) {
+	t.Helper()
+	got, err := s.Get(context.Background(), sid)
+	if err != nil {
+		t.Errorf("Get(%q) returned unexpected error: %v", sid, err)
+		return
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get(%q) returned unexpected session (-want +got):\n%s", sid, diff)
+	}
+}
+
+func checkNotFound(t *testing.T, s store.SessionStore[Session], sid string) {
+	t.Helper()
+	if _, err := s.Get(context.Background(), sid); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Get(%q) returned unexpected error - got: %v want: %v", sid, err, store.ErrSessionNotFound)
+	}
+}
+
+func testGetNotFound(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	checkNotFound(t, s, "missing")
+}
+
+func testSetGet(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	mustSet(t, s, "foo", &Session{Name: "foo"}, time.Hour)
+	mustSet(t, s, "bar", &Session{Name: "bar"}, time.Hour)
+	checkGet(t, s, "foo", &Session{Name: "foo"})
+	checkGet(t, s, "bar", &Session{Name: "bar"})
+}
+
+func testSetExists(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	mustSet(t, s, "foo", &Session{Name: "foo"}, time.Hour)
+	if err := s.Set(context.Background(), "foo", &Session{Name: "other"}, time.Hour); !errors.Is(err, store.ErrSessionExists) {
+		t.Errorf("Set() returned unexpected error for existing session - got: %v want: %v", err, store.ErrSessionExists)
+	}
+	checkGet(t, s, "foo", &Session{Name: "foo"})
+}
+
+func testDel(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	ctx := context.Background()
+	if err := s.Del(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Del() returned unexpected error for missing session - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	mustSet(t, s, "foo", &Session{Name: "foo"}, time.Hour)
+	mustSet(t, s, "bar", &Session{Name: "bar"}, time.Hour)
+	if err := s.Del(ctx, "foo"); err != nil {
+		t.Errorf("Del() returned unexpected error: %v", err)
+	}
+	checkNotFound(t, s, "foo")
+	checkGet(t, s, "bar", &Session{Name: "bar"})
+	// The SID may be reused once deleted.
+	mustSet(t, s, "foo", &Session{Name: "foo2"}, time.Hour)
+	checkGet(t, s, "foo", &Session{Name: "foo2"})
+}
+
+func testInvalidSessionData(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	// SessionStores that do not serialize sessions may accept this session,
+	// but those that do must report ErrInvalidSessionData.
+	err := s.Set(context.Background(), "foo", &Session{Name: "foo", Extra: make(chan int)}, time.Hour)
+	if err != nil && !errors.Is(err, store.ErrInvalidSessionData) {
+		t.Errorf("Set() returned unexpected error for unserializable session - got: %v want: nil or %v", err, store.ErrInvalidSessionData)
+	}
+	if err != nil {
+		checkNotFound(t, s, "foo")
+	}
+}
+
+func testExpiry(t *testing.T, factory Factory) {
+	s, advance := factory(t)
+	mustSet(t, s, "short", &Session{Name: "short"}, time.Minute)
+	mustSet(t, s, "long", &Session{Name: "long"}, time.Hour)
+	advance(30 * time.Second)
+	checkGet(t, s, "short", &Session{Name: "short"})
+	advance(time.Minute)
+	checkNotFound(t, s, "short")
+	checkGet(t, s, "long", &Session{Name: "long"})
+	if err := s.Del(context.Background(), "short"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Del() returned unexpected error for expired session - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	// The SID of an expired session may be reused.
+	mustSet(t, s, "short", &Session{Name: "short2"}, time.Minute)
+	checkGet(t, s, "short", &Session{Name: "short2"})
+}
+
+func testLargePayload(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	payload := []byte(strings.Repeat("0123456789abcdef", largePayloadSize/16))
+	mustSet(t, s, "foo", &Session{Name: "foo", Payload: payload}, time.Hour)
+	got, err := s.Get(context.Background(), "foo")
+	if err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	// Note: cmp.Diff is prohibitively slow for large payloads.
+	if got.Name != "foo" || !bytes.Equal(got.Payload, payload) {
+		t.Errorf("Get() returned unexpected session with name %q and %d byte payload", got.Name, len(got.Payload))
+	}
+}
+
+func testConcurrentSet(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	ctx := context.Background()
+	const n = 16
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Distinct SIDs must all be stored.
+			if err := s.Set(ctx, fmt.Sprintf("sid-%d", i), &Session{Name: fmt.Sprint(i)}, time.Hour); err != nil {
+				t.Errorf("Set() returned unexpected error: %v", err)
+			}
+			// Only one of the writers to a shared SID may succeed.
+			errs[i] = s.Set(ctx, "shared", &Session{Name: fmt.Sprint(i)}, time.Hour)
+		}()
+	}
+	wg.Wait()
+	var stored int
+	for _, err := range errs {
+		if err == nil {
+			stored++
+		} else if !errors.Is(err, store.ErrSessionExists) {
+			t.Errorf("Set() returned unexpected error for shared SID - got: %v want: %v", err, store.ErrSessionExists)
+		}
+	}
+	if stored != 1 {
+		t.Errorf("Set() succeeded for shared SID an unexpected number of times - got: %d want: 1", stored)
+	}
+	for i := range n {
+		checkGet(t, s, fmt.Sprintf("sid-%d", i), &Session{Name: fmt.Sprint(i)})
+	}
+}
+
+func testUpdate(t *testing.T, factory Factory) {
+	s, advance := factory(t)
+	u, ok := s.(store.Updater[Session])
+	if !ok {
+		t.Skip("store.Updater not implemented")
+	}
+	ctx := context.Background()
+	if err := u.Update(ctx, "foo", &Session{Name: "foo"}, time.Hour); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Errorf("Update() returned unexpected error for missing session - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	checkNotFound(t, s, "foo")
+	mustSet(t, s, "foo", &Session{Name: "foo"}, time.Minute)
+	if err := u.Update(ctx, "foo", &Session{Name: "foo2"}, time.Hour); err != nil {
+		t.Fatalf("Update() returned unexpected error: %v", err)
+	}
+	// Both the session and its TTL are replaced.
+	advance(30 * time.Minute)
+	checkGet(t, s, "foo", &Session{Name: "foo2"})
+	advance(time.Hour)
+	checkNotFound(t, s, "foo")
+}
+
+func testUserIndex(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	ui, ok := s.(store.UserIndex)
+	if !ok {
+		t.Skip("store.UserIndex not implemented")
+	}
+	ctx := context.Background()
+	mustSet(t, s, "c", &Session{Name: "c", UID: "alice"}, 3*time.Hour)
+	mustSet(t, s, "a", &Session{Name: "a", UID: "alice"}, time.Hour)
+	mustSet(t, s, "b", &Session{Name: "b", UID: "alice"}, 2*time.Hour)
+	mustSet(t, s, "d", &Session{Name: "d", UID: "bob"}, time.Hour)
+	mustSet(t, s, "e", &Session{Name: "e"}, time.Hour)
+	if err := s.Del(ctx, "b"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	got, err := ui.UserSessions(ctx, "alice")
+	if err != nil {
+		t.Fatalf("UserSessions() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, got); diff != "" {
+		t.Errorf("UserSessions() returned unexpected SIDs (-want +got):\n%s", diff)
+	}
+	if got, err := ui.UserSessions(ctx, "carol"); err != nil || len(got) != 0 {
+		t.Errorf("UserSessions() returned unexpected result for unknown user - got: (%v, %v) want: ([], <nil>)", got, err)
+	}
+}
+
+func testUserSessionLimiter(t *testing.T, factory Factory) {
+	s, _ := factory(t)
+	l, ok := s.(store.UserSessionLimiter[Session])
+	if !ok {
+		t.Skip("store.UserSessionLimiter not implemented")
+	}
+	ctx := context.Background()
+	for i, sid := range []string{"a", "b"} {
+		if _, err := l.SetLimited(ctx, sid, &Session{Name: sid, UID: "alice"}, time.Duration(i+1)*time.Hour, 2, false); err != nil {
+			t.Fatalf("SetLimited() returned unexpected error: %v", err)
+		}
+	}
+	if _, err := l.SetLimited(ctx, "c", &Session{Name: "c", UID: "alice"}, 3*time.Hour, 2, false); !errors.Is(err, store.ErrUserSessionLimit) {
+		t.Errorf("SetLimited() returned unexpected error - got: %v want: %v", err, store.ErrUserSessionLimit)
+	}
+	checkNotFound(t, s, "c")
+	evicted, err := l.SetLimited(ctx, "c", &Session{Name: "c", UID: "alice"}, 3*time.Hour, 2, true)
+	if err != nil {
+		t.Fatalf("SetLimited() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a"}, evicted); diff != "" {
+		t.Errorf("SetLimited() returned unexpected evicted SIDs (-want +got):\n%s", diff)
+	}
+	checkNotFound(t, s, "a")
+	checkGet(t, s, "c", &Session{Name: "c", UID: "alice"})
+	if _, err := l.SetLimited(ctx, "c", &Session{Name: "c", UID: "alice"}, time.Hour, 2, true); !errors.Is(err, store.ErrSessionExists) {
+		t.Errorf("SetLimited() returned unexpected error for existing session - got: %v want: %v", err, store.ErrSessionExists)
+	}
+}
+
+// scanAll returns all entries returned by the provided Scanner, keyed by SID.
+func scanAll(t *testing.T, sc store.Scanner[Session], count int) map[string]store.Entry[Session] {
+	t.Helper()
+	entries := make(map[string]store.Entry[Session])
+	var cursor string
+	for {
+		batch, next, err := sc.Scan(context.Background(), cursor, count)
+		if err != nil {
+			t.Fatalf("Scan() returned unexpected error: %v", err)
+		}
+		for _, e := range batch {
+			entries[e.SID] = e
+		}
+		if cursor = next; cursor == "" {
+			return entries
+		}
+	}
+}
+
+func testScanner(t *testing.T, factory Factory) {
+	s, advance := factory(t)
+	sc, ok := s.(store.Scanner[Session])
+	if !ok {
+		t.Skip("store.Scanner not implemented")
+	}
+	if got := scanAll(t, sc, 10); len(got) != 0 {
+		t.Errorf("Scan() returned unexpected entries for empty store: %v", got)
+	}
+	for i := range 25 {
+		sid := fmt.Sprintf("sid-%d", i)
+		mustSet(t, s, sid, &Session{Name: sid}, time.Hour)
+	}
+	mustSet(t, s, "expiring", &Session{Name: "expiring"}, time.Minute)
+	advance(30 * time.Minute)
+	got := scanAll(t, sc, 10)
+	if len(got) != 25 {
+		t.Errorf("Scan() returned unexpected number of entries - got: %d want: %d", len(got), 25)
+	}
+	for i := range 25 {
+		sid := fmt.Sprintf("sid-%d", i)
+		e, ok := got[sid]
+		if !ok {
+			t.Errorf("Scan() did not return SID %q", sid)
+			continue
+		}
+		if diff := cmp.Diff(&Session{Name: sid}, e.Session); diff != "" {
+			t.Errorf("Scan() returned unexpected session for SID %q (-want +got):\n%s", sid, diff)
+		}
+		if e.TTL <= 0 || e.TTL > 30*time.Minute {
+			t.Errorf("Scan() returned unexpected TTL for SID %q - got: %v want: (0, 30m]", sid, e.TTL)
+		}
+	}
+}
+
+func testEnumerator(t *testing.T, factory Factory) {
+	s, advance := factory(t)
+	e, ok := s.(store.Enumerator[Session])
+	if !ok {
+		t.Skip("store.Enumerator not implemented")
+	}
+	ctx := context.Background()
+	for i := range 5 {
+		sid := fmt.Sprintf("sid-%d", i)
+		mustSet(t, s, sid, &Session{Name: sid}, time.Hour)
+	}
+	mustSet(t, s, "expiring", &Session{Name: "expiring"}, time.Minute)
+	if err := s.Del(ctx, "sid-0"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	advance(30 * time.Minute)
+	if n, err := e.Count(ctx); err != nil || n != 4 {
+		t.Errorf("Count() returned unexpected result - got: (%d, %v) want: (4, <nil>)", n, err)
+	}
+}
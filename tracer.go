@@ -0,0 +1,44 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer creates spans for Manager operations (e.g., see tracing.NewTracer,
+// which uses OpenTelemetry).
+type Tracer interface {
+	// Start starts a span with the provided name, returning a Context
+	// carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an in-progress span created by a Tracer.
+type Span interface {
+	// SetSessionID associates the span with the session identified by the
+	// provided SID. Implementations must not record the SID itself (e.g., see
+	// tracing.HashSID).
+	SetSessionID(sid string)
+	// SetAttempts records the number of attempts made by a retried operation.
+	SetAttempts(n int)
+	// RecordRetry records that the provided attempt is about to be made,
+	// after the provided delay.
+	RecordRetry(attempt int, delay time.Duration)
+	// End records the outcome of the operation (e.g., metrics.CreateOK) and
+	// the associated error, if any, and ends the span.
+	End(outcome string, err error)
+}
+
+// nopTracer is a Tracer creating spans that record nothing.
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetSessionID(sid string)                      {}
+func (nopSpan) SetAttempts(n int)                            {}
+func (nopSpan) RecordRetry(attempt int, delay time.Duration) {}
+func (nopSpan) End(outcome string, err error)                {}
@@ -0,0 +1,249 @@
+// Package tracing provides OpenTelemetry instrumentation of session
+// operations, for use with Manager (see NewTracer) and SessionStore
+// implementations (see Store).
+//
+// Spans never carry SIDs. Where a span refers to a specific session, it
+// instead carries a truncated hash of the SID (see HashSID).
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	session "github.com/swfrench/simple-session"
+	"github.com/swfrench/simple-session/metrics"
+	"github.com/swfrench/simple-session/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of Tracers created by this
+// module.
+const ScopeName = "github.com/swfrench/simple-session"
+
+// Span attribute keys.
+const (
+	// StoreKey is the name of the SessionStore (e.g., "redis").
+	StoreKey = attribute.Key("session.store")
+	// OutcomeKey is the outcome of the operation (e.g., metrics.ClassOK for
+	// SessionStore operations, or metrics.LookupHit for session lookup).
+	OutcomeKey = attribute.Key("session.outcome")
+	// IDHashKey is the hash of the associated SID (see HashSID).
+	IDHashKey = attribute.Key("session.id_hash")
+	// AttemptsKey is the number of attempts made by a retried operation.
+	AttemptsKey = attribute.Key("session.attempts")
+	// RetryAttemptKey is the number of the upcoming attempt, on retry events.
+	RetryAttemptKey = attribute.Key("retry.attempt")
+	// RetryDelayKey is the delay prior to the upcoming attempt, on retry
+	// events.
+	RetryDelayKey = attribute.Key("retry.delay")
+)
+
+// RetryEvent is the name of span events recording a retry.
+const RetryEvent = "retry"
+
+// hashLen is the length (in bytes) of the truncated SID hash.
+const hashLen = 8
+
+// HashSID returns a truncated SHA-256 hash of the provided SID, suitable for
+// correlating spans referring to the same session without revealing the SID.
+func HashSID(sid string) string {
+	h := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(h[:hashLen])
+}
+
+// RecordRetry adds a RetryEvent to the provided span, for the provided upcoming
+// attempt made after the provided delay.
+func RecordRetry(span trace.Span, attempt int, delay time.Duration) {
+	span.AddEvent(RetryEvent, trace.WithAttributes(
+		RetryAttemptKey.Int(attempt),
+		RetryDelayKey.String(delay.String()),
+	))
+}
+
+// End ends the provided span, having set OutcomeKey to the provided outcome.
+// If err is non-nil and does not indicate an expected outcome (i.e., a
+// missing or existing session), it is recorded and the span status set to
+// Error.
+func End(span trace.Span, outcome string, err error) {
+	span.SetAttributes(OutcomeKey.String(outcome))
+	if err != nil && !errors.Is(err, store.ErrSessionNotFound) && !errors.Is(err, store.ErrSessionExists) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, outcome)
+	}
+	span.End()
+}
+
+// Tracer is a session.Tracer creating OpenTelemetry spans for Manager
+// operations (see session.Options.Tracer).
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a new Tracer creating spans using the provided
+// TracerProvider.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: tp.Tracer(ScopeName)}
+}
+
+// Start implements session.Tracer.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, session.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, managerSpan{span}
+}
+
+// managerSpan adapts a trace.Span to session.Span.
+type managerSpan struct {
+	span trace.Span
+}
+
+func (ms managerSpan) SetSessionID(sid string) {
+	ms.span.SetAttributes(IDHashKey.String(HashSID(sid)))
+}
+
+func (ms managerSpan) SetAttempts(n int) {
+	ms.span.SetAttributes(AttemptsKey.Int(n))
+}
+
+func (ms managerSpan) RecordRetry(attempt int, delay time.Duration) {
+	RecordRetry(ms.span, attempt, delay)
+}
+
+func (ms managerSpan) End(outcome string, err error) {
+	End(ms.span, outcome, err)
+}
+
+// Store is a SessionStore wrapping another SessionStore, creating a span for
+// each operation. As with metrics.Store, Store implements the optional
+// store.UserIndex, store.UserSessionLimiter, store.Updater, and
+// store.Enumerator interfaces, delegating to the wrapped SessionStore if
+// supported (see store.Supports).
+type Store[S any] struct {
+	s      store.SessionStore[S]
+	name   string
+	tracer trace.Tracer
+}
+
+// NewStore returns a new Store wrapping the provided SessionStore, creating
+// spans using the provided TracerProvider with StoreKey set to the provided
+// name (e.g., "redis").
+func NewStore[S any](s store.SessionStore[S], name string, tp trace.TracerProvider) *Store[S] {
+	return &Store[S]{
+		s:      s,
+		name:   name,
+		tracer: tp.Tracer(ScopeName),
+	}
+}
+
+// Unwrap implements store.Unwrapper.
+func (ts *Store[S]) Unwrap() store.SessionStore[S] {
+	return ts.s
+}
+
+func (ts *Store[S]) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return ts.tracer.Start(ctx, "SessionStore."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, StoreKey.String(ts.name))...))
+}
+
+func end(span trace.Span, err error) {
+	End(span, metrics.ErrorClass(err), err)
+}
+
+// Get implements store.SessionStore.
+func (ts *Store[S]) Get(ctx context.Context, sid string) (*S, error) {
+	ctx, span := ts.start(ctx, "Get", IDHashKey.String(HashSID(sid)))
+	s, err := ts.s.Get(ctx, sid)
+	end(span, err)
+	return s, err
+}
+
+// Set implements store.SessionStore.
+func (ts *Store[S]) Set(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	ctx, span := ts.start(ctx, "Set", IDHashKey.String(HashSID(sid)))
+	err := ts.s.Set(ctx, sid, s, ttl)
+	end(span, err)
+	return err
+}
+
+// Del implements store.SessionStore.
+func (ts *Store[S]) Del(ctx context.Context, sid string) error {
+	ctx, span := ts.start(ctx, "Del", IDHashKey.String(HashSID(sid)))
+	err := ts.s.Del(ctx, sid)
+	end(span, err)
+	return err
+}
+
+// Update implements store.Updater.
+func (ts *Store[S]) Update(ctx context.Context, sid string, s *S, ttl time.Duration) error {
+	ctx, span := ts.start(ctx, "Update", IDHashKey.String(HashSID(sid)))
+	var err error
+	if u, ok := ts.s.(store.Updater[S]); ok {
+		err = u.Update(ctx, sid, s, ttl)
+	} else {
+		err = store.Unsupported("store.Updater")
+	}
+	end(span, err)
+	return err
+}
+
+// SetLimited implements store.UserSessionLimiter.
+func (ts *Store[S]) SetLimited(ctx context.Context, sid string, s *S, ttl time.Duration, limit int, evict bool) ([]string, error) {
+	ctx, span := ts.start(ctx, "SetLimited", IDHashKey.String(HashSID(sid)))
+	var evicted []string
+	var err error
+	if l, ok := ts.s.(store.UserSessionLimiter[S]); ok {
+		evicted, err = l.SetLimited(ctx, sid, s, ttl, limit, evict)
+	} else {
+		err = store.Unsupported("store.UserSessionLimiter")
+	}
+	end(span, err)
+	return evicted, err
+}
+
+// UserSessions implements store.UserIndex.
+func (ts *Store[S]) UserSessions(ctx context.Context, uid string) ([]string, error) {
+	ctx, span := ts.start(ctx, "UserSessions")
+	var sids []string
+	var err error
+	if ui, ok := ts.s.(store.UserIndex); ok {
+		sids, err = ui.UserSessions(ctx, uid)
+	} else {
+		err = store.Unsupported("store.UserIndex")
+	}
+	end(span, err)
+	return sids, err
+}
+
+// Scan implements store.Scanner.
+func (ts *Store[S]) Scan(ctx context.Context, cursor string, count int) ([]store.Entry[S], string, error) {
+	ctx, span := ts.start(ctx, "Scan")
+	var entries []store.Entry[S]
+	var next string
+	var err error
+	if sc, ok := ts.s.(store.Scanner[S]); ok {
+		entries, next, err = sc.Scan(ctx, cursor, count)
+	} else {
+		err = store.Unsupported("store.Scanner")
+	}
+	end(span, err)
+	return entries, next, err
+}
+
+// Count implements store.Enumerator.
+func (ts *Store[S]) Count(ctx context.Context) (int, error) {
+	ctx, span := ts.start(ctx, "Count")
+	var n int
+	var err error
+	if e, ok := ts.s.(store.Enumerator[S]); ok {
+		n, err = e.Count(ctx)
+	} else {
+		err = store.Unsupported("store.Enumerator")
+	}
+	end(span, err)
+	return n, err
+}
@@ -0,0 +1,110 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/swfrench/simple-session/store"
+	"github.com/swfrench/simple-session/store/memory"
+	"github.com/swfrench/simple-session/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+type fakeSession struct {
+	Name string
+}
+
+// spanSummary is a comparable summary of a recorded span.
+type spanSummary struct {
+	Name       string
+	Attributes map[attribute.Key]string
+	Error      bool
+}
+
+func summarize(spans tracetest.SpanStubs) []spanSummary {
+	var ss []spanSummary
+	for _, span := range spans {
+		attrs := make(map[attribute.Key]string)
+		for _, kv := range span.Attributes {
+			attrs[kv.Key] = kv.Value.Emit()
+		}
+		ss = append(ss, spanSummary{
+			Name:       span.Name,
+			Attributes: attrs,
+			Error:      span.Status.Code == codes.Error,
+		})
+	}
+	return ss
+}
+
+func TestHashSID(t *testing.T) {
+	h := tracing.HashSID("foo")
+	if got, want := len(h), 16; got != want {
+		t.Errorf("HashSID() returned hash of unexpected length - got: %d want: %d", got, want)
+	}
+	if h == tracing.HashSID("bar") {
+		t.Errorf("HashSID() returned identical hashes for distinct SIDs: %q", h)
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	ts := tracing.NewStore[fakeSession](memory.New[fakeSession](), "memory", tp)
+
+	if err := ts.Set(ctx, "foo", &fakeSession{Name: "foo"}, time.Minute); err != nil {
+		t.Fatalf("Set() returned unexpected error: %v", err)
+	}
+	if _, err := ts.Get(ctx, "foo"); err != nil {
+		t.Fatalf("Get() returned unexpected error: %v", err)
+	}
+	if err := ts.Del(ctx, "foo"); err != nil {
+		t.Fatalf("Del() returned unexpected error: %v", err)
+	}
+	if _, err := ts.Get(ctx, "foo"); !errors.Is(err, store.ErrSessionNotFound) {
+		t.Fatalf("Get() returned unexpected error - got: %v want: %v", err, store.ErrSessionNotFound)
+	}
+	// The memory Store is not configured with a user index.
+	if _, err := ts.SetLimited(ctx, "foo", &fakeSession{Name: "foo"}, time.Minute, 1, false); err == nil {
+		t.Fatal("SetLimited() unexpectedly succeeded")
+	}
+
+	hash := tracing.HashSID("foo")
+	attrs := func(outcome string) map[attribute.Key]string {
+		return map[attribute.Key]string{
+			tracing.StoreKey:   "memory",
+			tracing.IDHashKey:  hash,
+			tracing.OutcomeKey: outcome,
+		}
+	}
+	want := []spanSummary{
+		{Name: "SessionStore.Set", Attributes: attrs("ok")},
+		{Name: "SessionStore.Get", Attributes: attrs("ok")},
+		{Name: "SessionStore.Del", Attributes: attrs("ok")},
+		{Name: "SessionStore.Get", Attributes: attrs("not_found")},
+		{Name: "SessionStore.SetLimited", Attributes: attrs("other"), Error: true},
+	}
+	if diff := cmp.Diff(want, summarize(exp.GetSpans())); diff != "" {
+		t.Errorf("Unexpected spans (+got, -want):\n%s", diff)
+	}
+}
+
+func TestStoreSupports(t *testing.T) {
+	ts := tracing.NewStore[fakeSession](struct {
+		store.SessionStore[fakeSession]
+	}{memory.New[fakeSession]()}, "memory", noop.NewTracerProvider())
+	if store.Supports[store.Updater[fakeSession]](ts) {
+		t.Error("Supports() unexpectedly returned true for Updater not implemented by the wrapped store")
+	}
+	if !store.Supports[store.Updater[fakeSession]](tracing.NewStore[fakeSession](memory.New[fakeSession](), "memory", noop.NewTracerProvider())) {
+		t.Error("Supports() unexpectedly returned false for Updater implemented by the wrapped store")
+	}
+}